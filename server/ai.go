@@ -0,0 +1,77 @@
+package main
+
+import (
+    "time"
+
+    "github.com/ThePrimeagen/twitch-ext-pong/server/game"
+)
+
+// AI difficulty unless configured otherwise
+const (
+    // Pixels per second the AI moves its paddle
+    DefaultAISpeed = 360
+    // How long the AI takes to notice where the ball went
+    DefaultAIReaction = 150 * time.Millisecond
+)
+
+// aiPaddle steers a paddle nobody is playing. It only looks at the ball
+// once per reaction period and chases what it saw, so it can be beaten.
+type aiPaddle struct {
+    // Seconds until the next look at the ball
+    wait float64
+    // Paddle Y the AI is heading for
+    aim float64
+}
+
+// Move paddle's target toward the ball and return it. Caller must hold the
+// room lock.
+func (a *aiPaddle) steer(dt float64, cfg Config, paddle game.PaddlePosition, balls []game.Ball) float64 {
+    a.wait -= dt
+    if a.wait <= 0 {
+        a.wait = cfg.AIReaction.Seconds()
+        a.aim = aiAim(cfg.Canvas, paddle, balls)
+    }
+    return game.Approach(paddle.Y, a.aim, float64(cfg.AISpeed)*dt)
+}
+
+// Where paddle should be to meet the closest ball heading its way, back to
+// the middle when nothing is coming
+func aiAim(c game.Canvas, paddle game.PaddlePosition, balls []game.Ball) float64 {
+    height := paddle.Size()
+    y := c.Height / 2
+    closest := c.Width
+    for _, ball := range balls {
+        var distance float64
+        if paddle.Side == "left" {
+            if ball.VX >= 0 {
+                continue
+            }
+            distance = ball.X
+        } else {
+            if ball.VX <= 0 {
+                continue
+            }
+            distance = c.Width - ball.X
+        }
+        if distance < closest {
+            closest = distance
+            y = ball.Y
+        }
+    }
+    return max(0, min(c.Height-height, y-height/2))
+}
+
+// Let the AI drive every paddle without a human on it. Caller must hold
+// the lock.
+func (r *Room) steerAI(dt float64) {
+    cfg := r.cfg
+    if !cfg.AIEnabled {
+        return
+    }
+    if r.controllers("left") == 0 {
+        r.gameState.LeftTarget = r.ai["left"].steer(dt, cfg, r.gameState.LeftPaddle, r.gameState.Balls)
+    }
+    if r.controllers("right") == 0 {
+        r.gameState.RightTarget = r.ai["right"].steer(dt, cfg, r.gameState.RightPaddle, r.gameState.Balls)
+    }
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+    "testing"
+
+    "github.com/ThePrimeagen/twitch-ext-pong/server/game"
+)
+
+func TestAIPaddleChasesBall(t *testing.T) {
+    cfg := testConfig()
+    cfg.AIEnabled = true
+    ts := newTestServer(t, cfg)
+    room := ts.Server.room("ai")
+
+    room.Lock()
+    start := room.gameState.RightPaddle.Y
+    room.gameState.Countdown = 0
+    room.gameState.Balls = []game.Ball{{X: 100, Y: 60, VX: 300}}
+    room.Unlock()
+
+    // Up toward the ball, a paddle height is plenty to tell it moved
+    eventually(t, func() bool {
+        return room.snapshot().RightPaddle.Y <= start-game.PaddleHeight
+    })
+}
+
+func TestAIAim(t *testing.T) {
+    c := game.DefaultCanvas
+    right := game.CenteredPaddle(c, "right", game.PaddleHeight)
+    for _, tc := range []struct {
+        name  string
+        balls []game.Ball
+        want  float64
+    }{
+        {"nothing coming", []game.Ball{{X: 400, Y: 100, VX: -300}}, 250},
+        {"centers on the ball", []game.Ball{{X: 400, Y: 200, VX: 300}}, 150},
+        {"closest ball wins", []game.Ball{{X: 100, Y: 500, VX: 300}, {X: 700, Y: 300, VX: 300}}, 250},
+        {"stays on the canvas", []game.Ball{{X: 400, Y: 590, VX: 300}}, 500},
+    } {
+        if got := aiAim(c, right, tc.balls); got != tc.want {
+            t.Errorf("%s: aiAim = %v, want %v", tc.name, got, tc.want)
+        }
+    }
+}
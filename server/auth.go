@@ -0,0 +1,89 @@
+package main
+
+import (
+    "crypto/hmac"
+    "crypto/sha256"
+    "encoding/base64"
+    "encoding/json"
+    "errors"
+    "fmt"
+    "net/http"
+    "strings"
+    "time"
+)
+
+var ErrInvalidToken = errors.New("invalid token")
+
+// TwitchClaims are the parts of the extension JWT we care about
+type TwitchClaims struct {
+    Exp          int64  `json:"exp"`
+    OpaqueUserID string `json:"opaque_user_id"`
+    UserID       string `json:"user_id"`
+    ChannelID    string `json:"channel_id"`
+    Role         string `json:"role"`
+}
+
+// Privileged reports whether the viewer can run the game, which Twitch
+// grants to the broadcaster and their moderators
+func (c TwitchClaims) Privileged() bool {
+    return c.Role == "broadcaster" || c.Role == "moderator"
+}
+
+// verifyTwitchJWT checks the HS256 signature of a Twitch extension JWT
+// against the extension secret and returns its claims
+func verifyTwitchJWT(token string, secret []byte, now time.Time) (TwitchClaims, error) {
+    var claims TwitchClaims
+
+    parts := strings.Split(token, ".")
+    if len(parts) != 3 {
+        return claims, fmt.Errorf("%w: malformed", ErrInvalidToken)
+    }
+
+    var header struct {
+        Alg string `json:"alg"`
+    }
+    headerJSON, err := base64.RawURLEncoding.DecodeString(parts[0])
+    if err != nil || json.Unmarshal(headerJSON, &header) != nil {
+        return claims, fmt.Errorf("%w: bad header", ErrInvalidToken)
+    }
+    if header.Alg != "HS256" {
+        return claims, fmt.Errorf("%w: unsupported alg %q", ErrInvalidToken, header.Alg)
+    }
+
+    mac := hmac.New(sha256.New, secret)
+    mac.Write([]byte(parts[0] + "." + parts[1]))
+    signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+    if err != nil || !hmac.Equal(signature, mac.Sum(nil)) {
+        return claims, fmt.Errorf("%w: bad signature", ErrInvalidToken)
+    }
+
+    payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+    if err != nil || json.Unmarshal(payload, &claims) != nil {
+        return claims, fmt.Errorf("%w: bad payload", ErrInvalidToken)
+    }
+    if claims.Exp != 0 && now.Unix() >= claims.Exp {
+        return claims, fmt.Errorf("%w: expired", ErrInvalidToken)
+    }
+    if claims.ChannelID == "" || claims.OpaqueUserID == "" {
+        return claims, fmt.Errorf("%w: missing channel_id or opaque_user_id", ErrInvalidToken)
+    }
+    return claims, nil
+}
+
+// tokenFromRequest pulls the JWT from the Sec-WebSocket-Protocol header,
+// which is the only header browsers let us set on a websocket, or from the
+// token query param. fromProtocol is true when it came from the header, in
+// which case it has to be echoed back for the browser to accept the upgrade.
+func tokenFromRequest(r *http.Request) (token string, fromProtocol bool) {
+    for _, protocol := range strings.Split(r.Header.Get("Sec-WebSocket-Protocol"), ",") {
+        protocol = strings.TrimSpace(protocol)
+        // Versions like pong.v1.msgpack have dots too
+        if strings.HasPrefix(protocol, protocolPrefix) {
+            continue
+        }
+        if strings.Count(protocol, ".") == 2 {
+            return protocol, true
+        }
+    }
+    return r.URL.Query().Get("token"), false
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+    "crypto/hmac"
+    "crypto/sha256"
+    "encoding/base64"
+    "encoding/json"
+    "net/http/httptest"
+    "testing"
+)
+
+// Secret the test servers verify tokens with
+var testSecret = []byte("test-secret")
+
+// signTestJWT fakes the extension JWT Twitch would hand claims' viewer
+func signTestJWT(t *testing.T, claims TwitchClaims) string {
+    t.Helper()
+    payload, err := json.Marshal(claims)
+    if err != nil {
+        t.Fatalf("encode claims: %v", err)
+    }
+    unsigned := base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"HS256","typ":"JWT"}`)) +
+        "." + base64.RawURLEncoding.EncodeToString(payload)
+    mac := hmac.New(sha256.New, testSecret)
+    mac.Write([]byte(unsigned))
+    return unsigned + "." + base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// dialAs connects with a token for a viewer with role in channel
+func dialAs(t *testing.T, ts *testServer, channel, user, role string) *testClient {
+    t.Helper()
+    token := signTestJWT(t, TwitchClaims{ChannelID: channel, OpaqueUserID: user, Role: role})
+    c := ts.dial(t, "token="+token)
+    c.expect(TypeInitialState)
+    return c
+}
+
+func TestTokenFromRequestSkipsVersions(t *testing.T) {
+    const jwt = "aaa.bbb.ccc"
+    for _, tc := range []struct {
+        name         string
+        protocols    string
+        query        string
+        want         string
+        fromProtocol bool
+    }{
+        {"token in protocol", ProtocolV1MsgPack + ", " + jwt, "", jwt, true},
+        {"msgpack version is not a token", ProtocolV1MsgPack, "?token=" + jwt, jwt, false},
+        {"query only", "", "?token=" + jwt, jwt, false},
+    } {
+        t.Run(tc.name, func(t *testing.T) {
+            r := httptest.NewRequest("GET", "/ws"+tc.query, nil)
+            if tc.protocols != "" {
+                r.Header.Set("Sec-WebSocket-Protocol", tc.protocols)
+            }
+            token, fromProtocol := tokenFromRequest(r)
+            if token != tc.want || fromProtocol != tc.fromProtocol {
+                t.Fatalf("got %q, %v, want %q, %v", token, fromProtocol, tc.want, tc.fromProtocol)
+            }
+        })
+    }
+}
+
+func TestResetGameNeedsPrivilege(t *testing.T) {
+    cfg := testConfig()
+    cfg.ExtensionSecret = testSecret
+    ts := newTestServer(t, cfg)
+    mod := dialAs(t, ts, "authz", "U1", "moderator")
+    viewer := dialAs(t, ts, "authz", "U2", "viewer")
+    room := ts.room(t, "authz")
+    room.Lock()
+    room.gameState.LeftScore = 3
+    room.Unlock()
+
+    viewer.send(TypeResetGame, nil)
+    viewer.expectError(ErrCodeForbidden)
+    if got := room.snapshot().LeftScore; got != 3 {
+        t.Fatalf("viewer reset the score to %d", got)
+    }
+
+    mod.send(TypeResetGame, nil)
+    reset := decode[InitialState](t, mod.expect(TypeInitialState))
+    if reset.LeftScore != 0 {
+        t.Fatalf("score after reset = %d, want 0", reset.LeftScore)
+    }
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+    "fmt"
+    "time"
+
+    "github.com/gorilla/websocket"
+    "golang.org/x/exp/slog"
+)
+
+// FullMode decides how connections past the global cap are turned away
+type FullMode string
+
+const (
+    // Answer the upgrade request with 503, cheapest for the server
+    FullModeReject FullMode = "reject"
+    // Upgrade, send a SERVER_FULL error and close, so extension frontends
+    // that can't see HTTP status codes can tell viewers why
+    FullModeMessage FullMode = "message"
+)
+
+// parseFullMode reads a full mode, falling back to reject when empty
+func parseFullMode(v string) (FullMode, error) {
+    switch mode := FullMode(v); mode {
+    case "":
+        return FullModeReject, nil
+    case FullModeReject, FullModeMessage:
+        return mode, nil
+    }
+    return "", fmt.Errorf("invalid full mode %q: must be %q or %q", v, FullModeReject, FullModeMessage)
+}
+
+// Tell a freshly upgraded connection the server is full and close it
+func rejectFull(conn *websocket.Conn) {
+    defer conn.Close()
+
+    msg, err := NewMessage(TypeError, ErrorPayload{
+        Code:    ErrCodeServerFull,
+        Message: "the server is full, try again later",
+    })
+    if err != nil {
+        slog.Error("Failed to build server full error",
+            "error", err,
+            "timestamp", time.Now().Format(time.RFC3339))
+        return
+    }
+    // Nothing else writes to this connection yet, no mutex needed
+    conn.SetWriteDeadline(time.Now().Add(WriteTimeout))
+    if err := conn.WriteJSON(msg); err != nil {
+        return
+    }
+    closeMsg := websocket.FormatCloseMessage(websocket.CloseTryAgainLater, "server full")
+    conn.WriteControl(websocket.CloseMessage, closeMsg, time.Now().Add(WriteTimeout))
+}
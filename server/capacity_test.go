@@ -0,0 +1,61 @@
+package main
+
+import (
+    "net/http"
+    "testing"
+
+    "github.com/gorilla/websocket"
+)
+
+func TestConnectionCapRejects(t *testing.T) {
+    cfg := testConfig()
+    cfg.MaxConnections = 1
+    ts := newTestServer(t, cfg)
+    ts.dial(t, "channel=full").expect(TypeInitialState)
+
+    _, resp, err := ts.dialWith(t, "channel=full", nil)
+    if err == nil {
+        t.Fatalf("second connection got in past a cap of 1")
+    }
+    if resp == nil || resp.StatusCode != http.StatusServiceUnavailable {
+        t.Fatalf("second connection: %v, want status %d", err, http.StatusServiceUnavailable)
+    }
+    if got := ts.connectionCount.Load(); got != 1 {
+        t.Fatalf("connection count = %d, want 1", got)
+    }
+}
+
+func TestConnectionCapMessage(t *testing.T) {
+    cfg := testConfig()
+    cfg.MaxConnections = 1
+    cfg.FullMode = FullModeMessage
+    ts := newTestServer(t, cfg)
+    ts.dial(t, "channel=full").expect(TypeInitialState)
+
+    c := ts.dial(t, "channel=full")
+    c.expectError(ErrCodeServerFull)
+    if err := c.expectClosed(); !websocket.IsCloseError(err, websocket.CloseTryAgainLater) {
+        t.Fatalf("closed with %v, want close code %d", err, websocket.CloseTryAgainLater)
+    }
+    eventually(t, func() bool {
+        return ts.connectionCount.Load() == 1
+    })
+}
+
+func TestParseFullMode(t *testing.T) {
+    for _, tc := range []struct {
+        v       string
+        want    FullMode
+        wantErr bool
+    }{
+        {"", FullModeReject, false},
+        {"reject", FullModeReject, false},
+        {"message", FullModeMessage, false},
+        {"queue", "", true},
+    } {
+        got, err := parseFullMode(tc.v)
+        if got != tc.want || (err != nil) != tc.wantErr {
+            t.Errorf("parseFullMode(%q) = %q, %v, want %q, error %v", tc.v, got, err, tc.want, tc.wantErr)
+        }
+    }
+}
@@ -0,0 +1,34 @@
+package main
+
+import (
+    "strings"
+    "unicode"
+)
+
+// Chat settings
+const (
+    // Longest chat message in runes, the rest is cut off
+    MaxChatRunes = 200
+    // Chat messages a connection may send per second
+    ChatRate = 1
+    // Chat messages a connection may send in a burst
+    ChatBurst = 5
+)
+
+// sanitizeChat strips control characters and surrounding whitespace and
+// truncates text to MaxChatRunes
+func sanitizeChat(text string) string {
+    var b strings.Builder
+    runes := 0
+    for _, r := range strings.TrimSpace(text) {
+        if unicode.IsControl(r) {
+            continue
+        }
+        if runes == MaxChatRunes {
+            break
+        }
+        b.WriteRune(r)
+        runes++
+    }
+    return strings.TrimSpace(b.String())
+}
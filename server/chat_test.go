@@ -0,0 +1,59 @@
+package main
+
+import (
+    "strings"
+    "testing"
+    "time"
+    "unicode/utf8"
+)
+
+func TestSanitizeChat(t *testing.T) {
+    long := strings.Repeat("é", MaxChatRunes+50)
+    for _, tc := range []struct {
+        name, text, want string
+    }{
+        {"plain", "gg", "gg"},
+        {"trimmed", "  gg wp \n", "gg wp"},
+        {"control characters", "g\x00g\x1b", "gg"},
+        {"truncated", long, strings.Repeat("é", MaxChatRunes)},
+        {"only whitespace", " \t ", ""},
+    } {
+        got := sanitizeChat(tc.text)
+        if got != tc.want {
+            t.Errorf("%s: sanitizeChat(%q) = %q, want %q", tc.name, tc.text, got, tc.want)
+        }
+        if n := utf8.RuneCountInString(got); n > MaxChatRunes {
+            t.Errorf("%s: %d runes, want at most %d", tc.name, n, MaxChatRunes)
+        }
+    }
+}
+
+func TestChatRelayedAndRateLimited(t *testing.T) {
+    ts := newTestServer(t, testConfig())
+    c := ts.dial(t, "channel=chat")
+    c.expect(TypeInitialState)
+
+    for i := 0; i < ChatBurst+1; i++ {
+        c.send(TypeChat, Chat{Text: strings.Repeat("a", MaxChatRunes+1)})
+    }
+
+    // Relays go through the hub and errors straight to the client, so
+    // they can arrive in any order
+    chats, limited := 0, 0
+    for chats < ChatBurst || limited < 1 {
+        msg := c.receive()
+        switch msg.Type {
+        case TypeChat:
+            chats++
+            if got := decode[Chat](t, msg); utf8.RuneCountInString(got.Text) != MaxChatRunes {
+                t.Fatalf("relayed %d runes, want %d", utf8.RuneCountInString(got.Text), MaxChatRunes)
+            }
+        case TypeError:
+            limited++
+            if got := decode[ErrorPayload](t, msg); got.Code != ErrCodeRateLimited {
+                t.Fatalf("error code = %q, want %q", got.Code, ErrCodeRateLimited)
+            }
+        }
+    }
+    c.expectNone(TypeChat, 100*time.Millisecond)
+}
@@ -0,0 +1,308 @@
+package main
+
+import (
+    "context"
+    "crypto/rand"
+    "fmt"
+    "strconv"
+    "sync"
+    "sync/atomic"
+    "time"
+
+    "github.com/gorilla/websocket"
+    "golang.org/x/exp/slog"
+)
+
+// Keepalive settings
+const (
+    // How often we ping each connection
+    PingInterval = 30 * time.Second
+    // How long a connection may go without a pong before we drop it
+    PongTimeout = 60 * time.Second
+    // Weight of the newest sample in the RTT moving average
+    RTTSmoothing = 0.2
+)
+
+// Outbound settings
+const (
+    // Messages queued per connection before it counts as a slow consumer
+    SendBufferSize = 256
+    // How long a single write may take
+    WriteTimeout = 10 * time.Second
+    // Most messages sent in one batch frame
+    MaxBatchSize = 64
+)
+
+// ClientRole is whether a connection plays or only watches
+type ClientRole string
+
+const (
+    RolePlayer    ClientRole = "player"
+    RoleSpectator ClientRole = "spectator"
+)
+
+// parseClientRole turns a requested role into a ClientRole, anything but
+// spectator plays
+func parseClientRole(v string) ClientRole {
+    if ClientRole(v) == RoleSpectator {
+        return RoleSpectator
+    }
+    return RolePlayer
+}
+
+// Client holds everything we know about a single connection
+type Client struct {
+    // Unique per connection, unlike the remote address behind a proxy
+    id   string
+    // Client IP, from the proxy headers when we trust them
+    addr string
+    conn *websocket.Conn
+    // gorilla/websocket allows only one concurrent writer per connection,
+    // so every write goes through this mutex
+    writeMu sync.Mutex
+    // Outbound messages, drained by writeLoop so a slow socket only holds
+    // up itself
+    send chan Message
+    // Who this is according to their verified JWT
+    identity TwitchClaims
+    // Message format version negotiated on upgrade, set once before the
+    // read loop starts
+    protocol string
+    // Encoding the protocol speaks, JSON until set alongside protocol
+    codec Codec
+    // Send whatever is queued as one batch frame instead of a frame per
+    // message, set once before the write loop starts
+    batch bool
+    // Presented on a new connection to get this one's team back, set once
+    // before join. Empty when reconnecting is off.
+    reconnectToken string
+    // Room the connection joined, set once on join
+    room *Room
+    // Player or spectator. Protected by the room mutex.
+    role ClientRole
+    // Team this connection plays for, empty until assigned. Protected by
+    // the room mutex.
+    team string
+    // When the client was last put on a team, auto balance moves the
+    // newest first. Protected by the room mutex.
+    assignedAt time.Time
+    // Last paddle update applied, or when the client started controlling a
+    // paddle. Protected by the room mutex.
+    lastInput time.Time
+    // Limits paddle updates, only touched by the read loop
+    paddleLimiter *RateLimiter
+    // Limits chat, only touched by the read loop
+    chatLimiter *RateLimiter
+    // Limits state requests, only touched by the read loop
+    stateLimiter *RateLimiter
+    // Highest paddle update seq applied, only touched by the read loop
+    lastSeq uint64
+    // Newest paddle update client timestamp applied, only touched by the
+    // read loop
+    lastTimestamp int64
+    // Smoothed ping round trip in nanoseconds, 0 until the first pong
+    rtt atomic.Int64
+    // Unix nanoseconds of the last message read, or of the connect
+    lastActivity atomic.Int64
+}
+
+func NewClient(conn *websocket.Conn, identity TwitchClaims, role ClientRole, paddleRate int) *Client {
+    return &Client{
+        id:            newConnectionID(),
+        conn:          conn,
+        identity:      identity,
+        role:          role,
+        codec:         JSONCodec{},
+        send:          make(chan Message, SendBufferSize),
+        paddleLimiter: NewRateLimiter(float64(paddleRate), paddleRate),
+        chatLimiter:   NewRateLimiter(ChatRate, ChatBurst),
+        stateLimiter:  NewRateLimiter(StateRequestRate, StateRequestBurst),
+    }
+}
+
+// newConnectionID returns a random version 4 UUID
+func newConnectionID() string {
+    var b [16]byte
+    if _, err := rand.Read(b[:]); err != nil {
+        // crypto/rand doesn't fail on any platform we run on, but an id
+        // that isn't unique beats no connection
+        return fmt.Sprintf("conn-%d", time.Now().UnixNano())
+    }
+    b[6] = b[6]&0x0f | 0x40
+    b[8] = b[8]&0x3f | 0x80
+    return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:])
+}
+
+// Write encodes msg with the client's codec and serializes writes to the
+// underlying connection
+func (c *Client) Write(msg Message) error {
+    data, err := c.codec.Encode(msg)
+    if err != nil {
+        return err
+    }
+    c.writeMu.Lock()
+    defer c.writeMu.Unlock()
+    c.conn.SetWriteDeadline(time.Now().Add(WriteTimeout))
+    return c.conn.WriteMessage(c.codec.FrameType(), data)
+}
+
+// Queue hands msg to the writer without blocking. A client whose buffer is
+// full can't keep up, so its connection is closed and false returned.
+func (c *Client) Queue(msg Message) bool {
+    select {
+    case c.send <- msg:
+        return true
+    default:
+        slog.Warn("Closing slow consumer",
+            "addr", c.addr,
+            "conn_id", c.id,
+            "buffer_size", SendBufferSize,
+            "timestamp", time.Now().Format(time.RFC3339))
+        // Unblocks the read loop, which cleans up
+        c.conn.Close()
+        return false
+    }
+}
+
+// Write queued messages until ctx is cancelled
+func (c *Client) writeLoop(ctx context.Context) {
+    for {
+        select {
+        case <-ctx.Done():
+            return
+        case msg := <-c.send:
+            if c.batch {
+                msg = c.collectBatch(msg)
+            }
+            if err := c.Write(msg); err != nil {
+                slog.Debug("Failed to write message",
+                    "error", err,
+                    "type", msg.Type,
+                    "addr", c.addr,
+                    "conn_id", c.id,
+                    "timestamp", time.Now().Format(time.RFC3339))
+                c.conn.Close()
+                return
+            }
+        }
+    }
+}
+
+// Wrap first and whatever else is already queued into one batch message.
+// A lone message goes out as is.
+func (c *Client) collectBatch(first Message) Message {
+    batch := []Message{first}
+    for len(batch) < MaxBatchSize {
+        select {
+        case msg := <-c.send:
+            batch = append(batch, msg)
+            continue
+        default:
+        }
+        break
+    }
+    if len(batch) == 1 {
+        return first
+    }
+
+    msg, err := NewMessage(TypeBatch, batch)
+    if err != nil {
+        slog.Error("Failed to build batch",
+            "error", err,
+            "addr", c.addr,
+            "conn_id", c.id,
+            "timestamp", time.Now().Format(time.RFC3339))
+        return first
+    }
+    return msg
+}
+
+// Ping the connection every PingInterval until ctx is cancelled. Pongs are
+// handled by the read loop, which extends the read deadline.
+func (c *Client) pingLoop(ctx context.Context) {
+    ticker := time.NewTicker(PingInterval)
+    defer ticker.Stop()
+
+    for {
+        select {
+        case <-ctx.Done():
+            return
+        case <-ticker.C:
+            // WriteControl is safe to call alongside other writes. The
+            // pong echoes the send time back so we can measure the RTT.
+            now := time.Now()
+            payload := []byte(strconv.FormatInt(now.UnixNano(), 10))
+            if err := c.conn.WriteControl(websocket.PingMessage, payload, now.Add(PongTimeout)); err != nil {
+                slog.Debug("Failed to send ping",
+                    "error", err,
+                    "addr", c.addr,
+                    "conn_id", c.id,
+                    "timestamp", time.Now().Format(time.RFC3339))
+                return
+            }
+        }
+    }
+}
+
+// Fold the round trip of the ping a pong answers into the average. Pongs
+// that don't carry one of our timestamps are ignored.
+func (c *Client) recordPong(appData string, now time.Time) {
+    sent, err := strconv.ParseInt(appData, 10, 64)
+    if err != nil {
+        return
+    }
+    sample := now.Sub(time.Unix(0, sent))
+    if sample < 0 || sample > PongTimeout {
+        return
+    }
+    // Only the read loop writes, no need for a compare and swap
+    prev := c.rtt.Load()
+    if prev == 0 {
+        c.rtt.Store(int64(sample))
+        return
+    }
+    c.rtt.Store(int64(RTTSmoothing*float64(sample) + (1-RTTSmoothing)*float64(prev)))
+}
+
+// RTT is the smoothed ping round trip, 0 until the first pong
+func (c *Client) RTT() time.Duration {
+    return time.Duration(c.rtt.Load())
+}
+
+// Mark the connection as active, called by the read loop
+func (c *Client) touch(now time.Time) {
+    c.lastActivity.Store(now.UnixNano())
+}
+
+// Whether the connection has sent nothing for staleAfter
+func (c *Client) stale(now time.Time, staleAfter time.Duration) bool {
+    return now.Sub(time.Unix(0, c.lastActivity.Load())) >= staleAfter
+}
+
+// Name shown to other viewers, the opaque id from the verified JWT
+func (c *Client) displayName() string {
+    if c.identity.OpaqueUserID == "" {
+        return "anonymous"
+    }
+    return c.identity.OpaqueUserID
+}
+
+// Send builds a message of type t around v and queues it for this client
+func (c *Client) Send(t MessageType, v any) {
+    msg, err := NewMessage(t, v)
+    if err != nil {
+        slog.Error("Failed to build message",
+            "error", err,
+            "type", t,
+            "addr", c.addr,
+            "conn_id", c.id,
+            "timestamp", time.Now().Format(time.RFC3339))
+        return
+    }
+    c.Queue(msg)
+}
+
+// SendError tells this client why its last message was dropped
+func (c *Client) SendError(code ErrorCode, message string) {
+    c.Send(TypeError, ErrorPayload{Code: code, Message: message})
+}
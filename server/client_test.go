@@ -0,0 +1,118 @@
+package main
+
+import (
+    "strconv"
+    "sync"
+    "testing"
+    "time"
+
+    "github.com/ThePrimeagen/twitch-ext-pong/server/game"
+)
+
+// The server side of the only connection in room
+func onlyClient(t *testing.T, room *Room) *Client {
+    t.Helper()
+    var client *Client
+    eventually(t, func() bool {
+        room.RLock()
+        defer room.RUnlock()
+        for c := range room.connections {
+            client = c
+        }
+        return len(room.connections) == 1
+    })
+    return client
+}
+
+func TestConcurrentWrites(t *testing.T) {
+    ts := newTestServer(t, testConfig())
+    c := ts.dial(t, "channel=writes")
+    room := ts.room(t, "writes")
+    client := onlyClient(t, room)
+
+    const writers = 50
+    msg, err := NewMessage(TypeChat, Chat{Text: "hi"})
+    if err != nil {
+        t.Fatal(err)
+    }
+    var wg sync.WaitGroup
+    for i := 0; i < writers; i++ {
+        wg.Add(1)
+        go func() {
+            defer wg.Done()
+            // Direct writes race the write loop draining broadcasts
+            room.broadcast(msg)
+            if err := client.Write(msg); err != nil {
+                t.Errorf("write: %v", err)
+            }
+        }()
+    }
+    wg.Wait()
+
+    // Frames interleaved by racing writers wouldn't parse
+    for i := 0; i < 2*writers; i++ {
+        c.expect(TypeChat)
+    }
+}
+
+func TestCollectBatch(t *testing.T) {
+    client := newBareClient()
+    first, err := NewMessage(TypeStateUpdate, game.State{LeftScore: 1})
+    if err != nil {
+        t.Fatal(err)
+    }
+    second, err := NewMessage(TypeStateUpdate, game.State{LeftScore: 2})
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    // Nothing else queued, the message goes out alone
+    if got := client.collectBatch(first); got.Type != TypeStateUpdate {
+        t.Fatalf("lone message sent as %q, want %q", got.Type, TypeStateUpdate)
+    }
+
+    // Two changes in one tick, the write loop took the first one already
+    client.Queue(second)
+    got := client.collectBatch(first)
+    if got.Type != TypeBatch {
+        t.Fatalf("sent as %q, want %q", got.Type, TypeBatch)
+    }
+    batch := decode[[]Message](t, got)
+    if len(batch) != 2 {
+        t.Fatalf("batch of %d, want 2", len(batch))
+    }
+    for i, want := range []int{1, 2} {
+        if score := decode[game.State](t, batch[i]).LeftScore; score != want {
+            t.Fatalf("batch[%d] left score = %d, want %d", i, score, want)
+        }
+    }
+}
+
+func TestRecordPong(t *testing.T) {
+    client := newBareClient()
+    start := time.Now()
+    pong := func(sent time.Time, delay time.Duration) {
+        client.recordPong(strconv.FormatInt(sent.UnixNano(), 10), sent.Add(delay))
+    }
+
+    if client.RTT() != 0 {
+        t.Fatalf("RTT before any pong = %s, want 0", client.RTT())
+    }
+    pong(start, 100*time.Millisecond)
+    if got := client.RTT(); got != 100*time.Millisecond {
+        t.Fatalf("first RTT = %s, want 100ms", got)
+    }
+    // Smoothed, a 200ms sample moves the average a fifth of the way
+    pong(start, 200*time.Millisecond)
+    if got := client.RTT(); got != 120*time.Millisecond {
+        t.Fatalf("smoothed RTT = %s, want 120ms", got)
+    }
+
+    // Garbage and impossible samples leave it alone
+    client.recordPong("not a timestamp", start)
+    pong(start, -time.Second)
+    pong(start, PongTimeout+time.Second)
+    if got := client.RTT(); got != 120*time.Millisecond {
+        t.Fatalf("RTT after bad pongs = %s, want 120ms", got)
+    }
+}
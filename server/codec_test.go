@@ -0,0 +1,122 @@
+package main
+
+import (
+    "encoding/json"
+    "testing"
+
+    "github.com/ThePrimeagen/twitch-ext-pong/server/game"
+)
+
+// A state frame like the game loop sends every tick
+func sampleStateMessage(t testing.TB) Message {
+    t.Helper()
+    state := game.State{
+        LeftPaddle:  game.PaddlePosition{Y: 250, Side: "left", Height: 100, VY: -120},
+        RightPaddle: game.PaddlePosition{Y: 310.5, Side: "right", Height: 100},
+        Balls:       []game.Ball{{ID: 1, X: 400.25, Y: 300, VX: -315, VY: 42.5}},
+        LeftScore:   3,
+        RightScore:  7,
+    }
+    msg, err := NewMessage(TypeStateUpdate, state)
+    if err != nil {
+        t.Fatalf("NewMessage: %v", err)
+    }
+    msg.Frame = 42
+    return msg
+}
+
+func TestMsgPackCodecRoundTrip(t *testing.T) {
+    codec := MsgPackCodec{}
+    msg := sampleStateMessage(t)
+
+    data, err := codec.Encode(msg)
+    if err != nil {
+        t.Fatalf("Encode: %v", err)
+    }
+    got, err := codec.Decode(data)
+    if err != nil {
+        t.Fatalf("Decode: %v", err)
+    }
+    if got.Type != msg.Type || got.Frame != msg.Frame {
+        t.Fatalf("got type %q frame %d, want %q frame %d", got.Type, got.Frame, msg.Type, msg.Frame)
+    }
+
+    var want, have game.State
+    if err := json.Unmarshal(msg.Payload, &want); err != nil {
+        t.Fatalf("unmarshal sent payload: %v", err)
+    }
+    if err := json.Unmarshal(got.Payload, &have); err != nil {
+        t.Fatalf("unmarshal decoded payload: %v", err)
+    }
+    if !have.Equal(want) || have.LeftScore != want.LeftScore || have.RightScore != want.RightScore {
+        t.Fatalf("payload changed on the way through:\n got %+v\nwant %+v", have, want)
+    }
+    if codec.FrameType() == (JSONCodec{}).FrameType() {
+        t.Fatal("MessagePack should use binary frames")
+    }
+}
+
+// The point of the codec, a state frame has to come out smaller
+func TestMsgPackSmallerThanJSON(t *testing.T) {
+    msg := sampleStateMessage(t)
+    packed, err := MsgPackCodec{}.Encode(msg)
+    if err != nil {
+        t.Fatalf("MessagePack Encode: %v", err)
+    }
+    text, err := JSONCodec{}.Encode(msg)
+    if err != nil {
+        t.Fatalf("JSON Encode: %v", err)
+    }
+    if len(packed) >= len(text) {
+        t.Fatalf("MessagePack frame is %d bytes, JSON %d", len(packed), len(text))
+    }
+}
+
+func TestMsgPackCodecNoPayload(t *testing.T) {
+    codec := MsgPackCodec{}
+    data, err := codec.Encode(Message{Type: TypePing})
+    if err != nil {
+        t.Fatalf("Encode: %v", err)
+    }
+    got, err := codec.Decode(data)
+    if err != nil {
+        t.Fatalf("Decode: %v", err)
+    }
+    if got.Type != TypePing {
+        t.Fatalf("got type %q, want %q", got.Type, TypePing)
+    }
+}
+
+func TestCodecFor(t *testing.T) {
+    if _, ok := codecFor(ProtocolV1MsgPack).(MsgPackCodec); !ok {
+        t.Errorf("%s should use MessagePack", ProtocolV1MsgPack)
+    }
+    if _, ok := codecFor(ProtocolV1).(JSONCodec); !ok {
+        t.Errorf("%s should use JSON", ProtocolV1)
+    }
+}
+
+// Reports the encoded size of a state frame for each codec as bytes/frame
+func BenchmarkCodecSize(b *testing.B) {
+    msg := sampleStateMessage(b)
+    for _, bc := range []struct {
+        name  string
+        codec Codec
+    }{
+        {"json", JSONCodec{}},
+        {"msgpack", MsgPackCodec{}},
+    } {
+        b.Run(bc.name, func(b *testing.B) {
+            b.ReportAllocs()
+            var size int
+            for i := 0; i < b.N; i++ {
+                data, err := bc.codec.Encode(msg)
+                if err != nil {
+                    b.Fatal(err)
+                }
+                size = len(data)
+            }
+            b.ReportMetric(float64(size), "bytes/frame")
+        })
+    }
+}
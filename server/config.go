@@ -0,0 +1,715 @@
+package main
+
+import (
+    "encoding/base64"
+    "errors"
+    "fmt"
+    "net/url"
+    "os"
+    "strconv"
+    "strings"
+    "time"
+
+    "github.com/ThePrimeagen/twitch-ext-pong/server/game"
+)
+
+// Port we listen on when PORT isn't set
+const DefaultPort = 42069
+
+// Frontend files are served from here when STATIC_DIR isn't set
+const DefaultStaticDir = "./src"
+
+// Timeouts for plain HTTP requests unless configured otherwise. Reading
+// headers should be quick even on a bad connection, static files and
+// metrics are small, and keep-alive connections are cheap to keep around
+// for a while. Websockets aren't affected, gorilla clears the deadlines
+// when it takes the connection over.
+const (
+    DefaultHTTPReadHeaderTimeout = 5 * time.Second
+    DefaultHTTPWriteTimeout      = 15 * time.Second
+    DefaultHTTPIdleTimeout       = 2 * time.Minute
+)
+
+// Per channel rules are read from here when ROOMS_FILE isn't set, running
+// without the file is fine
+const DefaultRoomsFile = "rooms.json"
+
+// Connections that sent nothing for this long count as stale in /metrics
+// unless configured otherwise, a few missed pings' worth
+const DefaultStaleAfter = 30 * time.Second
+
+// What clients are told to wait before reconnecting after a shutdown
+// unless configured otherwise, about how long a restart takes
+const DefaultShutdownReconnectAfter = 5 * time.Second
+
+// Paddle updates a connection may send per second when PADDLE_RATE_LIMIT
+// isn't set
+const DefaultPaddleRate = 120
+
+// Config holds everything that can be tuned through the environment
+type Config struct {
+    // Port to listen on
+    Port int
+    // Points needed to win a match
+    WinScore int
+    // Game loop ticks per second
+    TickRate int
+    // State updates per second sent to spectators, players get one every
+    // tick
+    SpectatorRate int
+    // Paddle updates allowed per connection per second
+    PaddleRate int
+    // Broadcast each paddle and ball as its own message instead of
+    // coalescing state into one frame per tick. Kept around for comparison.
+    ImmediateBroadcast bool
+    // Skip state frames when nothing moved since the last one, sending one
+    // every Heartbeat regardless
+    OnlyOnChange bool
+    Heartbeat    time.Duration
+    // http.Server timeouts against slowloris, 0 turns one off
+    HTTPReadHeaderTimeout time.Duration
+    HTTPWriteTimeout      time.Duration
+    HTTPIdleTimeout       time.Duration
+    // Negotiate permessage-deflate with clients that support it
+    Compression bool
+    // Keep moving paddles while the game is paused
+    InputWhilePaused bool
+    // Origins allowed to open a websocket
+    AllowedOrigins []string
+    // Take client IPs from X-Forwarded-For and X-Real-IP. Only turn on
+    // behind a proxy that sets them, anyone can send them.
+    TrustProxy bool
+    // Serve /debug/state, which dumps every room and connection
+    DebugState bool
+    // Connections that send nothing for this long are closed, 0 disables
+    IdleTimeout time.Duration
+    // Connections that sent nothing for this long are reported as stale
+    StaleAfter time.Duration
+    // Controlling players that send no paddle update for this long are
+    // made spectators, 0 never does
+    AFKTimeout time.Duration
+    // Told to clients in the shutdown notice as how long to wait before
+    // reconnecting
+    ShutdownReconnectAfter time.Duration
+    // How long a dropped player's team is held for a reconnect, 0 turns
+    // reconnect tokens off
+    ReconnectGrace time.Duration
+    // Rooms without connections for this long are removed, their state
+    // saved first. 0 keeps them forever.
+    RoomIdleTimeout time.Duration
+    // Largest message in bytes a client may send before it is disconnected
+    MaxMessageSize int
+    // Connections allowed across all rooms, 0 means no limit
+    MaxConnections int
+    // How connections past MaxConnections are turned away
+    FullMode FullMode
+    // Players allowed to control each paddle at once, the rest wait
+    MaxPlayersPerTeam int
+    // Put players on the smaller team whatever they ask for, and even the
+    // teams out when people leave
+    AutoBalance bool
+    // Player gap between teams tolerated before auto balance moves someone
+    AutoBalanceThreshold int
+    // Speed the ball is served at, it ramps up from there on paddle hits
+    BallSpeed float64
+    // Height paddles start every match at
+    PaddleHeight float64
+    // Vertical ball speed kept on wall bounces, 1 keeps all of it
+    WallDamping float64
+    // Share of a paddle's vertical speed the ball picks up on a hit
+    PaddleSpin float64
+    // Pixels the paddle hitbox grows by above and below, 0 is exact
+    CollisionTolerance float64
+    // Paddle hits after which a rally goes into sudden death, 0 never
+    SuddenDeathHits int
+    // Ball speed multiplier once in sudden death
+    SuddenDeathBoost float64
+    // Launch speed and angle in degrees of the ball a match opens with,
+    // for scripted demos. 0 speed serves at random.
+    InitialBallSpeed float64
+    InitialBallAngle float64
+    // Ball waits at the center this long before each serve, 0 serves
+    // right away
+    ServeCountdown time.Duration
+    // Whether the ball is served toward the side that conceded or the one
+    // that scored
+    ServeTarget game.ServeTarget
+    // Balls allowed in play at once
+    MaxBalls int
+    // Finished matches kept per room for /history
+    HistorySize int
+    // Fastest a paddle moves toward where its players want it, in pixels
+    // per second
+    MaxPaddleSpeed int
+    // Furthest a single paddle update may move the target from where the
+    // paddle is, in pixels. Bigger jumps are clamped, 0 allows any jump.
+    MaxPaddleDelta int
+    // Let the server play paddles nobody controls
+    AIEnabled bool
+    // Fastest the AI moves its paddle, in pixels per second
+    AISpeed int
+    // How long the AI waits before reacting to the ball
+    AIReaction time.Duration
+    // Seeds the random serves, the same seed replays the same serves
+    Seed int64
+    // How input from several players on one paddle is combined
+    ControlMode ControlMode
+    // Whether paddle updates off the canvas are dropped or clamped
+    BoundsMode BoundsMode
+    // What happens to a paddle its last player disconnected from
+    AbandonMode AbandonMode
+    // Size of the playing field
+    Canvas game.Canvas
+    // Directory the frontend is served from
+    StaticDir string
+    // Per channel rule overrides, see Ruleset
+    RoomsFile string
+    // Gzip text assets for clients that accept it
+    StaticGzip bool
+    // How long browsers may use static files without revalidating, 0
+    // makes them check the ETag every time
+    StaticMaxAge time.Duration
+    // Directory to record every room's broadcasts to, off when empty
+    RecordDir string
+    // File gameplay events are appended to, off when empty
+    EventLog string
+    // Connection open and close events are POSTed here, off when empty
+    WebhookURL string
+    // Directory to persist room state in, kept in memory when empty
+    StateDir string
+    // Certificate and key to serve HTTPS and WSS with, plain HTTP when
+    // both are empty
+    TLSCert string
+    TLSKey  string
+    // Twitch extension secret used to verify viewer JWTs. When empty
+    // connections aren't authenticated, which is only meant for local dev.
+    ExtensionSecret []byte
+}
+
+// DefaultConfig is what we run with when nothing is set
+func DefaultConfig() Config {
+    return Config{
+        Port:                   DefaultPort,
+        WinScore:               game.DefaultWinScore,
+        TickRate:               DefaultTickRate,
+        SpectatorRate:          DefaultSpectatorRate,
+        Heartbeat:              DefaultHeartbeat,
+        PaddleRate:             DefaultPaddleRate,
+        AllowedOrigins:         DefaultAllowedOrigins,
+        MaxMessageSize:         DefaultMaxMessageSize,
+        MaxPlayersPerTeam:      DefaultMaxPlayersPerTeam,
+        MaxBalls:               DefaultMaxBalls,
+        HistorySize:            DefaultHistorySize,
+        ServeCountdown:         DefaultServeCountdown,
+        ServeTarget:            game.ServeLoser,
+        BallSpeed:              game.BallSpeed,
+        PaddleHeight:           game.PaddleHeight,
+        WallDamping:            game.DefaultWallDamping,
+        PaddleSpin:             game.DefaultPaddleSpin,
+        SuddenDeathBoost:       game.DefaultSuddenDeathBoost,
+        AutoBalanceThreshold:   DefaultAutoBalanceThreshold,
+        RoomIdleTimeout:        DefaultRoomIdleTimeout,
+        StaleAfter:             DefaultStaleAfter,
+        ReconnectGrace:         DefaultReconnectGrace,
+        ShutdownReconnectAfter: DefaultShutdownReconnectAfter,
+        MaxPaddleSpeed:         DefaultMaxPaddleSpeed,
+        MaxPaddleDelta:         DefaultMaxPaddleDelta,
+        AISpeed:                DefaultAISpeed,
+        AIReaction:             DefaultAIReaction,
+        InputWhilePaused:       true,
+        Compression:            true,
+        ControlMode:            ControlModeLastWrite,
+        BoundsMode:             BoundsReject,
+        AbandonMode:            AbandonStay,
+        FullMode:               FullModeReject,
+        Canvas:                 game.DefaultCanvas,
+        StaticDir:              DefaultStaticDir,
+        RoomsFile:              DefaultRoomsFile,
+        StaticGzip:             true,
+        HTTPReadHeaderTimeout:  DefaultHTTPReadHeaderTimeout,
+        HTTPWriteTimeout:       DefaultHTTPWriteTimeout,
+        HTTPIdleTimeout:        DefaultHTTPIdleTimeout,
+    }
+}
+
+// clientConfig is the part of the config frontends render with
+func (c Config) clientConfig() ClientConfig {
+    return ClientConfig{
+        Canvas:       c.Canvas,
+        PaddleWidth:  game.PaddleWidth,
+        PaddleHeight: c.PaddleHeight,
+        PaddleOffset: game.PaddleOffset,
+        BallRadius:   game.BallRadius,
+        TickRate:     c.TickRate,
+        WinScore:     c.WinScore,
+    }
+}
+
+// LoadConfig reads the config from the environment, anything unset keeps
+// its default
+func LoadConfig() (Config, error) {
+    cfg := DefaultConfig()
+    var err error
+
+    // Platforms like to inject the port to listen on
+    if cfg.Port, err = parsePort(os.Getenv("PORT")); err != nil {
+        return cfg, fmt.Errorf("PORT: %w", err)
+    }
+
+    // Allow shorter or longer matches
+    if cfg.WinScore, err = parsePositiveInt(os.Getenv("WIN_SCORE"), game.DefaultWinScore); err != nil {
+        return cfg, fmt.Errorf("WIN_SCORE: %w", err)
+    }
+
+    // Physics fidelity vs CPU and bandwidth
+    if cfg.TickRate, err = parsePositiveInt(os.Getenv("TICK_RATE"), DefaultTickRate); err != nil {
+        return cfg, fmt.Errorf("TICK_RATE: %w", err)
+    }
+    if cfg.TickRate < MinTickRate || cfg.TickRate > MaxTickRate {
+        return cfg, fmt.Errorf("TICK_RATE: %d must be between %d and %d", cfg.TickRate, MinTickRate, MaxTickRate)
+    }
+
+    // Watching doesn't need every frame
+    if cfg.SpectatorRate, err = parsePositiveInt(os.Getenv("SPECTATOR_RATE"), DefaultSpectatorRate); err != nil {
+        return cfg, fmt.Errorf("SPECTATOR_RATE: %w", err)
+    }
+
+    // Keep clients from flooding paddle updates
+    if cfg.PaddleRate, err = parsePositiveInt(os.Getenv("PADDLE_RATE_LIMIT"), DefaultPaddleRate); err != nil {
+        return cfg, fmt.Errorf("PADDLE_RATE_LIMIT: %w", err)
+    }
+
+    // Old per update broadcasting, handy for comparing write volume
+    if cfg.ImmediateBroadcast, err = parseBool(os.Getenv("IMMEDIATE_BROADCAST"), false); err != nil {
+        return cfg, fmt.Errorf("IMMEDIATE_BROADCAST: %w", err)
+    }
+
+    // Idle lobbies don't need 60 identical frames a second
+    if cfg.OnlyOnChange, err = parseBool(os.Getenv("BROADCAST_ONLY_ON_CHANGE"), false); err != nil {
+        return cfg, fmt.Errorf("BROADCAST_ONLY_ON_CHANGE: %w", err)
+    }
+    if cfg.Heartbeat, err = parseSeconds(os.Getenv("HEARTBEAT_SECONDS"), DefaultHeartbeat); err != nil {
+        return cfg, fmt.Errorf("HEARTBEAT_SECONDS: %w", err)
+    }
+
+    // Smaller frames for viewers on mobile at the cost of some CPU
+    if cfg.Compression, err = parseBool(os.Getenv("COMPRESSION"), true); err != nil {
+        return cfg, fmt.Errorf("COMPRESSION: %w", err)
+    }
+
+    // Whether paddles still move while a mod has the game paused
+    if cfg.InputWhilePaused, err = parseBool(os.Getenv("INPUT_WHILE_PAUSED"), true); err != nil {
+        return cfg, fmt.Errorf("INPUT_WHILE_PAUSED: %w", err)
+    }
+
+    // Real client IPs in logs behind a load balancer
+    if cfg.TrustProxy, err = parseBool(os.Getenv("TRUST_PROXY"), false); err != nil {
+        return cfg, fmt.Errorf("TRUST_PROXY: %w", err)
+    }
+
+    // Troubleshooting production, keep it off otherwise
+    if cfg.DebugState, err = parseBool(os.Getenv("DEBUG_STATE"), false); err != nil {
+        return cfg, fmt.Errorf("DEBUG_STATE: %w", err)
+    }
+
+    // Twitch extension origins by default, add localhost for local dev
+    cfg.AllowedOrigins = parseOrigins(os.Getenv("ALLOWED_ORIGINS"))
+
+    // Keep huge payloads from eating memory
+    if cfg.MaxMessageSize, err = parsePositiveInt(os.Getenv("MAX_MESSAGE_SIZE"), DefaultMaxMessageSize); err != nil {
+        return cfg, fmt.Errorf("MAX_MESSAGE_SIZE: %w", err)
+    }
+
+    // Close tabs that were left open and forgotten
+    if cfg.IdleTimeout, err = parseSeconds(os.Getenv("IDLE_TIMEOUT_SECONDS"), 0); err != nil {
+        return cfg, fmt.Errorf("IDLE_TIMEOUT_SECONDS: %w", err)
+    }
+    // Spot zombie connections before they are reaped
+    if cfg.StaleAfter, err = parseSeconds(os.Getenv("STALE_AFTER_SECONDS"), DefaultStaleAfter); err != nil {
+        return cfg, fmt.Errorf("STALE_AFTER_SECONDS: %w", err)
+    }
+    if cfg.StaleAfter == 0 {
+        return cfg, errors.New("STALE_AFTER_SECONDS: must be positive")
+    }
+
+    // Slow clients on the plain HTTP endpoints shouldn't tie up connections
+    if cfg.HTTPReadHeaderTimeout, err = parseSeconds(os.Getenv("HTTP_READ_HEADER_TIMEOUT_SECONDS"), DefaultHTTPReadHeaderTimeout); err != nil {
+        return cfg, fmt.Errorf("HTTP_READ_HEADER_TIMEOUT_SECONDS: %w", err)
+    }
+    if cfg.HTTPWriteTimeout, err = parseSeconds(os.Getenv("HTTP_WRITE_TIMEOUT_SECONDS"), DefaultHTTPWriteTimeout); err != nil {
+        return cfg, fmt.Errorf("HTTP_WRITE_TIMEOUT_SECONDS: %w", err)
+    }
+    if cfg.HTTPIdleTimeout, err = parseSeconds(os.Getenv("HTTP_IDLE_TIMEOUT_SECONDS"), DefaultHTTPIdleTimeout); err != nil {
+        return cfg, fmt.Errorf("HTTP_IDLE_TIMEOUT_SECONDS: %w", err)
+    }
+
+    // Free paddles from players who walked away
+    if cfg.AFKTimeout, err = parseSeconds(os.Getenv("AFK_TIMEOUT_SECONDS"), 0); err != nil {
+        return cfg, fmt.Errorf("AFK_TIMEOUT_SECONDS: %w", err)
+    }
+
+    // Deploys that take longer than a quick restart
+    if cfg.ShutdownReconnectAfter, err = parseSeconds(os.Getenv("SHUTDOWN_RECONNECT_SECONDS"), DefaultShutdownReconnectAfter); err != nil {
+        return cfg, fmt.Errorf("SHUTDOWN_RECONNECT_SECONDS: %w", err)
+    }
+
+    // Network blips shouldn't cost players their paddle
+    if cfg.ReconnectGrace, err = parseSeconds(os.Getenv("RECONNECT_GRACE_SECONDS"), DefaultReconnectGrace); err != nil {
+        return cfg, fmt.Errorf("RECONNECT_GRACE_SECONDS: %w", err)
+    }
+
+    // Channels that went offline shouldn't keep a game loop running
+    if cfg.RoomIdleTimeout, err = parseSeconds(os.Getenv("ROOM_IDLE_TIMEOUT_SECONDS"), DefaultRoomIdleTimeout); err != nil {
+        return cfg, fmt.Errorf("ROOM_IDLE_TIMEOUT_SECONDS: %w", err)
+    }
+
+    // Protect small servers from a raid
+    if cfg.MaxConnections, err = parseNonNegativeInt(os.Getenv("MAX_CONNECTIONS"), 0); err != nil {
+        return cfg, fmt.Errorf("MAX_CONNECTIONS: %w", err)
+    }
+    if cfg.FullMode, err = parseFullMode(os.Getenv("FULL_MODE")); err != nil {
+        return cfg, fmt.Errorf("FULL_MODE: %w", err)
+    }
+
+    // How many viewers share a paddle
+    if cfg.MaxPlayersPerTeam, err = parsePositiveInt(os.Getenv("MAX_PLAYERS_PER_TEAM"), DefaultMaxPlayersPerTeam); err != nil {
+        return cfg, fmt.Errorf("MAX_PLAYERS_PER_TEAM: %w", err)
+    }
+
+    // Stop 10 vs 1 matches, off by default since picking a side is fun too
+    if cfg.AutoBalance, err = parseBool(os.Getenv("AUTO_BALANCE"), false); err != nil {
+        return cfg, fmt.Errorf("AUTO_BALANCE: %w", err)
+    }
+    if cfg.AutoBalanceThreshold, err = parsePositiveInt(os.Getenv("AUTO_BALANCE_THRESHOLD"), DefaultAutoBalanceThreshold); err != nil {
+        return cfg, fmt.Errorf("AUTO_BALANCE_THRESHOLD: %w", err)
+    }
+
+    // Faster or slower games, bigger or smaller paddles
+    if cfg.BallSpeed, err = parseFloat(os.Getenv("BALL_SPEED"), game.BallSpeed, game.MinBallSpeed, game.MaxBallSpeed); err != nil {
+        return cfg, fmt.Errorf("BALL_SPEED: %w", err)
+    }
+    if cfg.PaddleHeight, err = parseFloat(os.Getenv("PADDLE_HEIGHT"), game.PaddleHeight, game.MinPaddleHeight, game.MaxPaddleHeight); err != nil {
+        return cfg, fmt.Errorf("PADDLE_HEIGHT: %w", err)
+    }
+
+    // Softer walls and spinny paddles for variety
+    if cfg.WallDamping, err = parseFloat(os.Getenv("WALL_DAMPING"), game.DefaultWallDamping, 0, 1); err != nil {
+        return cfg, fmt.Errorf("WALL_DAMPING: %w", err)
+    }
+    if cfg.PaddleSpin, err = parseFloat(os.Getenv("PADDLE_SPIN"), game.DefaultPaddleSpin, 0, 2); err != nil {
+        return cfg, fmt.Errorf("PADDLE_SPIN: %w", err)
+    }
+
+    // Forgive crowd controlled paddles that lag behind the ball
+    if cfg.CollisionTolerance, err = parseFloat(os.Getenv("COLLISION_TOLERANCE"), 0, 0, game.PaddleHeight); err != nil {
+        return cfg, fmt.Errorf("COLLISION_TOLERANCE: %w", err)
+    }
+
+    // Keep rallies from dragging on forever
+    if cfg.SuddenDeathHits, err = parseNonNegativeInt(os.Getenv("SUDDEN_DEATH_HITS"), 0); err != nil {
+        return cfg, fmt.Errorf("SUDDEN_DEATH_HITS: %w", err)
+    }
+    if cfg.SuddenDeathBoost, err = parseFloat(os.Getenv("SUDDEN_DEATH_BOOST"), game.DefaultSuddenDeathBoost, 1, 4); err != nil {
+        return cfg, fmt.Errorf("SUDDEN_DEATH_BOOST: %w", err)
+    }
+
+    // Open every match with the same serve
+    if cfg.InitialBallSpeed, err = parseFloat(os.Getenv("INITIAL_BALL_SPEED"), 0, 0, game.MaxBallSpeed); err != nil {
+        return cfg, fmt.Errorf("INITIAL_BALL_SPEED: %w", err)
+    }
+    if cfg.InitialBallAngle, err = parseFloat(os.Getenv("INITIAL_BALL_ANGLE"), 0, -180, 180); err != nil {
+        return cfg, fmt.Errorf("INITIAL_BALL_ANGLE: %w", err)
+    }
+    if err := game.ValidateLaunchAngle(cfg.InitialBallAngle); err != nil {
+        return cfg, fmt.Errorf("INITIAL_BALL_ANGLE: %w", err)
+    }
+
+    // Give players a moment after every point
+    if cfg.ServeCountdown, err = parseSeconds(os.Getenv("SERVE_COUNTDOWN_SECONDS"), DefaultServeCountdown); err != nil {
+        return cfg, fmt.Errorf("SERVE_COUNTDOWN_SECONDS: %w", err)
+    }
+
+    // Cap for multiball so mods can't flood the field
+    if cfg.MaxBalls, err = parsePositiveInt(os.Getenv("MAX_BALLS"), DefaultMaxBalls); err != nil {
+        return cfg, fmt.Errorf("MAX_BALLS: %w", err)
+    }
+
+    // Recent results panel size
+    if cfg.HistorySize, err = parsePositiveInt(os.Getenv("HISTORY_SIZE"), DefaultHistorySize); err != nil {
+        return cfg, fmt.Errorf("HISTORY_SIZE: %w", err)
+    }
+
+    // Something to play against when one side is empty. Slower and later
+    // makes it easier.
+    if cfg.AIEnabled, err = parseBool(os.Getenv("AI_ENABLED"), false); err != nil {
+        return cfg, fmt.Errorf("AI_ENABLED: %w", err)
+    }
+    if cfg.AISpeed, err = parsePositiveInt(os.Getenv("AI_SPEED"), DefaultAISpeed); err != nil {
+        return cfg, fmt.Errorf("AI_SPEED: %w", err)
+    }
+    if cfg.AIReaction, err = parseMillis(os.Getenv("AI_REACTION_MS"), DefaultAIReaction); err != nil {
+        return cfg, fmt.Errorf("AI_REACTION_MS: %w", err)
+    }
+
+    // Fixed seed for reproducible serves, otherwise a fresh one each run
+    if cfg.Seed, err = parseSeed(os.Getenv("SEED")); err != nil {
+        return cfg, fmt.Errorf("SEED: %w", err)
+    }
+
+    // Lower feels floatier, higher gets closer to snapping to input
+    if cfg.MaxPaddleSpeed, err = parsePositiveInt(os.Getenv("MAX_PADDLE_SPEED"), DefaultMaxPaddleSpeed); err != nil {
+        return cfg, fmt.Errorf("MAX_PADDLE_SPEED: %w", err)
+    }
+
+    // Keep modified clients from teleporting the paddle across the field
+    if cfg.MaxPaddleDelta, err = parseNonNegativeInt(os.Getenv("MAX_PADDLE_DELTA"), DefaultMaxPaddleDelta); err != nil {
+        return cfg, fmt.Errorf("MAX_PADDLE_DELTA: %w", err)
+    }
+
+    // Some variants serve to whoever just scored
+    if cfg.ServeTarget, err = game.ParseServeTarget(os.Getenv("SERVE_TARGET")); err != nil {
+        return cfg, fmt.Errorf("SERVE_TARGET: %w", err)
+    }
+
+    // Crowd controlled paddles feel better averaged
+    if cfg.ControlMode, err = parseControlMode(os.Getenv("CONTROL_MODE")); err != nil {
+        return cfg, fmt.Errorf("CONTROL_MODE: %w", err)
+    }
+
+    // Forgiving clients that round a little past the edge
+    if cfg.BoundsMode, err = parseBoundsMode(os.Getenv("BOUNDS_MODE")); err != nil {
+        return cfg, fmt.Errorf("BOUNDS_MODE: %w", err)
+    }
+    // Don't leave abandoned paddles stuck wherever they were
+    if cfg.AbandonMode, err = parseAbandonMode(os.Getenv("ABANDONED_PADDLE")); err != nil {
+        return cfg, fmt.Errorf("ABANDONED_PADDLE: %w", err)
+    }
+
+    // Frontends rendering at a different resolution
+    width, err := parsePositiveInt(os.Getenv("CANVAS_WIDTH"), int(game.DefaultCanvas.Width))
+    if err != nil {
+        return cfg, fmt.Errorf("CANVAS_WIDTH: %w", err)
+    }
+    height, err := parsePositiveInt(os.Getenv("CANVAS_HEIGHT"), int(game.DefaultCanvas.Height))
+    if err != nil {
+        return cfg, fmt.Errorf("CANVAS_HEIGHT: %w", err)
+    }
+    cfg.Canvas = game.Canvas{Width: float64(width), Height: float64(height)}
+
+    // Where the frontend lives, relative to the working directory
+    if v := os.Getenv("STATIC_DIR"); v != "" {
+        cfg.StaticDir = v
+    }
+    if cfg.StaticGzip, err = parseBool(os.Getenv("STATIC_GZIP"), true); err != nil {
+        return cfg, fmt.Errorf("STATIC_GZIP: %w", err)
+    }
+    // Cache static files in the browser
+    if cfg.StaticMaxAge, err = parseSeconds(os.Getenv("STATIC_MAX_AGE_SECONDS"), 0); err != nil {
+        return cfg, fmt.Errorf("STATIC_MAX_AGE_SECONDS: %w", err)
+    }
+
+    // Let channels pick their own rules
+    if v := os.Getenv("ROOMS_FILE"); v != "" {
+        cfg.RoomsFile = v
+    }
+
+    // Record matches for highlights
+    cfg.RecordDir = os.Getenv("RECORD_DIR")
+
+    // Feed for stats tooling
+    cfg.EventLog = os.Getenv("EVENT_LOG")
+
+    // External analytics
+    if v := os.Getenv("WEBHOOK_URL"); v != "" {
+        u, err := url.Parse(v)
+        if err != nil {
+            return cfg, fmt.Errorf("WEBHOOK_URL: %w", err)
+        }
+        if u.Scheme != "http" && u.Scheme != "https" {
+            return cfg, fmt.Errorf("WEBHOOK_URL: invalid scheme %q: must be http or https", u.Scheme)
+        }
+        cfg.WebhookURL = v
+    }
+
+    // Persist rooms to disk so they survive restarts
+    cfg.StateDir = os.Getenv("STATE_DIR")
+
+    // Extensions in production only connect over wss
+    cfg.TLSCert = os.Getenv("TLS_CERT")
+    cfg.TLSKey = os.Getenv("TLS_KEY")
+    if (cfg.TLSCert == "") != (cfg.TLSKey == "") {
+        return cfg, errors.New("TLS_CERT and TLS_KEY must be set together")
+    }
+    if cfg.TLSCert != "" {
+        if err := checkReadable(cfg.TLSCert); err != nil {
+            return cfg, fmt.Errorf("TLS_CERT: %w", err)
+        }
+        if err := checkReadable(cfg.TLSKey); err != nil {
+            return cfg, fmt.Errorf("TLS_KEY: %w", err)
+        }
+    }
+
+    // Twitch hands out the extension secret base64 encoded
+    if v := os.Getenv("EXTENSION_SECRET"); v != "" {
+        if cfg.ExtensionSecret, err = base64.StdEncoding.DecodeString(v); err != nil {
+            return cfg, fmt.Errorf("EXTENSION_SECRET: %w", err)
+        }
+    }
+
+    return cfg, nil
+}
+
+// Largest message in bytes a client may send when MAX_MESSAGE_SIZE isn't
+// set, legitimate messages are tiny
+const DefaultMaxMessageSize = 1024
+
+// parsePort reads a listen port, falling back to DefaultPort when empty
+func parsePort(v string) (int, error) {
+    if v == "" {
+        return DefaultPort, nil
+    }
+    port, err := strconv.Atoi(v)
+    if err != nil {
+        return 0, fmt.Errorf("invalid port %q: %w", v, err)
+    }
+    if port < 1 || port > 65535 {
+        return 0, fmt.Errorf("invalid port %d: must be between 1 and 65535", port)
+    }
+    return port, nil
+}
+
+// parsePositiveInt reads a positive integer setting, falling back to def
+// when empty
+func parsePositiveInt(v string, def int) (int, error) {
+    if v == "" {
+        return def, nil
+    }
+    n, err := strconv.Atoi(v)
+    if err != nil {
+        return 0, fmt.Errorf("invalid number %q: %w", v, err)
+    }
+    if n < 1 {
+        return 0, fmt.Errorf("invalid number %d: must be at least 1", n)
+    }
+    return n, nil
+}
+
+// parseNonNegativeInt is parsePositiveInt for settings where 0 means off
+func parseNonNegativeInt(v string, def int) (int, error) {
+    if v == "" {
+        return def, nil
+    }
+    n, err := strconv.Atoi(v)
+    if err != nil {
+        return 0, fmt.Errorf("invalid number %q: %w", v, err)
+    }
+    if n < 0 {
+        return 0, fmt.Errorf("invalid number %d: must not be negative", n)
+    }
+    return n, nil
+}
+
+// parseFloat reads a number between lo and hi, falling back to def when
+// empty
+func parseFloat(v string, def, lo, hi float64) (float64, error) {
+    if v == "" {
+        return def, nil
+    }
+    f, err := strconv.ParseFloat(v, 64)
+    if err != nil {
+        return 0, fmt.Errorf("invalid number %q: %w", v, err)
+    }
+    if !(f >= lo && f <= hi) {
+        return 0, fmt.Errorf("invalid number %v: must be between %v and %v", f, lo, hi)
+    }
+    return f, nil
+}
+
+// parseSeed reads the RNG seed, picking one from the clock when empty
+func parseSeed(v string) (int64, error) {
+    if v == "" {
+        return time.Now().UnixNano(), nil
+    }
+    n, err := strconv.ParseInt(v, 10, 64)
+    if err != nil {
+        return 0, fmt.Errorf("invalid seed %q: %w", v, err)
+    }
+    return n, nil
+}
+
+// checkReadable makes sure path is a file we can open, so a bad path fails
+// at startup instead of on the first connection
+func checkReadable(path string) error {
+    f, err := os.Open(path)
+    if err != nil {
+        return err
+    }
+    defer f.Close()
+    info, err := f.Stat()
+    if err != nil {
+        return err
+    }
+    if info.IsDir() {
+        return fmt.Errorf("%s is a directory", path)
+    }
+    return nil
+}
+
+// parseSeconds reads a duration given in whole seconds, falling back to def
+// when empty. Zero is allowed and usually means off.
+func parseSeconds(v string, def time.Duration) (time.Duration, error) {
+    if v == "" {
+        return def, nil
+    }
+    n, err := strconv.Atoi(v)
+    if err != nil {
+        return 0, fmt.Errorf("invalid seconds %q: %w", v, err)
+    }
+    if n < 0 {
+        return 0, fmt.Errorf("invalid seconds %d: must not be negative", n)
+    }
+    return time.Duration(n) * time.Second, nil
+}
+
+// parseMillis reads a duration given in whole milliseconds, falling back
+// to def when empty. Zero is allowed.
+func parseMillis(v string, def time.Duration) (time.Duration, error) {
+    if v == "" {
+        return def, nil
+    }
+    n, err := strconv.Atoi(v)
+    if err != nil {
+        return 0, fmt.Errorf("invalid milliseconds %q: %w", v, err)
+    }
+    if n < 0 {
+        return 0, fmt.Errorf("invalid milliseconds %d: must not be negative", n)
+    }
+    return time.Duration(n) * time.Millisecond, nil
+}
+
+// parseBool reads an on/off setting, falling back to def when empty
+func parseBool(v string, def bool) (bool, error) {
+    if v == "" {
+        return def, nil
+    }
+    b, err := strconv.ParseBool(v)
+    if err != nil {
+        return false, fmt.Errorf("invalid boolean %q: %w", v, err)
+    }
+    return b, nil
+}
+
+// parseOrigins splits a comma separated allowlist, falling back to
+// DefaultAllowedOrigins when empty
+func parseOrigins(v string) []string {
+    var origins []string
+    for _, origin := range strings.Split(v, ",") {
+        if origin = strings.TrimSpace(origin); origin != "" {
+            origins = append(origins, origin)
+        }
+    }
+    if len(origins) == 0 {
+        return DefaultAllowedOrigins
+    }
+    return origins
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+    "os"
+    "path/filepath"
+    "testing"
+
+    "github.com/ThePrimeagen/twitch-ext-pong/server/game"
+)
+
+func TestLoadConfigInitialBall(t *testing.T) {
+    t.Setenv("INITIAL_BALL_SPEED", "400")
+    t.Setenv("INITIAL_BALL_ANGLE", "-30")
+    cfg, err := LoadConfig()
+    if err != nil {
+        t.Fatalf("LoadConfig: %v", err)
+    }
+    if cfg.InitialBallSpeed != 400 || cfg.InitialBallAngle != -30 {
+        t.Fatalf("got speed %v angle %v, want 400 and -30", cfg.InitialBallSpeed, cfg.InitialBallAngle)
+    }
+
+    ball := firstBall(cfg, NewRoomRand(1, "test"))
+    if ball.VX <= 0 || ball.VY >= 0 {
+        t.Fatalf("first ball velocity (%v, %v), want right and up", ball.VX, ball.VY)
+    }
+}
+
+func TestLoadConfigRejectsVerticalLaunch(t *testing.T) {
+    for _, angle := range []string{"90", "-90", "85"} {
+        t.Setenv("INITIAL_BALL_ANGLE", angle)
+        if _, err := LoadConfig(); err == nil {
+            t.Errorf("INITIAL_BALL_ANGLE=%s was accepted", angle)
+        }
+    }
+}
+
+func TestLoadConfigTLSPair(t *testing.T) {
+    dir := t.TempDir()
+    cert, key := filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+    for _, path := range []string{cert, key} {
+        if err := os.WriteFile(path, []byte("pem"), 0o600); err != nil {
+            t.Fatalf("write %s: %v", path, err)
+        }
+    }
+
+    for _, tc := range []struct {
+        name      string
+        cert, key string
+        ok        bool
+    }{
+        {"neither", "", "", true},
+        {"both", cert, key, true},
+        {"cert only", cert, "", false},
+        {"key only", "", key, false},
+        {"missing key file", cert, filepath.Join(dir, "nope.pem"), false},
+    } {
+        t.Setenv("TLS_CERT", tc.cert)
+        t.Setenv("TLS_KEY", tc.key)
+        _, err := LoadConfig()
+        if (err == nil) != tc.ok {
+            t.Errorf("%s: LoadConfig = %v, want ok %v", tc.name, err, tc.ok)
+        }
+    }
+}
+
+func TestConfigMessageMatchesConfig(t *testing.T) {
+    t.Setenv("CANVAS_WIDTH", "1000")
+    t.Setenv("CANVAS_HEIGHT", "700")
+    t.Setenv("PADDLE_HEIGHT", "140")
+    t.Setenv("TICK_RATE", "30")
+    t.Setenv("WIN_SCORE", "5")
+    cfg, err := LoadConfig()
+    if err != nil {
+        t.Fatalf("LoadConfig: %v", err)
+    }
+    cfg.StaticDir = ""
+    cfg.RoomsFile = ""
+    ts := newTestServer(t, cfg)
+    c := ts.dial(t, "channel=configured")
+
+    got := decode[ClientConfig](t, c.expect(TypeConfig))
+    want := ClientConfig{
+        Canvas:       game.Canvas{Width: 1000, Height: 700},
+        PaddleWidth:  game.PaddleWidth,
+        PaddleHeight: 140,
+        PaddleOffset: game.PaddleOffset,
+        BallRadius:   game.BallRadius,
+        TickRate:     30,
+        WinScore:     5,
+    }
+    if got != want {
+        t.Fatalf("config = %+v, want %+v", got, want)
+    }
+    // Sent before the state it describes
+    c.expect(TypeInitialState)
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+    "fmt"
+    "math"
+
+    "github.com/ThePrimeagen/twitch-ext-pong/server/game"
+)
+
+// ControlMode decides how several players' input for one paddle is combined
+type ControlMode string
+
+const (
+    // Every paddle update moves the paddle, last one wins
+    ControlModeLastWrite ControlMode = "last_write"
+    // Paddle moves to the mean of all input received during a tick
+    ControlModeAverage ControlMode = "average"
+    // Paddle moves to where most of the input received during a tick points
+    ControlModeMajority ControlMode = "majority"
+)
+
+// BoundsMode decides what happens to paddle updates with a Y off the canvas
+type BoundsMode string
+
+const (
+    // Drop the update with INVALID_POSITION
+    BoundsReject BoundsMode = "reject"
+    // Move the paddle as far as it goes, so clients that are a little off
+    // from rounding don't lose the update
+    BoundsClamp BoundsMode = "clamp"
+)
+
+// parseBoundsMode reads a bounds mode, falling back to reject when empty
+func parseBoundsMode(v string) (BoundsMode, error) {
+    switch mode := BoundsMode(v); mode {
+    case "":
+        return BoundsReject, nil
+    case BoundsReject, BoundsClamp:
+        return mode, nil
+    }
+    return "", fmt.Errorf("invalid bounds mode %q: must be %q or %q", v, BoundsReject, BoundsClamp)
+}
+
+// AbandonMode decides what happens to a paddle whose player disconnected
+// with nobody left to take it over
+type AbandonMode string
+
+const (
+    // Leave the paddle where it was
+    AbandonStay AbandonMode = "stay"
+    // Slide the paddle back to the center at paddle speed
+    AbandonEase AbandonMode = "ease"
+    // Put the paddle back at the center right away
+    AbandonSnap AbandonMode = "snap"
+)
+
+// parseAbandonMode reads an abandon mode, falling back to stay when empty
+func parseAbandonMode(v string) (AbandonMode, error) {
+    switch mode := AbandonMode(v); mode {
+    case "":
+        return AbandonStay, nil
+    case AbandonStay, AbandonEase, AbandonSnap:
+        return mode, nil
+    }
+    return "", fmt.Errorf("invalid abandon mode %q: must be %q, %q or %q", v, AbandonStay, AbandonEase, AbandonSnap)
+}
+
+// Inputs within this distance of each other count as the same vote in
+// majority mode
+const MajorityBucketSize = game.PaddleHeight / 2
+
+// parseControlMode reads a control mode, falling back to last write when
+// empty
+func parseControlMode(v string) (ControlMode, error) {
+    switch mode := ControlMode(v); mode {
+    case "":
+        return ControlModeLastWrite, nil
+    case ControlModeLastWrite, ControlModeAverage, ControlModeMajority:
+        return mode, nil
+    }
+    return "", fmt.Errorf("invalid control mode %q: must be %q, %q or %q",
+        v, ControlModeLastWrite, ControlModeAverage, ControlModeMajority)
+}
+
+// combineInputs turns a tick's worth of paddle Y values into a single
+// target. inputs must not be empty.
+func combineInputs(mode ControlMode, inputs []float64) float64 {
+    switch mode {
+    case ControlModeAverage:
+        return mean(inputs)
+    case ControlModeMajority:
+        return majority(inputs)
+    }
+    return inputs[len(inputs)-1]
+}
+
+func mean(values []float64) float64 {
+    sum := 0.0
+    for _, v := range values {
+        sum += v
+    }
+    return sum / float64(len(values))
+}
+
+// Bucket the inputs, pick the bucket with the most votes and return the
+// mean of the inputs in it. Ties go to the bucket voted for first.
+func majority(inputs []float64) float64 {
+    buckets := make(map[int][]float64)
+    var order []int
+    for _, y := range inputs {
+        bucket := int(math.Floor(y / MajorityBucketSize))
+        if _, ok := buckets[bucket]; !ok {
+            order = append(order, bucket)
+        }
+        buckets[bucket] = append(buckets[bucket], y)
+    }
+
+    best := order[0]
+    for _, bucket := range order[1:] {
+        if len(buckets[bucket]) > len(buckets[best]) {
+            best = bucket
+        }
+    }
+    return mean(buckets[best])
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+    "testing"
+
+    "github.com/ThePrimeagen/twitch-ext-pong/server/game"
+)
+
+func TestCombineInputs(t *testing.T) {
+    for _, tc := range []struct {
+        mode   ControlMode
+        inputs []float64
+        want   float64
+    }{
+        {ControlModeLastWrite, []float64{100, 200, 300}, 300},
+        {ControlModeAverage, []float64{100, 200, 300}, 200},
+        {ControlModeAverage, []float64{0, 0, 0, 400}, 100},
+        {ControlModeAverage, []float64{250}, 250},
+        // Two votes near the top beat one far away
+        {ControlModeMajority, []float64{10, 500, 20}, 15},
+        // Tie goes to the first bucket voted for
+        {ControlModeMajority, []float64{500, 10}, 500},
+    } {
+        if got := combineInputs(tc.mode, tc.inputs); got != tc.want {
+            t.Errorf("combineInputs(%s, %v) = %v, want %v", tc.mode, tc.inputs, got, tc.want)
+        }
+    }
+}
+
+func TestAverageModeMovesPaddleToMean(t *testing.T) {
+    cfg := DefaultConfig()
+    cfg.ControlMode = ControlModeAverage
+    r := newTestRoom(t, cfg)
+
+    r.Lock()
+    for _, y := range []float64{100, 200, 360} {
+        r.movePaddle(game.PaddlePosition{Side: "left", Y: y})
+    }
+    r.Unlock()
+    // Enough ticks for the paddle to get there at full speed
+    for i := 0; i < 60; i++ {
+        r.advance(1.0 / 60)
+    }
+
+    state := r.snapshot()
+    if state.LeftTarget != 220 || state.LeftPaddle.Y != 220 {
+        t.Fatalf("left paddle at %v heading to %v, want 220", state.LeftPaddle.Y, state.LeftTarget)
+    }
+}
+
+func TestAbandonedPaddleRecenters(t *testing.T) {
+    for _, mode := range []AbandonMode{AbandonEase, AbandonSnap} {
+        cfg := testConfig()
+        cfg.AbandonMode = mode
+        // Slow enough that easing back takes a few spectator frames
+        cfg.MaxPaddleSpeed = 300
+        ts := newTestServer(t, cfg)
+        player := dialPlayer(t, ts, "abandon", "left")
+        watcher := ts.dial(t, "channel=abandon&role=spectator")
+        watcher.expect(TypeInitialState)
+        center := game.CenteredPaddle(cfg.Canvas, "left", cfg.PaddleHeight).Y
+
+        player.send(TypePaddleUpdate, game.PaddlePosition{Side: "left", Y: 100})
+        watcher.expectState(func(s game.State) bool {
+            return s.LeftPaddle.Y == 100
+        })
+        player.conn.Close()
+
+        // Back at the center, easing passes through the space in between
+        var between bool
+        watcher.expectState(func(s game.State) bool {
+            between = between || (s.LeftPaddle.Y > 100 && s.LeftPaddle.Y < center)
+            return s.LeftPaddle.Y == center && s.LeftTarget == center
+        })
+        if between != (mode == AbandonEase) {
+            t.Errorf("%s: paddle passed between %v and %v: %v", mode, 100, center, between)
+        }
+    }
+}
@@ -0,0 +1,30 @@
+package main
+
+import (
+    "net/http"
+)
+
+// corsMiddleware lets pages from allowed origins (the extension iframe on
+// Twitch's CDN) fetch from us, using the same allowlist as the websocket
+// origin check. Preflight requests are answered here.
+func corsMiddleware(next http.Handler, allowed []string) http.Handler {
+    return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        origin := r.Header.Get("Origin")
+        w.Header().Add("Vary", "Origin")
+
+        if origin != "" && originAllowed(origin, allowed) {
+            w.Header().Set("Access-Control-Allow-Origin", origin)
+            if r.Method == http.MethodOptions {
+                w.Header().Set("Access-Control-Allow-Methods", "GET, POST, OPTIONS")
+                w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
+                w.Header().Set("Access-Control-Max-Age", "600")
+            }
+        }
+
+        if r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != "" {
+            w.WriteHeader(http.StatusNoContent)
+            return
+        }
+        next.ServeHTTP(w, r)
+    })
+}
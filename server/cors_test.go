@@ -0,0 +1,43 @@
+package main
+
+import (
+    "net/http"
+    "testing"
+)
+
+func TestCORSHeaders(t *testing.T) {
+    ts := newTestServer(t, testConfig())
+
+    for _, tc := range []struct {
+        method, origin string
+        want           string
+    }{
+        {http.MethodGet, "https://abc.ext-twitch.tv", "https://abc.ext-twitch.tv"},
+        {http.MethodOptions, "https://abc.ext-twitch.tv", "https://abc.ext-twitch.tv"},
+        {http.MethodGet, "https://evil.example.com", ""},
+        {http.MethodGet, "", ""},
+    } {
+        req, err := http.NewRequest(tc.method, ts.http.URL+"/metrics", nil)
+        if err != nil {
+            t.Fatal(err)
+        }
+        if tc.origin != "" {
+            req.Header.Set("Origin", tc.origin)
+        }
+        if tc.method == http.MethodOptions {
+            req.Header.Set("Access-Control-Request-Method", http.MethodGet)
+        }
+        resp, err := http.DefaultClient.Do(req)
+        if err != nil {
+            t.Fatalf("%s %q: %v", tc.method, tc.origin, err)
+        }
+        resp.Body.Close()
+
+        if got := resp.Header.Get("Access-Control-Allow-Origin"); got != tc.want {
+            t.Errorf("%s from %q: Access-Control-Allow-Origin = %q, want %q", tc.method, tc.origin, got, tc.want)
+        }
+        if tc.method == http.MethodOptions && resp.StatusCode != http.StatusNoContent {
+            t.Errorf("preflight status = %d, want %d", resp.StatusCode, http.StatusNoContent)
+        }
+    }
+}
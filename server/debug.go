@@ -0,0 +1,110 @@
+package main
+
+import (
+    "encoding/json"
+    "net/http"
+    "sort"
+    "time"
+
+    "github.com/ThePrimeagen/twitch-ext-pong/server/game"
+)
+
+// DebugConnection is what /debug/state shows of a connection. Tokens and
+// the extension secret never make it in here.
+type DebugConnection struct {
+    ID           string     `json:"id"`
+    Addr         string     `json:"addr"`
+    OpaqueUserID string     `json:"opaque_user_id,omitempty"`
+    TwitchRole   string     `json:"twitch_role,omitempty"`
+    Role         ClientRole `json:"role"`
+    Team         string     `json:"team,omitempty"`
+    Protocol     string     `json:"protocol"`
+    Batch        bool       `json:"batch"`
+    RTTMillis    float64    `json:"rtt_ms"`
+    Queued       int        `json:"queued"`
+}
+
+// DebugRoom is one room in /debug/state
+type DebugRoom struct {
+    Channel     string              `json:"channel"`
+    State       game.State          `json:"state"`
+    Waiting     map[string][]string `json:"waiting"`
+    Connections []DebugConnection   `json:"connections"`
+}
+
+// DebugState is the body returned by /debug/state
+type DebugState struct {
+    Uptime       string      `json:"uptime"`
+    Connections  int64       `json:"connections"`
+    Players      int64       `json:"players"`
+    Spectators   int64       `json:"spectators"`
+    ShuttingDown bool        `json:"shutting_down"`
+    Rooms        []DebugRoom `json:"rooms"`
+}
+
+// Snapshot of a room for /debug/state
+func (r *Room) debug() DebugRoom {
+    r.RLock()
+    defer r.RUnlock()
+
+    room := DebugRoom{
+        Channel:     r.channel,
+        State:       r.gameState.Clone(),
+        Waiting:     make(map[string][]string),
+        Connections: make([]DebugConnection, 0, len(r.connections)),
+    }
+    for team, queue := range r.waiting {
+        for _, client := range queue {
+            room.Waiting[team] = append(room.Waiting[team], client.id)
+        }
+    }
+    for client := range r.connections {
+        room.Connections = append(room.Connections, DebugConnection{
+            ID:           client.id,
+            Addr:         client.addr,
+            OpaqueUserID: client.identity.OpaqueUserID,
+            TwitchRole:   client.identity.Role,
+            Role:         client.role,
+            Team:         client.team,
+            Protocol:     client.protocol,
+            Batch:        client.batch,
+            RTTMillis:    float64(client.RTT()) / float64(time.Millisecond),
+            Queued:       len(client.send),
+        })
+    }
+    sort.Slice(room.Connections, func(i, j int) bool {
+        return room.Connections[i].ID < room.Connections[j].ID
+    })
+    return room
+}
+
+// handleDebugState dumps every room and connection, only registered when
+// DEBUG_STATE is on
+func (s *Server) handleDebugState(w http.ResponseWriter, r *http.Request) {
+    if r.Method != http.MethodGet {
+        http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+        return
+    }
+
+    // Server lock first, same order as everywhere else
+    s.RLock()
+    rooms := make([]DebugRoom, 0, len(s.rooms))
+    for _, room := range s.rooms {
+        rooms = append(rooms, room.debug())
+    }
+    s.RUnlock()
+    sort.Slice(rooms, func(i, j int) bool {
+        return rooms[i].Channel < rooms[j].Channel
+    })
+
+    resp := DebugState{
+        Uptime:       time.Since(s.startedAt).Round(time.Second).String(),
+        Connections:  s.connectionCount.Load(),
+        Players:      s.players.Load(),
+        Spectators:   s.spectators.Load(),
+        ShuttingDown: s.shuttingDown.Load(),
+        Rooms:        rooms,
+    }
+    w.Header().Set("Content-Type", "application/json")
+    json.NewEncoder(w).Encode(resp)
+}
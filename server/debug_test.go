@@ -0,0 +1,40 @@
+package main
+
+import (
+    "encoding/json"
+    "net/http"
+    "testing"
+)
+
+func TestDebugStateListsConnection(t *testing.T) {
+    cfg := testConfig()
+    cfg.DebugState = true
+    ts := newTestServer(t, cfg)
+    c := ts.dial(t, "channel=debugged")
+    id := decode[InitialState](t, c.expect(TypeInitialState)).ConnectionID
+    c.send(TypeTeamAssign, TeamAssignment{Team: "left"})
+    c.expect(TypeTeamAssign)
+
+    resp := ts.get(t, "/debug/state")
+    if resp.StatusCode != http.StatusOK {
+        t.Fatalf("status = %d, want %d", resp.StatusCode, http.StatusOK)
+    }
+    var dump DebugState
+    if err := json.NewDecoder(resp.Body).Decode(&dump); err != nil {
+        t.Fatalf("decode: %v", err)
+    }
+    if dump.Connections != 1 || len(dump.Rooms) != 1 || dump.Rooms[0].Channel != "debugged" {
+        t.Fatalf("dump has %d connections in %+v, want 1 in debugged", dump.Connections, dump.Rooms)
+    }
+    conns := dump.Rooms[0].Connections
+    if len(conns) != 1 || conns[0].ID != id || conns[0].Role != RolePlayer || conns[0].Team != "left" {
+        t.Fatalf("connections = %+v, want %s playing left", conns, id)
+    }
+}
+
+func TestDebugStateOffByDefault(t *testing.T) {
+    ts := newTestServer(t, testConfig())
+    if resp := ts.get(t, "/debug/state"); resp.StatusCode == http.StatusOK {
+        t.Fatalf("/debug/state served without DEBUG_STATE")
+    }
+}
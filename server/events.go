@@ -0,0 +1,64 @@
+package main
+
+import (
+    "encoding/json"
+    "io"
+    "sync"
+    "time"
+)
+
+// Kinds of gameplay events written to the event log
+const (
+    EventPaddleMoved = "paddle_moved"
+    EventScored      = "scored"
+    EventGameOver    = "game_over"
+)
+
+// Event is one line of the event log. Fields that don't apply to the kind
+// of event are left out.
+type Event struct {
+    Event   string    `json:"event"`
+    At      time.Time `json:"at"`
+    Channel string    `json:"channel"`
+    Side    string    `json:"side,omitempty"`
+    Y       *float64  `json:"y,omitempty"`
+    Left    *int      `json:"left,omitempty"`
+    Right   *int      `json:"right,omitempty"`
+}
+
+// EventLogger writes gameplay events as newline delimited JSON, separate
+// from the operational logs, for anything downstream that builds stats.
+// Safe for concurrent use.
+type EventLogger struct {
+    mu  sync.Mutex
+    enc *json.Encoder
+}
+
+// NewEventLogger writes events to w, use io.Discard to turn them off
+func NewEventLogger(w io.Writer) *EventLogger {
+    return &EventLogger{enc: json.NewEncoder(w)}
+}
+
+// PaddleMoved records a player moving a paddle to y
+func (l *EventLogger) PaddleMoved(channel, side string, y float64) {
+    l.write(Event{Event: EventPaddleMoved, Channel: channel, Side: side, Y: &y})
+}
+
+// Scored records side scoring and the score after it
+func (l *EventLogger) Scored(channel, side string, left, right int) {
+    l.write(Event{Event: EventScored, Channel: channel, Side: side, Left: &left, Right: &right})
+}
+
+// GameOver records side winning a match with the final score
+func (l *EventLogger) GameOver(channel, winner string, left, right int) {
+    l.write(Event{Event: EventGameOver, Channel: channel, Side: winner, Left: &left, Right: &right})
+}
+
+func (l *EventLogger) write(e Event) {
+    e.At = time.Now().UTC()
+
+    l.mu.Lock()
+    defer l.mu.Unlock()
+    // Losing an event isn't worth interrupting the game for
+    _ = l.enc.Encode(e)
+}
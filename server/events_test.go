@@ -0,0 +1,45 @@
+package main
+
+import (
+    "bufio"
+    "bytes"
+    "encoding/json"
+    "reflect"
+    "testing"
+)
+
+func TestEventLoggerLines(t *testing.T) {
+    var buf bytes.Buffer
+    events := NewEventLogger(&buf)
+    events.PaddleMoved("chan", "left", 120)
+    events.Scored("chan", "right", 0, 1)
+    events.GameOver("chan", "right", 3, 11)
+
+    // Exact keys per kind, fields that don't apply must be left out
+    want := []map[string]any{
+        {"event": EventPaddleMoved, "channel": "chan", "side": "left", "y": 120.0},
+        {"event": EventScored, "channel": "chan", "side": "right", "left": 0.0, "right": 1.0},
+        {"event": EventGameOver, "channel": "chan", "side": "right", "left": 3.0, "right": 11.0},
+    }
+    scanner := bufio.NewScanner(&buf)
+    var i int
+    for ; scanner.Scan(); i++ {
+        if i >= len(want) {
+            t.Fatalf("extra line %s", scanner.Text())
+        }
+        var got map[string]any
+        if err := json.Unmarshal(scanner.Bytes(), &got); err != nil {
+            t.Fatalf("line %d: %v", i+1, err)
+        }
+        if _, ok := got["at"].(string); !ok {
+            t.Fatalf("line %d has no at: %s", i+1, scanner.Text())
+        }
+        delete(got, "at")
+        if !reflect.DeepEqual(got, want[i]) {
+            t.Fatalf("line %d = %v, want %v", i+1, got, want[i])
+        }
+    }
+    if i != len(want) {
+        t.Fatalf("%d lines, want %d", i, len(want))
+    }
+}
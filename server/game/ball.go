@@ -0,0 +1,229 @@
+package game
+
+import (
+    "fmt"
+    "math"
+    "math/rand"
+)
+
+// Ball settings
+const (
+    BallRadius = 10
+    // Pixels per second on each axis unless configured otherwise
+    BallSpeed = 300
+    // Slowest serve that can be configured
+    MinBallSpeed = 50
+    // Horizontal speed is multiplied by this on every paddle hit
+    BallSpeedRamp = 1.05
+    // Horizontal speed never goes past this, in pixels per second
+    MaxBallSpeed = 900
+)
+
+// Ball is the server authoritative ball, velocities are in pixels per second
+type Ball struct {
+    // Tells balls apart when several are in play
+    ID int     `json:"id"`
+    X  float64 `json:"x"`
+    Y  float64 `json:"y"`
+    VX float64 `json:"vx"`
+    VY float64 `json:"vy"`
+    // Paddle hits since the ball was served
+    Hits int `json:"-"`
+    // Set once the rally ran long enough to speed the ball up, until the
+    // point ends
+    SuddenDeath bool `json:"suddenDeath,omitempty"`
+}
+
+// NewBall returns a ball at the center of the canvas heading toward a
+// random side
+func NewBall(c Canvas, speed float64, rng *rand.Rand) Ball {
+    return ServeBall(c, RandomSide(rng), speed, rng)
+}
+
+// ServeBall returns a ball at the center of the canvas heading toward side
+// at a random angle, speed pixels per second on each axis at most
+func ServeBall(c Canvas, side string, speed float64, rng *rand.Rand) Ball {
+    vx := speed
+    if side == "left" {
+        vx = -vx
+    }
+    return Ball{
+        X:  c.Width / 2,
+        Y:  c.Height / 2,
+        VX: vx,
+        VY: speed * (2*rng.Float64() - 1),
+    }
+}
+
+// LaunchBall returns a ball at the center of the canvas moving at speed
+// pixels per second, angle degrees from straight right. Positive angles
+// head down the canvas, 180 is straight left. See ValidateLaunchAngle.
+func LaunchBall(c Canvas, speed, angle float64) Ball {
+    rad := angle * math.Pi / 180
+    return Ball{
+        X:  c.Width / 2,
+        Y:  c.Height / 2,
+        VX: speed * math.Cos(rad),
+        VY: speed * math.Sin(rad),
+    }
+}
+
+// Launch angles closer than this many degrees to straight up or down are
+// rejected, the ball would bounce between the walls without ever reaching
+// a paddle
+const LaunchAngleMargin = 15
+
+// ValidateLaunchAngle makes sure a launch angle in degrees heads toward one
+// of the sides
+func ValidateLaunchAngle(angle float64) error {
+    if angle < -180 || angle > 180 {
+        return fmt.Errorf("invalid launch angle %v: must be between -180 and 180", angle)
+    }
+    if math.Abs(math.Abs(angle)-90) < LaunchAngleMargin {
+        return fmt.Errorf("invalid launch angle %v: must be at least %v degrees from straight up or down", angle, LaunchAngleMargin)
+    }
+    return nil
+}
+
+// RandomSide picks left or right
+func RandomSide(rng *rand.Rand) string {
+    if rng.Intn(2) == 0 {
+        return "left"
+    }
+    return "right"
+}
+
+// PhysicsConfig is everything a ball step depends on besides the ball
+type PhysicsConfig struct {
+    Canvas Canvas
+    // Paddles as they are this tick, including how fast they move
+    Left, Right PaddlePosition
+    // Vertical speed is multiplied by this on every wall bounce, 1 keeps
+    // it as is
+    WallDamping float64
+    // Share of the paddle's vertical speed passed on to the ball on a hit
+    Spin float64
+    // Paddle hits after which the ball goes into sudden death, 0 never
+    SuddenDeathHits int
+    // Speed multiplier for sudden death, also raises the speed cap
+    SuddenDeathBoost float64
+    // Pixels added above and below each paddle when checking for a hit,
+    // forgives paddles that lag behind the ball
+    Tolerance float64
+    // Replace the right paddle with a wall along the canvas edge, for
+    // practicing alone. The left side can still concede.
+    RightWall bool
+}
+
+// StepBall advances the ball by dt seconds, bouncing it off the top and
+// bottom walls and off either paddle. The ball is allowed to leave the
+// canvas on the sides, which is a point for the other team, unless the
+// right side is a wall.
+func StepBall(b Ball, dt float64, cfg PhysicsConfig) Ball {
+    prevX := b.X
+    b.X += b.VX * dt
+    b.Y += b.VY * dt
+
+    // Top and bottom walls
+    if b.Y-BallRadius < 0 {
+        b.Y = BallRadius
+        b.VY = -b.VY * cfg.WallDamping
+    } else if b.Y+BallRadius > cfg.Canvas.Height {
+        b.Y = cfg.Canvas.Height - BallRadius
+        b.VY = -b.VY * cfg.WallDamping
+    }
+
+    limit := float64(MaxBallSpeed)
+    if b.SuddenDeath {
+        limit *= cfg.SuddenDeathBoost
+    }
+    if cfg.RightWall && b.VX > 0 && b.X+BallRadius > cfg.Canvas.Width {
+        b.X = cfg.Canvas.Width - BallRadius
+        b.VX = -b.VX
+        return b
+    }
+    if b.collideLeft(prevX, cfg, limit) || (!cfg.RightWall && b.collideRight(prevX, cfg, limit)) {
+        b.Hits++
+        // Long rallies speed up until someone misses
+        if cfg.SuddenDeathHits > 0 && !b.SuddenDeath && b.Hits > cfg.SuddenDeathHits {
+            b.SuddenDeath = true
+            b.VX *= cfg.SuddenDeathBoost
+            b.VY *= cfg.SuddenDeathBoost
+        }
+    }
+    return b
+}
+
+// Scorer returns the side that scored once the ball has fully left the
+// canvas, or an empty string while it is still in play
+func (b Ball) Scorer(c Canvas) string {
+    if b.X+BallRadius < 0 {
+        return "right"
+    }
+    if b.X-BallRadius > c.Width {
+        return "left"
+    }
+    return ""
+}
+
+// Face of the left paddle the ball bounces off
+const leftPaddlePlane = PaddleOffset + PaddleWidth
+
+// Face of the right paddle the ball bounces off
+func (c Canvas) rightPaddlePlane() float64 {
+    return c.Width - PaddleOffset - PaddleWidth
+}
+
+// The ball hits a paddle when its edge crosses the paddle face during the
+// step, so large steps at low tick rates can't tunnel through
+func (b *Ball) collideLeft(prevX float64, cfg PhysicsConfig, limit float64) bool {
+    if b.VX >= 0 {
+        return false
+    }
+    if prevX-BallRadius < leftPaddlePlane || b.X-BallRadius > leftPaddlePlane {
+        return false
+    }
+    if !overlapsPaddle(b.Y, cfg.Left, cfg.Tolerance) {
+        return false
+    }
+    b.X = leftPaddlePlane + BallRadius
+    b.reflect(cfg.Left, cfg.Spin, limit)
+    return true
+}
+
+func (b *Ball) collideRight(prevX float64, cfg PhysicsConfig, limit float64) bool {
+    if b.VX <= 0 {
+        return false
+    }
+    plane := cfg.Canvas.rightPaddlePlane()
+    if prevX+BallRadius > plane || b.X+BallRadius < plane {
+        return false
+    }
+    if !overlapsPaddle(b.Y, cfg.Right, cfg.Tolerance) {
+        return false
+    }
+    b.X = plane - BallRadius
+    b.reflect(cfg.Right, cfg.Spin, limit)
+    return true
+}
+
+// Paddle Y is the top of the paddle, tolerance stretches it both ways
+func overlapsPaddle(ballY float64, paddle PaddlePosition, tolerance float64) bool {
+    return ballY+BallRadius >= paddle.Y-tolerance && ballY-BallRadius <= paddle.Y+paddle.Size()+tolerance
+}
+
+// Send the ball back a little faster and push it up or down depending on
+// where it hit. Hitting the middle keeps VY as is, hitting an edge adds up
+// to PaddleInfluence in that direction, and a moving paddle adds spin in
+// the direction it moves. Horizontal speed stays under limit. Speed goes
+// back to BallSpeed on the next serve.
+func (b *Ball) reflect(paddle PaddlePosition, spin, limit float64) {
+    b.VX = -b.VX * BallSpeedRamp
+    if math.Abs(b.VX) > limit {
+        b.VX = math.Copysign(limit, b.VX)
+    }
+    half := paddle.Size() / 2
+    offset := (b.Y - (paddle.Y + half)) / half
+    offset = max(-1, min(1, offset))
+    b.VY += offset*PaddleInfluence + paddle.VY*spin
+}
@@ -0,0 +1,315 @@
+package game
+
+import (
+    "math"
+    "testing"
+)
+
+// Close enough for positions that went through a few float operations
+func near(a, b float64) bool {
+    return math.Abs(a-b) < 1e-9
+}
+
+func TestValidateLaunchAngle(t *testing.T) {
+    for _, tc := range []struct {
+        angle float64
+        ok    bool
+    }{
+        {0, true},
+        {180, true},
+        {-180, true},
+        {45, true},
+        {-75, true},
+        {90, false},
+        {-90, false},
+        {80, false},
+        {100, false},
+        {-95, false},
+        {181, false},
+        {-200, false},
+    } {
+        err := ValidateLaunchAngle(tc.angle)
+        if (err == nil) != tc.ok {
+            t.Errorf("ValidateLaunchAngle(%v) = %v, want ok %v", tc.angle, err, tc.ok)
+        }
+    }
+}
+
+func TestLaunchBallFirstFrame(t *testing.T) {
+    c := DefaultCanvas
+    ball := LaunchBall(c, 400, 30)
+    if !near(ball.X, c.Width/2) || !near(ball.Y, c.Height/2) {
+        t.Fatalf("launched from (%v, %v), want the center", ball.X, ball.Y)
+    }
+
+    dt := 1.0 / 60
+    cfg := PhysicsConfig{
+        Canvas:      c,
+        Left:        CenteredPaddle(c, "left", PaddleHeight),
+        Right:       CenteredPaddle(c, "right", PaddleHeight),
+        WallDamping: DefaultWallDamping,
+    }
+    next := StepBall(ball, dt, cfg)
+    wantX := c.Width/2 + 400*math.Cos(math.Pi/6)*dt
+    wantY := c.Height/2 + 400*math.Sin(math.Pi/6)*dt
+    if !near(next.X, wantX) || !near(next.Y, wantY) {
+        t.Fatalf("first frame at (%v, %v), want (%v, %v)", next.X, next.Y, wantX, wantY)
+    }
+}
+
+func TestLaunchBallStraightLeft(t *testing.T) {
+    ball := LaunchBall(DefaultCanvas, 300, 180)
+    if !near(ball.VX, -300) || !near(ball.VY, 0) {
+        t.Fatalf("velocity (%v, %v), want (-300, 0)", ball.VX, ball.VY)
+    }
+}
+
+func TestReflect(t *testing.T) {
+    paddle := PaddlePosition{Side: "left", Y: 200, Height: PaddleHeight}
+    for _, tc := range []struct {
+        name           string
+        ballY, vx, vy  float64
+        paddleVY, spin float64
+        limit          float64
+        wantVX, wantVY float64
+    }{
+        {"center", 250, -300, 0, 0, 0, MaxBallSpeed, 300 * BallSpeedRamp, 0},
+        {"top edge", 200, -300, 0, 0, 0, MaxBallSpeed, 300 * BallSpeedRamp, -PaddleInfluence},
+        {"bottom edge", 300, -300, 0, 0, 0, MaxBallSpeed, 300 * BallSpeedRamp, PaddleInfluence},
+        {"past the edge", 320, -300, 0, 0, 0, MaxBallSpeed, 300 * BallSpeedRamp, PaddleInfluence},
+        {"halfway up", 225, -300, 10, 0, 0, MaxBallSpeed, 300 * BallSpeedRamp, 10 - PaddleInfluence/2},
+        {"right paddle", 250, 300, 0, 0, 0, MaxBallSpeed, -300 * BallSpeedRamp, 0},
+        {"capped", 250, -MaxBallSpeed, 0, 0, 0, MaxBallSpeed, MaxBallSpeed, 0},
+        {"spin", 250, -300, 0, 100, 0.5, MaxBallSpeed, 300 * BallSpeedRamp, 50},
+    } {
+        paddle.VY = tc.paddleVY
+        b := Ball{Y: tc.ballY, VX: tc.vx, VY: tc.vy}
+        b.reflect(paddle, tc.spin, tc.limit)
+        if !near(b.VX, tc.wantVX) || !near(b.VY, tc.wantVY) {
+            t.Errorf("%s: velocity (%v, %v), want (%v, %v)", tc.name, b.VX, b.VY, tc.wantVX, tc.wantVY)
+        }
+    }
+}
+
+// Paddles centered on a canvas, ready for StepBall
+func testPhysics() PhysicsConfig {
+    c := DefaultCanvas
+    return PhysicsConfig{
+        Canvas:      c,
+        Left:        CenteredPaddle(c, "left", PaddleHeight),
+        Right:       CenteredPaddle(c, "right", PaddleHeight),
+        WallDamping: DefaultWallDamping,
+    }
+}
+
+func TestRallySpeedsUpToCap(t *testing.T) {
+    cfg := testPhysics()
+    // Straight down the middle so every shot meets a paddle
+    ball := Ball{X: cfg.Canvas.Width / 2, Y: cfg.Canvas.Height / 2, VX: BallSpeed}
+
+    speed := math.Abs(ball.VX)
+    for hits := 0; hits < 40; {
+        ball = StepBall(ball, 1.0/120, cfg)
+        if ball.Scorer(cfg.Canvas) != "" {
+            t.Fatalf("ball got past a paddle after %d hits", hits)
+        }
+        if ball.Hits == hits {
+            continue
+        }
+        hits = ball.Hits
+        next := math.Abs(ball.VX)
+        if next < speed || next > MaxBallSpeed {
+            t.Fatalf("hit %d: speed %v after %v, want it to grow up to %d", hits, next, speed, MaxBallSpeed)
+        }
+        speed = next
+    }
+    if speed != MaxBallSpeed {
+        t.Fatalf("speed %v after 40 hits, want the %d cap", speed, MaxBallSpeed)
+    }
+}
+
+func TestTallerPaddleHitsMore(t *testing.T) {
+    cfg := testPhysics()
+    plane := cfg.Canvas.rightPaddlePlane()
+    // Below the bottom of a standard paddle but within a 200 high one
+    ballY := cfg.Right.Y + PaddleHeight + 50
+    ball := Ball{X: plane - BallRadius - 2, Y: ballY, VX: BallSpeed}
+
+    if next := StepBall(ball, 1.0/60, cfg); next.VX < 0 {
+        t.Fatalf("standard paddle returned a ball %v below it", ballY-cfg.Right.Y-PaddleHeight)
+    }
+    cfg.Right.Height = 2 * PaddleHeight
+    if next := StepBall(ball, 1.0/60, cfg); next.VX >= 0 {
+        t.Fatalf("taller paddle missed the ball at y %v", ballY)
+    }
+}
+
+func TestWallDamping(t *testing.T) {
+    cfg := testPhysics()
+    for _, tc := range []struct {
+        name    string
+        y, vy   float64
+        damping float64
+        wantY   float64
+        wantVY  float64
+    }{
+        {"top, undamped", BallRadius + 1, -300, 1, BallRadius, 300},
+        {"top, halved", BallRadius + 1, -300, 0.5, BallRadius, 150},
+        {"bottom, halved", cfg.Canvas.Height - BallRadius - 1, 300, 0.5, cfg.Canvas.Height - BallRadius, -150},
+        {"bottom, dead", cfg.Canvas.Height - BallRadius - 1, 300, 0, cfg.Canvas.Height - BallRadius, 0},
+    } {
+        cfg.WallDamping = tc.damping
+        ball := Ball{X: cfg.Canvas.Width / 2, Y: tc.y, VX: BallSpeed, VY: tc.vy}
+        next := StepBall(ball, 1.0/60, cfg)
+        if !near(next.Y, tc.wantY) || !near(next.VY, tc.wantVY) {
+            t.Errorf("%s: y %v vy %v, want y %v vy %v", tc.name, next.Y, next.VY, tc.wantY, tc.wantVY)
+        }
+    }
+}
+
+func TestPaddleSpin(t *testing.T) {
+    for _, tc := range []struct {
+        name     string
+        spin     float64
+        paddleVY float64
+        wantVY   float64
+    }{
+        {"no spin", 0, 200, 0},
+        {"paddle still", 0.5, 0, 0},
+        {"moving down", 0.25, 200, 50},
+        {"moving up", 0.5, -200, -100},
+    } {
+        cfg := testPhysics()
+        cfg.Spin = tc.spin
+        cfg.Left.VY = tc.paddleVY
+        // Dead center of the left paddle, about to reach its face
+        ball := Ball{X: leftPaddlePlane + BallRadius + 2, Y: cfg.Left.Y + cfg.Left.Size()/2, VX: -BallSpeed}
+        next := StepBall(ball, 1.0/60, cfg)
+        if next.Hits != 1 {
+            t.Fatalf("%s: ball missed the paddle", tc.name)
+        }
+        if !near(next.VY, tc.wantVY) {
+            t.Errorf("%s: vy %v, want %v", tc.name, next.VY, tc.wantVY)
+        }
+    }
+}
+
+func TestStepBallMoves(t *testing.T) {
+    cfg := testPhysics()
+    ball := Ball{X: 400, Y: 300, VX: 120, VY: -60}
+    next := StepBall(ball, 0.5, cfg)
+    if !near(next.X, 460) || !near(next.Y, 270) || next.VX != 120 || next.VY != -60 {
+        t.Fatalf("stepped to %+v, want (460, 270) at the same velocity", next)
+    }
+    if next.Hits != 0 {
+        t.Fatalf("hits = %d in open space", next.Hits)
+    }
+}
+
+func TestStepBallMissesPaddle(t *testing.T) {
+    cfg := testPhysics()
+    // Well above the left paddle, nothing stops it
+    ball := Ball{X: leftPaddlePlane + BallRadius + 2, Y: cfg.Left.Y - 3*BallRadius, VX: -BallSpeed}
+    for i := 0; i < 60 && ball.Scorer(cfg.Canvas) == ""; i++ {
+        ball = StepBall(ball, 1.0/60, cfg)
+        if ball.VX > 0 {
+            t.Fatalf("ball bounced off a paddle it missed")
+        }
+    }
+    if side := ball.Scorer(cfg.Canvas); side != "right" {
+        t.Fatalf("scorer = %q, want right", side)
+    }
+}
+
+func TestScorer(t *testing.T) {
+    c := DefaultCanvas
+    for _, tc := range []struct {
+        x    float64
+        want string
+    }{
+        {c.Width / 2, ""},
+        {0, ""},
+        // Partly out is still in play
+        {-BallRadius + 1, ""},
+        {-BallRadius - 1, "right"},
+        {c.Width + BallRadius - 1, ""},
+        {c.Width + BallRadius + 1, "left"},
+    } {
+        if got := (Ball{X: tc.x, Y: c.Height / 2}).Scorer(c); got != tc.want {
+            t.Errorf("Scorer at x %v = %q, want %q", tc.x, got, tc.want)
+        }
+    }
+}
+
+func TestSuddenDeathAfterLongRally(t *testing.T) {
+    cfg := testPhysics()
+    cfg.SuddenDeathHits = 3
+    cfg.SuddenDeathBoost = 1.5
+    ball := Ball{X: cfg.Canvas.Width / 2, Y: cfg.Canvas.Height / 2, VX: BallSpeed}
+
+    for hits := 0; hits <= cfg.SuddenDeathHits+1; {
+        prev := ball
+        ball = StepBall(ball, 1.0/120, cfg)
+        if ball.Scorer(cfg.Canvas) != "" {
+            t.Fatalf("ball got past a paddle after %d hits", hits)
+        }
+        if ball.Hits == hits {
+            continue
+        }
+        hits = ball.Hits
+        if want := hits > cfg.SuddenDeathHits; ball.SuddenDeath != want {
+            t.Fatalf("sudden death %v after %d hits, want %v", ball.SuddenDeath, hits, want)
+        }
+        // The hit that starts it boosts on top of the usual speed up
+        want := math.Abs(prev.VX) * BallSpeedRamp
+        if hits == cfg.SuddenDeathHits+1 {
+            want *= cfg.SuddenDeathBoost
+        }
+        if !near(math.Abs(ball.VX), want) {
+            t.Fatalf("hit %d: speed %v, want %v", hits, math.Abs(ball.VX), want)
+        }
+    }
+}
+
+func TestCollisionTolerance(t *testing.T) {
+    cfg := testPhysics()
+    // Just clear of the top of the left paddle, a lagging paddle's near miss
+    ball := Ball{X: leftPaddlePlane + BallRadius + 2, Y: cfg.Left.Y - BallRadius - 5, VX: -BallSpeed}
+
+    for _, tc := range []struct {
+        tolerance float64
+        hit       bool
+    }{
+        {0, false},
+        {4, false},
+        {5, true},
+        {10, true},
+    } {
+        cfg.Tolerance = tc.tolerance
+        next := StepBall(ball, 1.0/60, cfg)
+        if hit := next.VX > 0; hit != tc.hit {
+            t.Errorf("tolerance %v: hit %v, want %v", tc.tolerance, hit, tc.hit)
+        }
+    }
+}
+
+func TestRightWallBouncesBack(t *testing.T) {
+    cfg := testPhysics()
+    cfg.RightWall = true
+    // Past where the right paddle would be, the wall still sends it back
+    ball := Ball{X: cfg.Canvas.Width - BallRadius - 1, Y: 50, VX: BallSpeed, VY: 30}
+
+    next := StepBall(ball, 1.0/60, cfg)
+    if next.VX != -BallSpeed || next.VY != 30 || next.X != cfg.Canvas.Width-BallRadius {
+        t.Fatalf("off the wall at x %v with (%v, %v), want x %v with (%v, 30)", next.X, next.VX, next.VY, cfg.Canvas.Width-BallRadius, -BallSpeed)
+    }
+    if side := next.Scorer(cfg.Canvas); side != "" {
+        t.Fatalf("wall bounce scored for %q", side)
+    }
+
+    // The left side can still concede
+    ball = Ball{X: -BallRadius + 1, Y: 50, VX: -BallSpeed}
+    if side := StepBall(ball, 1.0/60, cfg).Scorer(cfg.Canvas); side != "right" {
+        t.Fatalf("ball past the left edge scored for %q, want right", side)
+    }
+}
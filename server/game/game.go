@@ -0,0 +1,25 @@
+// Package game holds the pong rules: the shared state, ball physics and
+// scoring. It knows nothing about websockets or HTTP, package main wires
+// the transport around it.
+package game
+
+// Canvas is the size of the playing field
+type Canvas struct {
+    Width  float64 `json:"width"`
+    Height float64 `json:"height"`
+}
+
+// Canvas size the frontend renders at unless configured otherwise
+var DefaultCanvas = Canvas{Width: 800, Height: 600}
+
+// Points needed to win a match unless configured otherwise
+const DefaultWinScore = 11
+
+// Physics tuning used unless configured otherwise, both off
+const (
+    DefaultWallDamping = 1
+    DefaultPaddleSpin  = 0
+)
+
+// Sudden death speed multiplier unless configured otherwise
+const DefaultSuddenDeathBoost = 1.5
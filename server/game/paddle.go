@@ -0,0 +1,105 @@
+package game
+
+import (
+    "errors"
+    "fmt"
+    "math"
+    "strings"
+)
+
+// Paddle settings, matching the frontend
+const (
+    PaddleWidth = 20
+    // Height paddles start at unless configured otherwise
+    PaddleHeight = 100
+    // Range paddles can be resized to for handicap matches
+    MinPaddleHeight = 20
+    MaxPaddleHeight = 300
+    // Gap between the canvas edge and the paddle
+    PaddleOffset = 20
+    // Max vertical speed in pixels per second added when the ball hits the
+    // paddle edge
+    PaddleInfluence = 240
+)
+
+// PaddlePosition is where a paddle currently sits on the canvas
+type PaddlePosition struct {
+    Y    float64 `json:"y"`
+    Side string  `json:"side"`
+    // Set by the server, whatever clients send here is ignored
+    Height float64 `json:"height,omitempty"`
+    // Vertical speed in pixels per second this tick, set by the server
+    VY float64 `json:"vy,omitempty"`
+    // Optional client sequence number, increasing per connection. The
+    // paddle carries the seq of the last input applied to it so clients
+    // predicting locally can reconcile.
+    Seq uint64 `json:"seq,omitempty"`
+    // Optional client hint in pixels per second of where the paddle is
+    // heading, for touch input that updates less often than it moves. The
+    // server keeps the paddle going between updates and echoes the hint
+    // back while it lasts.
+    Velocity float64 `json:"velocity,omitempty"`
+    // Optional client clock in milliseconds when the input was made.
+    // Only used to drop input that arrives out of order, never sent back.
+    Timestamp int64 `json:"ts,omitempty"`
+}
+
+// Errors returned by PaddlePosition.Validate
+var (
+    ErrInvalidY    = errors.New("invalid paddle y")
+    ErrInvalidSide = errors.New("invalid paddle side")
+)
+
+// Validate makes sure a paddle of size paddleHeight is fully on a canvas of
+// the given height and belongs to a side we have. Side is matched
+// case-insensitively, callers should lowercase it before storing.
+func (p PaddlePosition) Validate(height, paddleHeight float64) error {
+    if err := ValidateSide(p.Side); err != nil {
+        return err
+    }
+    if p.Y < 0 || p.Y > height-paddleHeight {
+        return fmt.Errorf("%w %v: must be between 0 and %v", ErrInvalidY, p.Y, height-paddleHeight)
+    }
+    return nil
+}
+
+// ClampY keeps a paddle of size paddleHeight fully on a canvas of the given
+// height
+func ClampY(y, height, paddleHeight float64) float64 {
+    return max(0, min(height-paddleHeight, y))
+}
+
+// ValidateSide makes sure side is left or right in any case
+func ValidateSide(side string) error {
+    if !strings.EqualFold(side, "left") && !strings.EqualFold(side, "right") {
+        return fmt.Errorf("%w %q: must be \"left\" or \"right\"", ErrInvalidSide, side)
+    }
+    return nil
+}
+
+// Size is the paddle's height, states saved before paddles could be
+// resized don't have one
+func (p PaddlePosition) Size() float64 {
+    if p.Height == 0 {
+        return PaddleHeight
+    }
+    return p.Height
+}
+
+// CenteredPaddle returns a paddle of height for side, vertically centered
+// on the canvas
+func CenteredPaddle(c Canvas, side string, height float64) PaddlePosition {
+    return PaddlePosition{
+        Y:      c.Height/2 - height/2,
+        Side:   side,
+        Height: height,
+    }
+}
+
+// Approach moves from toward to by at most maxDelta
+func Approach(from, to, maxDelta float64) float64 {
+    if math.Abs(to-from) <= maxDelta {
+        return to
+    }
+    return from + math.Copysign(maxDelta, to-from)
+}
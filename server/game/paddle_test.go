@@ -0,0 +1,67 @@
+package game
+
+import (
+    "errors"
+    "testing"
+)
+
+func TestValidateY(t *testing.T) {
+    tests := []struct {
+        y       float64
+        wantErr bool
+    }{
+        {-1, true},
+        {601, true},
+        {300, false},
+        {DefaultCanvas.Height - PaddleHeight, false},
+        {DefaultCanvas.Height - PaddleHeight + 1, true},
+    }
+    for _, tt := range tests {
+        err := PaddlePosition{Side: "left", Y: tt.y}.Validate(DefaultCanvas.Height, PaddleHeight)
+        if (err != nil) != tt.wantErr {
+            t.Fatalf("Validate(Y=%v) = %v, want error %v", tt.y, err, tt.wantErr)
+        }
+        if err != nil && !errors.Is(err, ErrInvalidY) {
+            t.Fatalf("Validate(Y=%v) = %v, want ErrInvalidY", tt.y, err)
+        }
+    }
+}
+
+func TestValidateSide(t *testing.T) {
+    for _, tc := range []struct {
+        side    string
+        wantErr bool
+    }{
+        {"left", false},
+        {"LEFT", false},
+        {"Right", false},
+        {"middle", true},
+        {"", true},
+    } {
+        err := PaddlePosition{Side: tc.side, Y: 300}.Validate(DefaultCanvas.Height, PaddleHeight)
+        if (err != nil) != tc.wantErr {
+            t.Fatalf("Validate(Side=%q) = %v, want error %v", tc.side, err, tc.wantErr)
+        }
+        if err != nil && !errors.Is(err, ErrInvalidSide) {
+            t.Fatalf("Validate(Side=%q) = %v, want ErrInvalidSide", tc.side, err)
+        }
+    }
+}
+
+func TestCenteredPaddle(t *testing.T) {
+    for _, tc := range []struct {
+        canvasHeight, height float64
+        want                 float64
+    }{
+        {600, PaddleHeight, 250},
+        {400, PaddleHeight, 150},
+        {400, 80, 160},
+        {1080, 200, 440},
+    } {
+        c := Canvas{Width: 800, Height: tc.canvasHeight}
+        p := CenteredPaddle(c, "right", tc.height)
+        if p.Y != tc.want || p.Height != tc.height || p.Side != "right" {
+            t.Errorf("CenteredPaddle(height %v on %v) = %+v, want y %v", tc.height, tc.canvasHeight, p, tc.want)
+        }
+    }
+}
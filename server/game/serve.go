@@ -0,0 +1,41 @@
+package game
+
+import "fmt"
+
+// ServeTarget decides which side the ball heads to after a point
+type ServeTarget string
+
+const (
+    // Toward the side that was just scored on, like classic pong
+    ServeLoser ServeTarget = "loser"
+    // Toward the side that just scored
+    ServeWinner ServeTarget = "winner"
+)
+
+// ParseServeTarget reads a serve target, falling back to the loser when
+// empty
+func ParseServeTarget(v string) (ServeTarget, error) {
+    switch target := ServeTarget(v); target {
+    case "":
+        return ServeLoser, nil
+    case ServeLoser, ServeWinner:
+        return target, nil
+    }
+    return "", fmt.Errorf("invalid serve target %q: must be %q or %q", v, ServeLoser, ServeWinner)
+}
+
+// Side returns the side to serve toward after scorer scored
+func (t ServeTarget) Side(scorer string) string {
+    if t == ServeWinner {
+        return scorer
+    }
+    return OtherSide(scorer)
+}
+
+// OtherSide returns the side across the net from side
+func OtherSide(side string) string {
+    if side == "left" {
+        return "right"
+    }
+    return "left"
+}
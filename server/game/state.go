@@ -0,0 +1,58 @@
+package game
+
+import "slices"
+
+// State is the shared state every client renders
+type State struct {
+    LeftPaddle  PaddlePosition `json:"leftPaddle"`
+    RightPaddle PaddlePosition `json:"rightPaddle"`
+    // Where players want each paddle, the paddles move there over a few
+    // ticks
+    LeftTarget  float64 `json:"leftTarget"`
+    RightTarget float64 `json:"rightTarget"`
+    Balls       []Ball  `json:"balls"`
+    LeftScore   int     `json:"leftScore"`
+    RightScore  int     `json:"rightScore"`
+    Paused      bool    `json:"paused"`
+    // Seconds left before the ball is served, it sits at the center until
+    // then
+    Countdown float64 `json:"countdown,omitempty"`
+}
+
+// Clone returns a copy that shares nothing with s, for handing state out
+// from under a lock
+func (s State) Clone() State {
+    s.Balls = slices.Clone(s.Balls)
+    return s
+}
+
+// Equal reports whether s and o would render the same
+func (s State) Equal(o State) bool {
+    return s.LeftPaddle == o.LeftPaddle &&
+        s.RightPaddle == o.RightPaddle &&
+        s.LeftTarget == o.LeftTarget &&
+        s.RightTarget == o.RightTarget &&
+        slices.Equal(s.Balls, o.Balls) &&
+        s.LeftScore == o.LeftScore &&
+        s.RightScore == o.RightScore &&
+        s.Paused == o.Paused &&
+        s.Countdown == o.Countdown
+}
+
+// Score awards a point to side and reports whether that won the match.
+// The final score is left in place for the caller to announce before it
+// calls NewMatch.
+func (s *State) Score(side string, winScore int) bool {
+    if side == "left" {
+        s.LeftScore++
+    } else {
+        s.RightScore++
+    }
+    return s.LeftScore >= winScore || s.RightScore >= winScore
+}
+
+// NewMatch puts the score back to zero
+func (s *State) NewMatch() {
+    s.LeftScore = 0
+    s.RightScore = 0
+}
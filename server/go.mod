@@ -4,5 +4,8 @@ go 1.21
 
 require (
 	github.com/gorilla/websocket v1.5.3
+	github.com/vmihailenco/msgpack/v5 v5.4.1
 	golang.org/x/exp v0.0.0-20230905200255-921286631fa9
 )
+
+require github.com/vmihailenco/tagparser/v2 v2.0.0 // indirect
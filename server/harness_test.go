@@ -0,0 +1,343 @@
+package main
+
+import (
+    "bytes"
+    "context"
+    "encoding/json"
+    "io"
+    "net"
+    "net/http"
+    "net/http/httptest"
+    "os"
+    "strings"
+    "sync"
+    "testing"
+    "time"
+
+    "github.com/ThePrimeagen/twitch-ext-pong/server/game"
+    "github.com/gorilla/websocket"
+    "golang.org/x/exp/slog"
+)
+
+// How long a test waits for a message before giving up
+const testTimeout = 2 * time.Second
+
+func TestMain(m *testing.M) {
+    // Tests that fail say why, the server's own logs are noise
+    slog.SetDefault(slog.New(slog.NewTextHandler(io.Discard, nil)))
+    os.Exit(m.Run())
+}
+
+// Log output the server goroutines write to concurrently
+type logBuffer struct {
+    mu  sync.Mutex
+    buf bytes.Buffer
+}
+
+func (b *logBuffer) Write(p []byte) (int, error) {
+    b.mu.Lock()
+    defer b.mu.Unlock()
+    return b.buf.Write(p)
+}
+
+func (b *logBuffer) String() string {
+    b.mu.Lock()
+    defer b.mu.Unlock()
+    return b.buf.String()
+}
+
+// captureLogs sends JSON logs to the returned buffer until the test ends
+func captureLogs(t *testing.T) *logBuffer {
+    t.Helper()
+    logs := &logBuffer{}
+    previous := slog.Default()
+    slog.SetDefault(slog.New(slog.NewJSONHandler(logs, &slog.HandlerOptions{Level: slog.LevelDebug})))
+    t.Cleanup(func() {
+        slog.SetDefault(previous)
+    })
+    return logs
+}
+
+// A running server behind a real listener, stopped when the test ends
+type testServer struct {
+    *Server
+    http *httptest.Server
+    // Cancels the base context of every request, like main does on
+    // shutdown
+    cancel context.CancelFunc
+}
+
+func newTestServer(t *testing.T, cfg Config) *testServer {
+    t.Helper()
+    s := NewServer(cfg, NewMemoryStore())
+    s.Start()
+    ctx, cancel := context.WithCancel(context.Background())
+    ts := httptest.NewUnstartedServer(s.Handler())
+    ts.Config.BaseContext = func(net.Listener) context.Context { return ctx }
+    // Same limits main puts on the real server
+    ts.Config.ReadHeaderTimeout = cfg.HTTPReadHeaderTimeout
+    ts.Config.WriteTimeout = cfg.HTTPWriteTimeout
+    ts.Config.IdleTimeout = cfg.HTTPIdleTimeout
+    ts.Start()
+    t.Cleanup(func() {
+        cancel()
+        ts.Close()
+        s.Stop()
+    })
+    return &testServer{Server: s, http: ts, cancel: cancel}
+}
+
+// testConfig is DefaultConfig without anything read from disk
+func testConfig() Config {
+    cfg := DefaultConfig()
+    cfg.StaticDir = ""
+    cfg.RoomsFile = ""
+    return cfg
+}
+
+// One websocket connection to a testServer
+type testClient struct {
+    t    *testing.T
+    conn *websocket.Conn
+}
+
+// dial connects to /ws with the given query string, failing the test if
+// the upgrade doesn't go through
+func (ts *testServer) dial(t *testing.T, query string) *testClient {
+    t.Helper()
+    c, resp, err := ts.dialWith(t, query, nil)
+    if err != nil {
+        status := 0
+        if resp != nil {
+            status = resp.StatusCode
+        }
+        t.Fatalf("dial %q: %v (status %d)", query, err, status)
+    }
+    return c
+}
+
+// dialWith is dial with extra handshake headers, returning the error and
+// response instead of failing so rejections can be tested
+func (ts *testServer) dialWith(t *testing.T, query string, header http.Header) (*testClient, *http.Response, error) {
+    t.Helper()
+    return ts.dialer(t, websocket.DefaultDialer, "/ws", query, header)
+}
+
+// dialer connects to path with d, for handshakes the default dialer
+// doesn't do
+func (ts *testServer) dialer(t *testing.T, d *websocket.Dialer, path, query string, header http.Header) (*testClient, *http.Response, error) {
+    t.Helper()
+    url := "ws" + strings.TrimPrefix(ts.http.URL, "http") + path
+    if query != "" {
+        url += "?" + query
+    }
+    conn, resp, err := d.Dial(url, header)
+    if err != nil {
+        return nil, resp, err
+    }
+    t.Cleanup(func() {
+        conn.Close()
+    })
+    return &testClient{t: t, conn: conn}, resp, nil
+}
+
+// get fetches path from the server's http side
+func (ts *testServer) get(t *testing.T, path string) *http.Response {
+    t.Helper()
+    resp, err := http.Get(ts.http.URL + path)
+    if err != nil {
+        t.Fatalf("GET %s: %v", path, err)
+    }
+    t.Cleanup(func() {
+        resp.Body.Close()
+    })
+    return resp
+}
+
+// room returns the room for channel once a connection has created it
+func (ts *testServer) room(t *testing.T, channel string) *Room {
+    t.Helper()
+    var room *Room
+    eventually(t, func() bool {
+        ts.RLock()
+        room = ts.rooms[channel]
+        ts.RUnlock()
+        return room != nil
+    })
+    return room
+}
+
+// eventually polls cond until it holds or the test times out
+func eventually(t *testing.T, cond func() bool) {
+    t.Helper()
+    deadline := time.Now().Add(testTimeout)
+    for !cond() {
+        if time.Now().After(deadline) {
+            t.Fatalf("condition not met within %s", testTimeout)
+        }
+        time.Sleep(5 * time.Millisecond)
+    }
+}
+
+// send writes a message with v as its payload
+func (c *testClient) send(typ MessageType, v any) {
+    c.t.Helper()
+    c.sendRaw(frame(c.t, typ, v))
+}
+
+// sendRaw writes data as a text frame, for garbage the server should
+// survive
+func (c *testClient) sendRaw(data []byte) {
+    c.t.Helper()
+    if err := c.conn.WriteMessage(websocket.TextMessage, data); err != nil {
+        c.t.Fatalf("write: %v", err)
+    }
+}
+
+// receive reads the next message, failing if none arrives in time
+func (c *testClient) receive() Message {
+    c.t.Helper()
+    msg, err := c.tryReceive(testTimeout)
+    if err != nil {
+        c.t.Fatalf("receive: %v", err)
+    }
+    return msg
+}
+
+func (c *testClient) tryReceive(timeout time.Duration) (Message, error) {
+    c.conn.SetReadDeadline(time.Now().Add(timeout))
+    var msg Message
+    _, data, err := c.conn.ReadMessage()
+    if err != nil {
+        return msg, err
+    }
+    err = json.Unmarshal(data, &msg)
+    return msg, err
+}
+
+// expect skips messages until one of type typ arrives, failing if it
+// doesn't within the timeout
+func (c *testClient) expect(typ MessageType) Message {
+    c.t.Helper()
+    return c.expectMatch(typ, func(Message) bool { return true })
+}
+
+// expectMatch skips messages until one of type typ that match accepts
+// arrives
+func (c *testClient) expectMatch(typ MessageType, match func(Message) bool) Message {
+    c.t.Helper()
+    deadline := time.Now().Add(testTimeout)
+    for {
+        msg, err := c.tryReceive(time.Until(deadline))
+        if err != nil {
+            c.t.Fatalf("waiting for %s: %v", typ, err)
+        }
+        if msg.Type == typ && match(msg) {
+            return msg
+        }
+    }
+}
+
+// expectState waits for a state_update that match accepts
+func (c *testClient) expectState(match func(game.State) bool) game.State {
+    c.t.Helper()
+    var state game.State
+    c.expectMatch(TypeStateUpdate, func(msg Message) bool {
+        state = game.State{}
+        return json.Unmarshal(msg.Payload, &state) == nil && match(state)
+    })
+    return state
+}
+
+// expectError waits for an error message and checks its code
+func (c *testClient) expectError(code ErrorCode) ErrorPayload {
+    c.t.Helper()
+    payload := decode[ErrorPayload](c.t, c.expect(TypeError))
+    if payload.Code != code {
+        c.t.Fatalf("error code = %q (%s), want %q", payload.Code, payload.Message, code)
+    }
+    return payload
+}
+
+// expectNone fails if a message of type typ arrives within d
+func (c *testClient) expectNone(typ MessageType, d time.Duration) {
+    c.t.Helper()
+    deadline := time.Now().Add(d)
+    for time.Now().Before(deadline) {
+        msg, err := c.tryReceive(time.Until(deadline))
+        if err != nil {
+            return
+        }
+        if msg.Type == typ {
+            c.t.Fatalf("got unexpected %s: %s", typ, msg.Payload)
+        }
+    }
+}
+
+// expectClosed reads until the server closes the connection, failing if
+// it stays open past the timeout. Returns the close error.
+func (c *testClient) expectClosed() error {
+    c.t.Helper()
+    deadline := time.Now().Add(testTimeout)
+    for {
+        c.conn.SetReadDeadline(deadline)
+        if _, _, err := c.conn.ReadMessage(); err != nil {
+            if time.Now().After(deadline) {
+                c.t.Fatalf("connection still open after %s", testTimeout)
+            }
+            return err
+        }
+    }
+}
+
+// decode unmarshals the payload of msg into v
+func decode[T any](t *testing.T, msg Message) T {
+    t.Helper()
+    var v T
+    if err := json.Unmarshal(msg.Payload, &v); err != nil {
+        t.Fatalf("decode %s: %v", msg.Type, err)
+    }
+    return v
+}
+
+func TestInitialState(t *testing.T) {
+    ts := newTestServer(t, testConfig())
+    c := ts.dial(t, "channel=harness")
+
+    initial := decode[InitialState](t, c.expect(TypeInitialState))
+    if initial.Canvas != ts.cfg.Canvas {
+        t.Fatalf("canvas = %+v, want %+v", initial.Canvas, ts.cfg.Canvas)
+    }
+    if initial.ConnectionID == "" {
+        t.Fatalf("initial state has no connection id")
+    }
+    if initial.LeftScore != 0 || initial.RightScore != 0 {
+        t.Fatalf("score = %d-%d, want 0-0", initial.LeftScore, initial.RightScore)
+    }
+    if len(initial.Balls) == 0 {
+        t.Fatalf("initial state has no ball")
+    }
+}
+
+func TestPaddleBroadcast(t *testing.T) {
+    ts := newTestServer(t, testConfig())
+    player := ts.dial(t, "channel=harness")
+    watcher := ts.dial(t, "channel=harness&role=spectator")
+    player.expect(TypeInitialState)
+    watcher.expect(TypeInitialState)
+
+    player.send(TypeTeamAssign, TeamAssignment{Team: "left"})
+    assigned := decode[TeamAssignment](t, player.expect(TypeTeamAssign))
+    if assigned.Team != "left" {
+        t.Fatalf("team = %q, want left", assigned.Team)
+    }
+
+    const y = 100
+    player.send(TypePaddleUpdate, game.PaddlePosition{Side: "left", Y: y})
+    state := watcher.expectState(func(s game.State) bool {
+        return s.LeftPaddle.Y == y
+    })
+    if state.LeftTarget != y {
+        t.Fatalf("left target = %v, want %v", state.LeftTarget, y)
+    }
+}
@@ -0,0 +1,37 @@
+package main
+
+import (
+    "encoding/json"
+    "net/http"
+    "time"
+)
+
+// Health is the body returned by /healthz
+type Health struct {
+    Status        string `json:"status"`
+    Connections   int64  `json:"connections"`
+    UptimeSeconds int64  `json:"uptime_seconds"`
+}
+
+// handleHealth reports 200 while the server is running and 503 once the
+// game loops have stopped or we are shutting down. Only reads atomics so it never
+// waits on the server mutex.
+func (s *Server) handleHealth(w http.ResponseWriter, r *http.Request) {
+    health := Health{
+        Status:        "ok",
+        Connections:   s.connectionCount.Load(),
+        UptimeSeconds: int64(time.Since(s.startedAt).Seconds()),
+    }
+    status := http.StatusOK
+    if s.shuttingDown.Load() {
+        health.Status = "shutting_down"
+        status = http.StatusServiceUnavailable
+    } else if !s.running.Load() {
+        health.Status = "game_loop_stopped"
+        status = http.StatusServiceUnavailable
+    }
+
+    w.Header().Set("Content-Type", "application/json")
+    w.WriteHeader(status)
+    json.NewEncoder(w).Encode(health)
+}
@@ -0,0 +1,37 @@
+package main
+
+import (
+    "encoding/json"
+    "net/http"
+    "testing"
+)
+
+func TestHealth(t *testing.T) {
+    ts := newTestServer(t, testConfig())
+
+    check := func(wantStatus int, want string) {
+        t.Helper()
+        resp := ts.get(t, "/healthz")
+        if resp.StatusCode != wantStatus {
+            t.Fatalf("status = %d, want %d", resp.StatusCode, wantStatus)
+        }
+        var body map[string]any
+        if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+            t.Fatalf("decode: %v", err)
+        }
+        if body["status"] != want {
+            t.Fatalf("status field = %v, want %q", body["status"], want)
+        }
+        for _, key := range []string{"connections", "uptime_seconds"} {
+            if _, ok := body[key].(float64); !ok {
+                t.Fatalf("%s = %v, want a number", key, body[key])
+            }
+        }
+    }
+
+    check(http.StatusOK, "ok")
+    ts.running.Store(false)
+    check(http.StatusServiceUnavailable, "game_loop_stopped")
+    ts.shuttingDown.Store(true)
+    check(http.StatusServiceUnavailable, "shutting_down")
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+    "encoding/json"
+    "net/http"
+    "time"
+)
+
+// Finished matches kept per room unless configured otherwise
+const DefaultHistorySize = 20
+
+// MatchResult is a finished match in /history
+type MatchResult struct {
+    Winner          string    `json:"winner"`
+    Left            int       `json:"left"`
+    Right           int       `json:"right"`
+    StartedAt       time.Time `json:"started_at"`
+    EndedAt         time.Time `json:"ended_at"`
+    DurationSeconds int64     `json:"duration_seconds"`
+}
+
+// History is a ring buffer of a room's last finished matches. Protected by
+// the room mutex.
+type History struct {
+    results []MatchResult
+    // Slot the next result goes in
+    next int
+    // Set once the buffer wrapped and every slot is used
+    full bool
+}
+
+func NewHistory(size int) *History {
+    return &History{results: make([]MatchResult, size)}
+}
+
+// Add records a result, evicting the oldest once the buffer is full
+func (h *History) Add(result MatchResult) {
+    h.results[h.next] = result
+    h.next = (h.next + 1) % len(h.results)
+    if h.next == 0 {
+        h.full = true
+    }
+}
+
+// Results returns a copy of the kept results, newest first
+func (h *History) Results() []MatchResult {
+    n := h.next
+    if h.full {
+        n = len(h.results)
+    }
+    out := make([]MatchResult, 0, n)
+    for i := 1; i <= n; i++ {
+        out = append(out, h.results[(h.next-i+len(h.results))%len(h.results)])
+    }
+    return out
+}
+
+// HistoryResponse is the body returned by /history
+type HistoryResponse struct {
+    Channel string        `json:"channel"`
+    Matches []MatchResult `json:"matches"`
+}
+
+// handleHistory serves GET /history?channel=, 404 for rooms nobody has
+// played in
+func (s *Server) handleHistory(w http.ResponseWriter, r *http.Request) {
+    if r.Method != http.MethodGet {
+        http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+        return
+    }
+
+    channel := r.URL.Query().Get("channel")
+    if channel == "" {
+        channel = DefaultChannel
+    }
+    if !channelPattern.MatchString(channel) {
+        http.Error(w, "invalid channel", http.StatusBadRequest)
+        return
+    }
+
+    // Don't create rooms just to report nothing happened in them
+    s.RLock()
+    room, ok := s.rooms[channel]
+    s.RUnlock()
+    if !ok {
+        http.Error(w, "room not found", http.StatusNotFound)
+        return
+    }
+
+    room.RLock()
+    resp := HistoryResponse{
+        Channel: channel,
+        Matches: room.history.Results(),
+    }
+    room.RUnlock()
+
+    w.Header().Set("Content-Type", "application/json")
+    json.NewEncoder(w).Encode(resp)
+}
@@ -0,0 +1,56 @@
+package main
+
+import "testing"
+
+func TestHistoryKeepsLastMatches(t *testing.T) {
+    cfg := DefaultConfig()
+    cfg.WinScore = 3
+    cfg.HistorySize = 3
+    r := newTestRoom(t, cfg)
+
+    // Five matches with scores that tell them apart
+    var played []MatchResult
+    for i := 0; i < 5; i++ {
+        winner, loser := "left", "right"
+        if i%2 == 1 {
+            winner, loser = loser, winner
+        }
+        for j := 0; j < i%3; j++ {
+            r.score(loser)
+        }
+        result := MatchResult{Winner: winner, Left: i % 3, Right: i % 3}
+        if winner == "left" {
+            result.Left = cfg.WinScore
+        } else {
+            result.Right = cfg.WinScore
+        }
+        for over := false; !over; {
+            _, over = r.score(winner)
+        }
+        played = append(played, result)
+    }
+
+    got := r.history.Results()
+    if len(got) != cfg.HistorySize {
+        t.Fatalf("history holds %d matches, want the last %d", len(got), cfg.HistorySize)
+    }
+    for i, match := range got {
+        want := played[len(played)-1-i]
+        if match.Winner != want.Winner || match.Left != want.Left || match.Right != want.Right {
+            t.Errorf("history[%d] = %s %d-%d, want %s %d-%d", i, match.Winner, match.Left, match.Right, want.Winner, want.Left, want.Right)
+        }
+    }
+}
+
+func TestHistoryBeforeWrapping(t *testing.T) {
+    h := NewHistory(3)
+    if got := h.Results(); len(got) != 0 {
+        t.Fatalf("empty history has %d results", len(got))
+    }
+    h.Add(MatchResult{Winner: "left"})
+    h.Add(MatchResult{Winner: "right"})
+    got := h.Results()
+    if len(got) != 2 || got[0].Winner != "right" || got[1].Winner != "left" {
+        t.Fatalf("results = %+v, want right then left", got)
+    }
+}
@@ -0,0 +1,136 @@
+package main
+
+import "sync/atomic"
+
+// Messages waiting for the hub before broadcasters block
+const HubBufferSize = 256
+
+// hubClient is a registration or role change for a connection
+type hubClient struct {
+    client *Client
+    role   ClientRole
+    // Queued to a registering client ahead of any broadcast
+    first []Message
+    // Closed once a registering client gets broadcasts
+    registered chan struct{}
+}
+
+// hubMessage is a broadcast, to connections with role or to everyone when
+// role is empty
+type hubMessage struct {
+    msg  Message
+    role ClientRole
+}
+
+// Hub fans messages out to a set of connections. Only its own goroutine
+// touches the set, everyone else talks to it through channels, so
+// broadcasting never waits on a room's lock.
+type Hub struct {
+    // Each connection's role, a copy so the room lock isn't needed to read it
+    clients    map[*Client]ClientRole
+    register   chan hubClient
+    setRole    chan hubClient
+    unregister chan *Client
+    broadcast  chan hubMessage
+    // Closed when the room stops, sends after that are dropped
+    done <-chan struct{}
+    // Counts connections dropped for not keeping up
+    slowConsumers *atomic.Int64
+}
+
+func NewHub(done <-chan struct{}, slowConsumers *atomic.Int64) *Hub {
+    return &Hub{
+        clients:       make(map[*Client]ClientRole),
+        register:      make(chan hubClient),
+        setRole:       make(chan hubClient),
+        unregister:    make(chan *Client),
+        broadcast:     make(chan hubMessage, HubBufferSize),
+        done:          done,
+        slowConsumers: slowConsumers,
+    }
+}
+
+// Register queues first for client and then starts sending it
+// broadcasts. Broadcasts still waiting for the hub when it registers are
+// older than first, client doesn't get them. Every broadcast after
+// Register returns reaches client.
+func (h *Hub) Register(client *Client, role ClientRole, first ...Message) {
+    registered := make(chan struct{})
+    select {
+    case h.register <- hubClient{client, role, first, registered}:
+    case <-h.done:
+        return
+    }
+    select {
+    case <-registered:
+    case <-h.done:
+    }
+}
+
+// SetRole tells the hub a registered client changed role
+func (h *Hub) SetRole(client *Client, role ClientRole) {
+    select {
+    case h.setRole <- hubClient{client: client, role: role}:
+    case <-h.done:
+    }
+}
+
+// Unregister stops sending broadcasts to client
+func (h *Hub) Unregister(client *Client) {
+    select {
+    case h.unregister <- client:
+    case <-h.done:
+    }
+}
+
+// Broadcast queues msg for every registered client with role, or for
+// everyone when role is empty
+func (h *Hub) Broadcast(msg Message, role ClientRole) {
+    select {
+    case h.broadcast <- hubMessage{msg, role}:
+    case <-h.done:
+    }
+}
+
+// run owns the client set until the room stops
+func (h *Hub) run() {
+    for {
+        select {
+        case <-h.done:
+            return
+        case c := <-h.register:
+            // Everyone else gets what was queued before the registration
+            // first, it would only set the new client back
+            for n := len(h.broadcast); n > 0; n-- {
+                h.fanOut(<-h.broadcast)
+            }
+            for _, msg := range c.first {
+                c.client.Queue(msg)
+            }
+            h.clients[c.client] = c.role
+            close(c.registered)
+        case c := <-h.setRole:
+            if _, ok := h.clients[c.client]; ok {
+                h.clients[c.client] = c.role
+            }
+        case client := <-h.unregister:
+            delete(h.clients, client)
+        case b := <-h.broadcast:
+            h.fanOut(b)
+        }
+    }
+}
+
+// Queue b for the clients it is addressed to. Never blocks, slow clients
+// get disconnected instead.
+func (h *Hub) fanOut(b hubMessage) {
+    for client, role := range h.clients {
+        if b.role != "" && b.role != role {
+            continue
+        }
+        if !client.Queue(b.msg) {
+            h.slowConsumers.Add(1)
+            delete(h.clients, client)
+        }
+    }
+}
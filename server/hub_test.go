@@ -0,0 +1,251 @@
+package main
+
+import (
+    "fmt"
+    "net/http"
+    "net/http/httptest"
+    "strings"
+    "sync"
+    "sync/atomic"
+    "testing"
+    "time"
+
+    "github.com/gorilla/websocket"
+)
+
+// A hub running until the test ends, and its slow consumer count
+func newTestHub(tb testing.TB) (*Hub, *atomic.Int64) {
+    tb.Helper()
+    done := make(chan struct{})
+    slowConsumers := &atomic.Int64{}
+    hub := NewHub(done, slowConsumers)
+    go hub.run()
+    tb.Cleanup(func() {
+        close(done)
+    })
+    return hub, slowConsumers
+}
+
+// A client that is nothing but a send buffer, enough for the hub as long
+// as it keeps up
+func newBareClient() *Client {
+    return &Client{send: make(chan Message, SendBufferSize)}
+}
+
+// A real connection for clients that fall behind, the hub closes it when
+// it drops them
+func testConn(tb testing.TB) *websocket.Conn {
+    tb.Helper()
+    upgrader := websocket.Upgrader{}
+    srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        conn, err := upgrader.Upgrade(w, r, nil)
+        if err != nil {
+            return
+        }
+        defer conn.Close()
+        for {
+            if _, _, err := conn.ReadMessage(); err != nil {
+                return
+            }
+        }
+    }))
+    tb.Cleanup(srv.Close)
+    conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(srv.URL, "http"), nil)
+    if err != nil {
+        tb.Fatalf("dial: %v", err)
+    }
+    tb.Cleanup(func() {
+        conn.Close()
+    })
+    return conn
+}
+
+// Next message hub sent client, failing if none comes
+func hubReceive(t *testing.T, client *Client) Message {
+    t.Helper()
+    select {
+    case msg := <-client.send:
+        return msg
+    case <-time.After(testTimeout):
+        t.Fatalf("no broadcast within %s", testTimeout)
+        return Message{}
+    }
+}
+
+func TestHubRegisterAndBroadcast(t *testing.T) {
+    hub, _ := newTestHub(t)
+    player, spectator := newBareClient(), newBareClient()
+    hub.Register(player, RolePlayer)
+    hub.Register(spectator, RoleSpectator)
+
+    hub.Broadcast(Message{Type: TypePlayerCount}, "")
+    for _, client := range []*Client{player, spectator} {
+        if msg := hubReceive(t, client); msg.Type != TypePlayerCount {
+            t.Fatalf("got %s, want %s", msg.Type, TypePlayerCount)
+        }
+    }
+
+    // Only players get this one, the spectator's next message is the one
+    // after it
+    hub.Broadcast(Message{Type: TypeStateUpdate}, RolePlayer)
+    hub.Broadcast(Message{Type: TypeChat}, "")
+    if msg := hubReceive(t, player); msg.Type != TypeStateUpdate {
+        t.Fatalf("player got %s, want %s", msg.Type, TypeStateUpdate)
+    }
+    if msg := hubReceive(t, spectator); msg.Type != TypeChat {
+        t.Fatalf("spectator got %s, want %s", msg.Type, TypeChat)
+    }
+}
+
+func TestHubRegisterSkipsOlderBroadcasts(t *testing.T) {
+    done := make(chan struct{})
+    t.Cleanup(func() {
+        close(done)
+    })
+    hub := NewHub(done, &atomic.Int64{})
+    // Waiting for the hub before the client registers, it isn't even
+    // running yet
+    for i := 0; i < 3; i++ {
+        hub.Broadcast(Message{Type: TypeStateUpdate}, "")
+    }
+    go hub.run()
+    client := newBareClient()
+    hub.Register(client, RolePlayer, Message{Type: TypeInitialState})
+    hub.Broadcast(Message{Type: TypeChat}, "")
+
+    for _, want := range []MessageType{TypeInitialState, TypeChat} {
+        if msg := hubReceive(t, client); msg.Type != want {
+            t.Fatalf("got %s, want %s", msg.Type, want)
+        }
+    }
+}
+
+func TestHubSetRole(t *testing.T) {
+    hub, _ := newTestHub(t)
+    client := newBareClient()
+    hub.Register(client, RoleSpectator)
+    hub.SetRole(client, RolePlayer)
+
+    hub.Broadcast(Message{Type: TypeStateUpdate}, RolePlayer)
+    if msg := hubReceive(t, client); msg.Type != TypeStateUpdate {
+        t.Fatalf("got %s, want %s", msg.Type, TypeStateUpdate)
+    }
+
+    // A role change for someone the hub doesn't know doesn't register them
+    stranger := newBareClient()
+    hub.SetRole(stranger, RolePlayer)
+    hub.Broadcast(Message{Type: TypeChat}, "")
+    hubReceive(t, client)
+    if len(stranger.send) != 0 {
+        t.Fatalf("unregistered client got a broadcast")
+    }
+}
+
+func TestHubUnregister(t *testing.T) {
+    hub, _ := newTestHub(t)
+    gone, staying := newBareClient(), newBareClient()
+    hub.Register(gone, RolePlayer)
+    hub.Register(staying, RolePlayer)
+    hub.Unregister(gone)
+
+    hub.Broadcast(Message{Type: TypeStateUpdate}, "")
+    // Once the client still registered has it the hub is done with it
+    hubReceive(t, staying)
+    if len(gone.send) != 0 {
+        t.Fatalf("unregistered client got a broadcast")
+    }
+}
+
+func TestHubDropsSlowConsumer(t *testing.T) {
+    hub, slowConsumers := newTestHub(t)
+    slow := newBareClient()
+    slow.conn = testConn(t)
+    hub.Register(slow, RolePlayer)
+
+    for i := 0; i <= SendBufferSize; i++ {
+        hub.Broadcast(Message{Type: TypeStateUpdate}, "")
+    }
+    eventually(t, func() bool {
+        return slowConsumers.Load() == 1
+    })
+
+    // Dropped for good, draining it doesn't bring it back
+    for len(slow.send) > 0 {
+        <-slow.send
+    }
+    witness := newBareClient()
+    hub.Register(witness, RolePlayer)
+    hub.Broadcast(Message{Type: TypeChat}, "")
+    hubReceive(t, witness)
+    if len(slow.send) != 0 {
+        t.Fatalf("dropped client still gets broadcasts")
+    }
+}
+
+// Broadcasts b.N messages to fast clients that drain as they go, waiting
+// for every one of them to get each message. With slow set, one more
+// client never reads and gets dropped once its buffer fills.
+func benchmarkFanOut(b *testing.B, fast int, slow bool) {
+    hub, slowConsumers := newTestHub(b)
+    var received sync.WaitGroup
+    stop := make(chan struct{})
+    b.Cleanup(func() {
+        close(stop)
+    })
+    for i := 0; i < fast; i++ {
+        client := newBareClient()
+        hub.Register(client, RolePlayer)
+        go func() {
+            for {
+                select {
+                case <-client.send:
+                    received.Done()
+                case <-stop:
+                    return
+                }
+            }
+        }()
+    }
+    if slow {
+        client := newBareClient()
+        client.conn = testConn(b)
+        hub.Register(client, RolePlayer)
+    }
+    msg, err := NewMessage(TypeBallUpdate, struct{ X, Y float64 }{400, 300})
+    if err != nil {
+        b.Fatal(err)
+    }
+
+    b.ReportAllocs()
+    b.ResetTimer()
+    for i := 0; i < b.N; i++ {
+        received.Add(fast)
+        hub.Broadcast(msg, "")
+        received.Wait()
+    }
+    b.StopTimer()
+    if slow && b.N > SendBufferSize && slowConsumers.Load() != 1 {
+        b.Fatalf("slow consumers = %d, want 1", slowConsumers.Load())
+    }
+}
+
+// Time for a broadcast to reach every fast client, with and without a
+// client that stopped reading
+func BenchmarkBroadcastSlowClient(b *testing.B) {
+    b.Run("fast", func(b *testing.B) {
+        benchmarkFanOut(b, 100, false)
+    })
+    b.Run("one_slow", func(b *testing.B) {
+        benchmarkFanOut(b, 100, true)
+    })
+}
+
+// Fan out of one message to N connections, from Broadcast until every
+// connection has it
+func BenchmarkBroadcast(b *testing.B) {
+    for _, n := range []int{10, 100, 1000} {
+        b.Run(fmt.Sprintf("clients=%d", n), func(b *testing.B) {
+            benchmarkFanOut(b, n, false)
+        })
+    }
+}
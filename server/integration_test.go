@@ -0,0 +1,93 @@
+package main
+
+import (
+    "encoding/json"
+    "testing"
+
+    "github.com/ThePrimeagen/twitch-ext-pong/server/game"
+)
+
+// Fixed step the room is driven with, the default tick rate
+const pointStep = 1.0 / 60
+
+// Ticks a point may take before the test gives up, a minute of play
+const pointTicks = 60 * 60
+
+// Plays a point against the AI with a scripted left player that follows
+// the ball. The room is stepped a fixed tick at a time instead of running
+// its loop, so the point plays out the same on every run. The AI is slowed
+// down so it misses.
+func TestPlayFullPoint(t *testing.T) {
+    cfg := testConfig()
+    cfg.AIEnabled = true
+    cfg.AISpeed = 150
+    cfg.Seed = 74
+    r := NewRoom(NewServer(cfg, NewMemoryStore()), "point")
+    defer close(r.done)
+    // Never registered with the hub, the AI just leaves its paddle alone
+    player := NewClient(nil, TwitchClaims{}, RolePlayer, cfg.PaddleRate)
+    player.team = "left"
+    r.connections[player] = true
+
+    var updates []Score
+    for i := 0; i < pointTicks && len(updates) == 0; i++ {
+        follow(r)
+        r.tick(pointStep)
+        updates = append(updates, scoreUpdates(t, r)...)
+    }
+    if len(updates) != 1 {
+        t.Fatalf("score updates = %+v after %d ticks, want a single point", updates, pointTicks)
+    }
+    score := updates[0]
+    if score.Left+score.Right != 1 {
+        t.Fatalf("score_update = %+v, want a single point", score)
+    }
+
+    // The next serve waits for the countdown, nothing else can score
+    for i := 0; i < int(cfg.ServeCountdown.Seconds()/pointStep); i++ {
+        follow(r)
+        r.tick(pointStep)
+        if more := scoreUpdates(t, r); len(more) > 0 {
+            t.Fatalf("scored again during the countdown: %+v", more)
+        }
+    }
+    state := r.snapshot()
+    if state.LeftScore != score.Left || state.RightScore != score.Right {
+        t.Fatalf("room score %d-%d, broadcast %d-%d", state.LeftScore, state.RightScore, score.Left, score.Right)
+    }
+}
+
+// Point the left paddle at the first ball, the way a player tracking it
+// would
+func follow(r *Room) {
+    r.Lock()
+    defer r.Unlock()
+    if len(r.gameState.Balls) == 0 {
+        return
+    }
+    height := r.gameState.LeftPaddle.Size()
+    y := r.gameState.Balls[0].Y - height/2
+    r.movePaddle(game.PaddlePosition{Side: "left", Y: max(0, min(r.cfg.Canvas.Height-height, y))})
+}
+
+// Takes everything the last tick broadcast off the hub, returning the
+// score updates. The hub isn't running so nothing else reads it.
+func scoreUpdates(t *testing.T, r *Room) []Score {
+    t.Helper()
+    var scores []Score
+    for {
+        select {
+        case out := <-r.hub.broadcast:
+            if out.msg.Type != TypeScoreUpdate {
+                continue
+            }
+            var score Score
+            if err := json.Unmarshal(out.msg.Payload, &score); err != nil {
+                t.Fatalf("decode score_update: %v", err)
+            }
+            scores = append(scores, score)
+        default:
+            return scores
+        }
+    }
+}
@@ -0,0 +1,205 @@
+package main
+
+import (
+    "fmt"
+    "sync"
+    "time"
+
+    "github.com/gorilla/websocket"
+    "golang.org/x/exp/slog"
+)
+
+// Reason sent in the close frame of a kicked connection
+const KickReason = "kicked"
+
+// banKey is a viewer in a channel, bans don't carry over to other channels
+type banKey struct {
+    channel string
+    userID  string
+}
+
+// BanList holds banned viewers until their ban runs out or the server
+// restarts. Safe for concurrent use.
+type BanList struct {
+    sync.Mutex
+    // When each ban runs out, zero for never
+    bans map[banKey]time.Time
+}
+
+func NewBanList() *BanList {
+    return &BanList{bans: make(map[banKey]time.Time)}
+}
+
+// Add bans userID from channel for ttl, or until the server restarts when
+// ttl is 0
+func (b *BanList) Add(channel, userID string, ttl time.Duration) {
+    b.Lock()
+    defer b.Unlock()
+    var expires time.Time
+    if ttl > 0 {
+        expires = time.Now().Add(ttl)
+    }
+    b.bans[banKey{channel, userID}] = expires
+}
+
+// Remove lifts userID's ban from channel, reporting whether there was one
+func (b *BanList) Remove(channel, userID string) bool {
+    b.Lock()
+    defer b.Unlock()
+    key := banKey{channel, userID}
+    _, ok := b.bans[key]
+    delete(b.bans, key)
+    return ok
+}
+
+// Banned reports whether userID is banned from channel, forgetting the
+// ban once it ran out
+func (b *BanList) Banned(channel, userID string) bool {
+    b.Lock()
+    defer b.Unlock()
+    key := banKey{channel, userID}
+    expires, ok := b.bans[key]
+    if !ok {
+        return false
+    }
+    if !expires.IsZero() && !time.Now().Before(expires) {
+        delete(b.bans, key)
+        return false
+    }
+    return true
+}
+
+// Connections matching kick, by connection id or opaque user id
+func (r *Room) kickTargets(kick Kick) []*Client {
+    r.RLock()
+    defer r.RUnlock()
+
+    var targets []*Client
+    for client := range r.connections {
+        if (kick.ConnectionID != "" && client.id == kick.ConnectionID) ||
+            (kick.UserID != "" && client.identity.OpaqueUserID == kick.UserID) {
+            targets = append(targets, client)
+        }
+    }
+    return targets
+}
+
+// Close the connection with a close frame saying it was kicked. The read
+// loop wakes up and removes it from the room.
+func (c *Client) kick() {
+    closeMsg := websocket.FormatCloseMessage(websocket.ClosePolicyViolation, KickReason)
+    c.conn.WriteControl(websocket.CloseMessage, closeMsg, time.Now().Add(WriteTimeout))
+    c.conn.Close()
+}
+
+func (s *Server) handleKick(client *Client, msg Message) {
+    if !client.identity.Privileged() {
+        slog.Warn("Rejected kick from unprivileged viewer",
+            "role", client.identity.Role,
+            "addr", client.addr,
+            "conn_id", client.id,
+            "timestamp", time.Now().Format(time.RFC3339))
+        client.SendError(ErrCodeForbidden, "only the broadcaster or a moderator can kick")
+        return
+    }
+
+    kick, code, err := decodeKick(msg.Payload)
+    if err != nil {
+        client.SendError(code, err.Error())
+        return
+    }
+
+    room := client.room
+    targets := room.kickTargets(kick)
+    // Banning works on viewers that already left too
+    if kick.Ban && kick.UserID == "" && len(targets) > 0 {
+        kick.UserID = targets[0].identity.OpaqueUserID
+    }
+    banned := kick.Ban && kick.UserID != ""
+    if banned {
+        s.bans.Add(room.channel, kick.UserID, 0)
+    }
+    if len(targets) == 0 && !banned {
+        client.SendError(ErrCodeNotConnected, "nobody in this channel matches the kick")
+        return
+    }
+
+    for _, target := range targets {
+        target.kick()
+    }
+    slog.Info("Kicked viewer",
+        "channel", room.channel,
+        "conn_id", kick.ConnectionID,
+        "opaque_user_id", kick.UserID,
+        "ban", banned,
+        "connections", len(targets),
+        "by", client.identity.OpaqueUserID,
+        "timestamp", time.Now().Format(time.RFC3339))
+    // Kicked, but a viewer without a user id can walk right back in
+    if kick.Ban && !banned {
+        client.SendError(ErrCodeNotConnected, "kicked, but the connection has no user id to ban")
+    }
+}
+
+func (s *Server) handleBan(client *Client, msg Message) {
+    if !client.identity.Privileged() {
+        slog.Warn("Rejected ban from unprivileged viewer",
+            "role", client.identity.Role,
+            "addr", client.addr,
+            "conn_id", client.id,
+            "timestamp", time.Now().Format(time.RFC3339))
+        client.SendError(ErrCodeForbidden, "only the broadcaster or a moderator can ban")
+        return
+    }
+
+    ban, code, err := decodeBan(msg.Payload)
+    if err != nil {
+        client.SendError(code, err.Error())
+        return
+    }
+
+    room := client.room
+    ttl := time.Duration(ban.TTLSeconds) * time.Second
+    s.bans.Add(room.channel, ban.UserID, ttl)
+    // Whoever is connected right now goes too
+    targets := room.kickTargets(Kick{UserID: ban.UserID})
+    for _, target := range targets {
+        target.kick()
+    }
+    slog.Info("Banned viewer",
+        "channel", room.channel,
+        "opaque_user_id", ban.UserID,
+        "ttl", ttl.String(),
+        "connections", len(targets),
+        "by", client.identity.OpaqueUserID,
+        "timestamp", time.Now().Format(time.RFC3339))
+}
+
+func (s *Server) handleUnban(client *Client, msg Message) {
+    if !client.identity.Privileged() {
+        slog.Warn("Rejected unban from unprivileged viewer",
+            "role", client.identity.Role,
+            "addr", client.addr,
+            "conn_id", client.id,
+            "timestamp", time.Now().Format(time.RFC3339))
+        client.SendError(ErrCodeForbidden, "only the broadcaster or a moderator can unban")
+        return
+    }
+
+    ban, code, err := decodeBan(msg.Payload)
+    if err != nil {
+        client.SendError(code, err.Error())
+        return
+    }
+
+    room := client.room
+    if !s.bans.Remove(room.channel, ban.UserID) {
+        client.SendError(ErrCodeNotBanned, fmt.Sprintf("%q is not banned", ban.UserID))
+        return
+    }
+    slog.Info("Unbanned viewer",
+        "channel", room.channel,
+        "opaque_user_id", ban.UserID,
+        "by", client.identity.OpaqueUserID,
+        "timestamp", time.Now().Format(time.RFC3339))
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+    "net/http"
+    "testing"
+    "time"
+
+    "github.com/gorilla/websocket"
+)
+
+func TestModKicksConnection(t *testing.T) {
+    cfg := testConfig()
+    cfg.ExtensionSecret = testSecret
+    ts := newTestServer(t, cfg)
+    mod := dialAs(t, ts, "kick", "U1", "moderator")
+    token := signTestJWT(t, TwitchClaims{ChannelID: "kick", OpaqueUserID: "U2", Role: "viewer"})
+    viewer := ts.dial(t, "token="+token)
+    id := decode[InitialState](t, viewer.expect(TypeInitialState)).ConnectionID
+    room := ts.room(t, "kick")
+
+    mod.send(TypeKick, Kick{ConnectionID: id})
+
+    err := viewer.expectClosed()
+    if !websocket.IsCloseError(err, websocket.ClosePolicyViolation) {
+        t.Fatalf("closed with %v, want close code %d", err, websocket.ClosePolicyViolation)
+    }
+    if ce := err.(*websocket.CloseError); ce.Text != KickReason {
+        t.Fatalf("close reason = %q, want %q", ce.Text, KickReason)
+    }
+    eventually(t, func() bool {
+        room.RLock()
+        defer room.RUnlock()
+        for client := range room.connections {
+            if client.id == id {
+                return false
+            }
+        }
+        return len(room.connections) == 1
+    })
+
+    // The mod is still there and can keep talking
+    mod.send(TypePing, nil)
+    mod.expect(TypePing)
+}
+
+func TestViewerCannotKick(t *testing.T) {
+    cfg := testConfig()
+    cfg.ExtensionSecret = testSecret
+    ts := newTestServer(t, cfg)
+    viewer := dialAs(t, ts, "kick", "U2", "viewer")
+    other := dialAs(t, ts, "kick", "U3", "viewer")
+
+    viewer.send(TypeKick, Kick{UserID: "U3"})
+    viewer.expectError(ErrCodeForbidden)
+    other.send(TypePing, nil)
+    other.expect(TypePing)
+}
+
+func TestKickBanWithoutMatchErrors(t *testing.T) {
+    cfg := testConfig()
+    cfg.ExtensionSecret = testSecret
+    ts := newTestServer(t, cfg)
+    mod := dialAs(t, ts, "kick", "U1", "moderator")
+
+    // No connection to take a user id from, so nobody gets banned
+    mod.send(TypeKick, Kick{ConnectionID: "no-such-connection", Ban: true})
+    mod.expectError(ErrCodeNotConnected)
+}
+
+func TestBanBlocksRejoin(t *testing.T) {
+    cfg := testConfig()
+    cfg.ExtensionSecret = testSecret
+    ts := newTestServer(t, cfg)
+    mod := dialAs(t, ts, "ban", "U1", "moderator")
+    viewer := dialAs(t, ts, "ban", "U2", "viewer")
+
+    mod.send(TypeBan, Ban{UserID: "U2"})
+    if err := viewer.expectClosed(); !websocket.IsCloseError(err, websocket.ClosePolicyViolation) {
+        t.Fatalf("closed with %v, want close code %d", err, websocket.ClosePolicyViolation)
+    }
+
+    token := signTestJWT(t, TwitchClaims{ChannelID: "ban", OpaqueUserID: "U2", Role: "viewer"})
+    _, resp, err := ts.dialWith(t, "token="+token, nil)
+    if err == nil || resp == nil || resp.StatusCode != http.StatusForbidden {
+        t.Fatalf("banned rejoin got %v, want status %d", err, http.StatusForbidden)
+    }
+    // Only this channel
+    dialAs(t, ts, "elsewhere", "U2", "viewer")
+
+    mod.send(TypeUnban, Ban{UserID: "U2"})
+    mod.send(TypePing, nil)
+    mod.expect(TypePing)
+    dialAs(t, ts, "ban", "U2", "viewer")
+}
+
+func TestBanListExpires(t *testing.T) {
+    bans := NewBanList()
+    bans.Add("c", "U1", 50*time.Millisecond)
+    bans.Add("c", "U2", 0)
+
+    if !bans.Banned("c", "U1") || !bans.Banned("c", "U2") {
+        t.Fatalf("fresh bans not in effect")
+    }
+    if bans.Banned("other", "U1") {
+        t.Fatalf("ban carried over to another channel")
+    }
+    time.Sleep(60 * time.Millisecond)
+    if bans.Banned("c", "U1") {
+        t.Fatalf("ban still in effect after its ttl")
+    }
+    if !bans.Banned("c", "U2") {
+        t.Fatalf("ban without a ttl ran out")
+    }
+    if bans.Remove("c", "U1") {
+        t.Fatalf("expired ban was still on the list")
+    }
+}
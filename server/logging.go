@@ -0,0 +1,84 @@
+package main
+
+import (
+    "io"
+    "os"
+    "strings"
+
+    "golang.org/x/exp/slog"
+)
+
+// Logger settings used when the env doesn't say otherwise
+const (
+    DefaultLogLevel  = slog.LevelDebug
+    DefaultLogFormat = "json"
+)
+
+// LogSettings is how the default slog logger is set up. It is read before
+// the rest of the config so config errors can be logged properly.
+type LogSettings struct {
+    Level     slog.Level
+    Format    string
+    AddSource bool
+    // Env values we didn't understand and replaced with defaults, logged
+    // once the logger exists
+    Invalid []string
+}
+
+// LoadLogSettings reads LOG_LEVEL, LOG_FORMAT and LOG_SOURCE. Bad values
+// fall back to the defaults instead of failing startup.
+func LoadLogSettings() LogSettings {
+    settings := LogSettings{Level: DefaultLogLevel, Format: DefaultLogFormat, AddSource: true}
+
+    if v := os.Getenv("LOG_LEVEL"); v != "" {
+        if level, ok := parseLogLevel(v); ok {
+            settings.Level = level
+        } else {
+            settings.Invalid = append(settings.Invalid, "LOG_LEVEL")
+        }
+    }
+
+    switch v := strings.ToLower(os.Getenv("LOG_FORMAT")); v {
+    case "":
+    case "json", "text":
+        settings.Format = v
+    default:
+        settings.Invalid = append(settings.Invalid, "LOG_FORMAT")
+    }
+
+    // File and line on every log is noisy in prod
+    source, err := parseBool(os.Getenv("LOG_SOURCE"), true)
+    if err != nil {
+        settings.Invalid = append(settings.Invalid, "LOG_SOURCE")
+    } else {
+        settings.AddSource = source
+    }
+    return settings
+}
+
+// parseLogLevel reads debug, info, warn or error in any case
+func parseLogLevel(v string) (slog.Level, bool) {
+    switch strings.ToLower(v) {
+    case "debug":
+        return slog.LevelDebug, true
+    case "info":
+        return slog.LevelInfo, true
+    case "warn", "warning":
+        return slog.LevelWarn, true
+    case "error":
+        return slog.LevelError, true
+    }
+    return 0, false
+}
+
+// Handler builds the slog handler writing to w
+func (l LogSettings) Handler(w io.Writer) slog.Handler {
+    opts := &slog.HandlerOptions{
+        Level:     l.Level,
+        AddSource: l.AddSource,
+    }
+    if l.Format == "text" {
+        return slog.NewTextHandler(w, opts)
+    }
+    return slog.NewJSONHandler(w, opts)
+}
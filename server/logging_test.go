@@ -0,0 +1,43 @@
+package main
+
+import (
+    "slices"
+    "testing"
+
+    "golang.org/x/exp/slog"
+)
+
+func TestParseLogLevel(t *testing.T) {
+    for _, tc := range []struct {
+        v    string
+        want slog.Level
+        ok   bool
+    }{
+        {"debug", slog.LevelDebug, true},
+        {"INFO", slog.LevelInfo, true},
+        {"Warn", slog.LevelWarn, true},
+        {"warning", slog.LevelWarn, true},
+        {"error", slog.LevelError, true},
+        {"verbose", 0, false},
+        {"", 0, false},
+    } {
+        got, ok := parseLogLevel(tc.v)
+        if got != tc.want || ok != tc.ok {
+            t.Errorf("parseLogLevel(%q) = %v, %v, want %v, %v", tc.v, got, ok, tc.want, tc.ok)
+        }
+    }
+}
+
+func TestLoadLogSettingsFallsBack(t *testing.T) {
+    t.Setenv("LOG_LEVEL", "loud")
+    t.Setenv("LOG_FORMAT", "text")
+    t.Setenv("LOG_SOURCE", "maybe")
+
+    settings := LoadLogSettings()
+    if settings.Level != DefaultLogLevel || settings.Format != "text" || !settings.AddSource {
+        t.Fatalf("settings = %+v, want the default level, text and source", settings)
+    }
+    if want := []string{"LOG_LEVEL", "LOG_SOURCE"}; !slices.Equal(settings.Invalid, want) {
+        t.Fatalf("invalid = %v, want %v", settings.Invalid, want)
+    }
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+    "hash/fnv"
+    "math/rand"
+    "time"
+
+    "github.com/ThePrimeagen/twitch-ext-pong/server/game"
+)
+
+// Pause before each serve unless configured otherwise
+const DefaultServeCountdown = 3 * time.Second
+
+// Balls allowed in play at once unless configured otherwise
+const DefaultMaxBalls = 5
+
+// Fastest a paddle moves toward its target in pixels per second unless
+// configured otherwise
+const DefaultMaxPaddleSpeed = 1200
+
+// Furthest a single paddle update may move a paddle's target from where
+// the paddle is, in pixels, unless configured otherwise
+const DefaultMaxPaddleDelta = 150
+
+// Game loop ticks per second unless configured otherwise, and the range
+// we accept
+const (
+    DefaultTickRate = 60
+    MinTickRate     = 10
+    MaxTickRate     = 240
+)
+
+// State updates per second spectators get unless configured otherwise, at
+// or above the tick rate they get every tick like players
+const DefaultSpectatorRate = 20
+
+// With only on change broadcasting, an unchanged state is still sent this
+// often unless configured otherwise
+const DefaultHeartbeat = 5 * time.Second
+
+// Longest step we simulate at once, so a stalled loop doesn't teleport
+// the ball
+const MaxStep = 100 * time.Millisecond
+
+// Player count changes are sent at most this often
+const PlayerCountInterval = time.Second
+
+// How long a room may sit without connections before it is removed
+const DefaultRoomIdleTimeout = 5 * time.Minute
+
+// NewRoomRand returns the random source for a room. Every room gets its own
+// so serves only depend on the seed and the room's own history, not on
+// what other rooms are doing.
+func NewRoomRand(seed int64, channel string) *rand.Rand {
+    h := fnv.New64a()
+    h.Write([]byte(channel))
+    return rand.New(rand.NewSource(seed ^ int64(h.Sum64())))
+}
+
+// stateFilter drops state frames that repeat the last one sent, letting
+// one through every heartbeat so clients know the room is still alive.
+// Only used from the game loop.
+type stateFilter struct {
+    last   game.State
+    sentAt time.Time
+}
+
+// skip reports whether state can be left unsent, remembering it when not
+func (f *stateFilter) skip(state game.State, now time.Time, heartbeat time.Duration) bool {
+    if !f.sentAt.IsZero() && state.Equal(f.last) && now.Sub(f.sentAt) < heartbeat {
+        return true
+    }
+    f.last = state
+    f.sentAt = now
+    return false
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+    "slices"
+    "testing"
+    "time"
+
+    "github.com/ThePrimeagen/twitch-ext-pong/server/game"
+)
+
+// The first ball and the next few serves in a room seeded with seed, each
+// serve forced by the ball leaving on the left
+func serves(t *testing.T, seed int64) []game.Ball {
+    t.Helper()
+    cfg := DefaultConfig()
+    cfg.Seed = seed
+    r := newTestRoom(t, cfg)
+
+    balls := []game.Ball{r.gameState.Balls[0]}
+    for i := 0; i < 5; i++ {
+        r.gameState.Countdown = 0
+        r.gameState.Balls = []game.Ball{{X: -game.BallRadius - 1, Y: 300, VX: -300}}
+        r.advance(1.0 / 60)
+        balls = append(balls, r.gameState.Balls[0])
+    }
+    return balls
+}
+
+func TestSameSeedSameServes(t *testing.T) {
+    a, b := serves(t, 42), serves(t, 42)
+    if !slices.Equal(a, b) {
+        t.Fatalf("seed 42 served %+v then %+v", a, b)
+    }
+    if c := serves(t, 43); slices.Equal(a, c) {
+        t.Fatalf("seeds 42 and 43 served the same %+v", a)
+    }
+}
+
+func TestRoomRandDependsOnChannel(t *testing.T) {
+    a, b := NewRoomRand(42, "one"), NewRoomRand(42, "two")
+    if a.Int63() == b.Int63() {
+        t.Fatalf("channels one and two got the same random source")
+    }
+    if NewRoomRand(42, "one").Int63() != NewRoomRand(42, "one").Int63() {
+        t.Fatalf("same seed and channel gave different random sources")
+    }
+}
+
+func TestStateFilterHeartbeat(t *testing.T) {
+    var f stateFilter
+    const heartbeat = time.Second
+    start := time.Now()
+    state := game.State{LeftScore: 1, Balls: []game.Ball{{X: 10, Y: 20}}}
+
+    for _, tc := range []struct {
+        after time.Duration
+        state game.State
+        skip  bool
+    }{
+        {0, state, false},
+        {100 * time.Millisecond, state.Clone(), true},
+        {999 * time.Millisecond, state.Clone(), true},
+        {time.Second, state.Clone(), false},
+        {1500 * time.Millisecond, state.Clone(), true},
+        {1600 * time.Millisecond, game.State{LeftScore: 2}, false},
+        {1700 * time.Millisecond, game.State{LeftScore: 2}, true},
+    } {
+        if got := f.skip(tc.state, start.Add(tc.after), heartbeat); got != tc.skip {
+            t.Fatalf("skip at %s = %v, want %v", tc.after, got, tc.skip)
+        }
+    }
+}
+
+func TestOnlyOnChangeSendsHeartbeats(t *testing.T) {
+    cfg := testConfig()
+    cfg.OnlyOnChange = true
+    cfg.Heartbeat = 300 * time.Millisecond
+    ts := newTestServer(t, cfg)
+    c := ts.dial(t, "channel=static")
+    c.expect(TypeInitialState)
+    room := ts.room(t, "static")
+
+    // Nothing moves from here on
+    room.Lock()
+    room.gameState.Countdown = 0
+    room.gameState.Balls = []game.Ball{{X: 400, Y: 300}}
+    room.Unlock()
+    c.expectState(func(s game.State) bool {
+        return len(s.Balls) == 1 && s.Balls[0].X == 400
+    })
+
+    var gaps []time.Duration
+    last := time.Now()
+    for len(gaps) < 3 {
+        c.expect(TypeStateUpdate)
+        gaps = append(gaps, time.Since(last))
+        last = time.Now()
+    }
+    for _, gap := range gaps {
+        if gap < cfg.Heartbeat-50*time.Millisecond {
+            t.Fatalf("state updates %v apart on a static state, want one per %s heartbeat", gaps, cfg.Heartbeat)
+        }
+    }
+}
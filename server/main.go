@@ -1,122 +1,1139 @@
 package main
 
 import (
+    "context"
+    "errors"
+    "fmt"
+    "io"
+    "net"
     "net/http"
     "os"
+    "os/signal"
+    "path/filepath"
+    "strconv"
     "sync"
+    "sync/atomic"
+    "syscall"
     "time"
 
     "github.com/gorilla/websocket"
     "golang.org/x/exp/slog"
 )
 
-var upgrader = websocket.Upgrader{
-    CheckOrigin: func(r *http.Request) bool {
-        return true // Allow all connections for now 🦍
-    },
-}
-
 type Server struct {
-    // Mutex to protect connections
+    // Mutex to protect rooms
     sync.RWMutex
-    // Connections store
-    connections map[*websocket.Conn]bool
+    // Rooms keyed by channel
+    rooms map[string]*Room
+    // Where room state is persisted
+    store StateStore
+    // Viewers banned from a channel
+    bans *BanList
+    // Tokens that give dropped players their team back
+    reconnects *Reconnects
+    // Gameplay events for stats, separate from the operational logs
+    events *EventLogger
+    // Connection events for external analytics, nil when off
+    webhook *Webhook
     // Add connection count for metrics
-    connectionCount int
+    connectionCount atomic.Int64
+    // Counters for /metrics, updated without holding the mutex
+    totalConnections  atomic.Int64
+    paddleUpdates     atomic.Int64
+    broadcasts        atomic.Int64
+    reapedConnections atomic.Int64
+    slowConsumers     atomic.Int64
+    gameLoopPanics    atomic.Int64
+    players           atomic.Int64
+    spectators        atomic.Int64
+    // Health state for /healthz
+    startedAt    time.Time
+    running      atomic.Bool
+    shuttingDown atomic.Bool
+    // Settings loaded at startup
+    cfg Config
+    // Per channel overrides of cfg, read when a room is created
+    rulesets *Rulesets
+    // Upgrades http requests to websockets, checking the origin first
+    upgrader websocket.Upgrader
+    // Closed to stop every room's game loop
+    done chan struct{}
+    // Tracks the game loop goroutines so Stop can wait on them
+    loopWG sync.WaitGroup
+}
+
+func NewServer(cfg Config, store StateStore) *Server {
+    s := &Server{
+        rooms:      make(map[string]*Room),
+        store:      store,
+        bans:       NewBanList(),
+        reconnects: NewReconnects(),
+        rulesets:   NewRulesets(cfg.RoomsFile),
+        cfg:        cfg,
+        startedAt:  time.Now(),
+        done:       make(chan struct{}),
+        events:     NewEventLogger(io.Discard),
+        webhook:    NewWebhook(cfg.WebhookURL),
+    }
+    s.upgrader = websocket.Upgrader{
+        CheckOrigin: s.checkOrigin,
+        // permessage-deflate, only used when the client offers it too
+        EnableCompression: cfg.Compression,
+    }
+    return s
 }
 
-func NewServer() *Server {
-    return &Server{
-        connections: make(map[*websocket.Conn]bool),
+// Start marks the server as running, room game loops start as rooms are
+// created
+func (s *Server) Start() {
+    s.running.Store(true)
+    slog.Info("Game settings",
+        "tick_rate", s.cfg.TickRate,
+        "spectator_rate", s.cfg.SpectatorRate,
+        "win_score", s.cfg.WinScore,
+        "max_balls", s.cfg.MaxBalls,
+        "control_mode", s.cfg.ControlMode,
+        "ai_enabled", s.cfg.AIEnabled,
+        "seed", s.cfg.Seed,
+        "canvas_width", s.cfg.Canvas.Width,
+        "canvas_height", s.cfg.Canvas.Height,
+        "timestamp", time.Now().Format(time.RFC3339))
+    if s.cfg.RecordDir != "" {
+        slog.Info("Recording matches",
+            "record_dir", s.cfg.RecordDir,
+            "timestamp", time.Now().Format(time.RFC3339))
+    }
+    if s.webhook != nil {
+        slog.Info("Sending webhooks",
+            "timestamp", time.Now().Format(time.RFC3339))
+        s.loopWG.Add(1)
+        go func() {
+            defer s.loopWG.Done()
+            s.webhook.run(s.done)
+        }()
     }
 }
 
+// Stop ends every room's game loop and waits for them to exit
+func (s *Server) Stop() {
+    s.running.Store(false)
+    close(s.done)
+    s.loopWG.Wait()
+}
+
+// How long writers get to send the shutdown notice before connections are
+// closed
+const ShutdownNoticeDelay = 500 * time.Millisecond
+
+// Tell every connection the server is going down, then give the writers
+// a moment to flush before connections get closed
+func (s *Server) announceShutdown(reason string) {
+    msg, err := NewMessage(TypeServerShutdown, ServerShutdown{
+        Reason:                reason,
+        ReconnectAfterSeconds: int(s.cfg.ShutdownReconnectAfter.Seconds()),
+    })
+    if err != nil {
+        slog.Error("Failed to build shutdown notice",
+            "error", err,
+            "timestamp", time.Now().Format(time.RFC3339))
+        return
+    }
+
+    s.RLock()
+    for _, room := range s.rooms {
+        room.broadcast(msg)
+    }
+    s.RUnlock()
+    time.Sleep(ShutdownNoticeDelay)
+}
+
+// Keep the player and spectator gauges in sync
+func (s *Server) countRole(role ClientRole, delta int64) {
+    if role == RoleSpectator {
+        s.spectators.Add(delta)
+    } else {
+        s.players.Add(delta)
+    }
+}
+
+// Get the room for channel, creating it and starting its game loop if
+// this is the first we hear of it
+func (s *Server) room(channel string) *Room {
+    s.RLock()
+    room, ok := s.rooms[channel]
+    s.RUnlock()
+    if ok {
+        return room
+    }
+
+    // Loading the saved state and opening the recording can be slow, every
+    // other lookup would wait on them under the lock
+    room = NewRoom(s, channel)
+    s.Lock()
+    defer s.Unlock()
+    if existing, ok := s.rooms[channel]; ok {
+        room.discard(existing)
+        return existing
+    }
+    s.startRoomLocked(room)
+    return room
+}
+
+// Add room to the server and start its game loop and hub. Caller must
+// hold the lock.
+func (s *Server) startRoomLocked(room *Room) {
+    s.rooms[room.channel] = room
+    s.loopWG.Add(2)
+    go room.run()
+    go func() {
+        defer s.loopWG.Done()
+        room.hub.run()
+    }()
+
+    slog.Info("Room created",
+        "channel", room.channel,
+        "practice", room.practice,
+        "timestamp", time.Now().Format(time.RFC3339))
+}
+
 func (s *Server) handleWS(w http.ResponseWriter, r *http.Request) {
     // Log incoming connection attempt
     slog.Info("Incoming WebSocket connection attempt",
-        "remote_addr", r.RemoteAddr,
+        "remote_addr", s.remoteAddr(r),
         "user_agent", r.UserAgent(),
         "timestamp", time.Now().Format(time.RFC3339))
 
-    conn, err := upgrader.Upgrade(w, r, nil)
+    // Each channel gets its own game
+    channel := r.URL.Query().Get("channel")
+    if channel == "" {
+        channel = DefaultChannel
+    }
+
+    // Only viewers with a JWT signed by Twitch get in
+    var identity TwitchClaims
+    var responseHeader http.Header
+    if len(s.cfg.ExtensionSecret) > 0 {
+        token, fromProtocol := tokenFromRequest(r)
+        claims, err := verifyTwitchJWT(token, s.cfg.ExtensionSecret, time.Now())
+        if err != nil {
+            slog.Warn("Rejected connection with unverified token",
+                "error", err,
+                "remote_addr", s.remoteAddr(r),
+                "timestamp", time.Now().Format(time.RFC3339))
+            http.Error(w, "unauthorized", http.StatusUnauthorized)
+            return
+        }
+        identity = claims
+        // The verified channel wins over whatever the client asked for
+        channel = claims.ChannelID
+        if fromProtocol {
+            responseHeader = http.Header{"Sec-WebSocket-Protocol": {token}}
+        }
+    }
+
+    if !channelPattern.MatchString(channel) {
+        slog.Warn("Rejected connection with invalid channel",
+            "remote_addr", s.remoteAddr(r),
+            "timestamp", time.Now().Format(time.RFC3339))
+        http.Error(w, "invalid channel", http.StatusBadRequest)
+        return
+    }
+
+    if identity.OpaqueUserID != "" && s.bans.Banned(channel, identity.OpaqueUserID) {
+        slog.Info("Rejected banned viewer",
+            "channel", channel,
+            "opaque_user_id", identity.OpaqueUserID,
+            "remote_addr", s.remoteAddr(r),
+            "timestamp", time.Now().Format(time.RFC3339))
+        http.Error(w, "banned", http.StatusForbidden)
+        return
+    }
+
+    // Only one subprotocol can be echoed, a version wins over the token
+    protocol, requested, ok := negotiateProtocol(r)
+    if !ok {
+        slog.Warn("Rejected connection with unsupported protocol",
+            "protocols", websocket.Subprotocols(r),
+            "remote_addr", s.remoteAddr(r),
+            "timestamp", time.Now().Format(time.RFC3339))
+        http.Error(w, "unsupported protocol version", http.StatusBadRequest)
+        return
+    }
+    if requested {
+        responseHeader = http.Header{"Sec-WebSocket-Protocol": {protocol}}
+    }
+
+    // Claim a slot before upgrading so concurrent connects can't overshoot
+    // the cap
+    currentCount := s.connectionCount.Add(1)
+    full := s.cfg.MaxConnections > 0 && currentCount > int64(s.cfg.MaxConnections)
+    if full {
+        slog.Warn("Turning away connection, server full",
+            "max_connections", s.cfg.MaxConnections,
+            "mode", s.cfg.FullMode,
+            "remote_addr", s.remoteAddr(r),
+            "timestamp", time.Now().Format(time.RFC3339))
+    }
+    if full && s.cfg.FullMode == FullModeReject {
+        s.connectionCount.Add(-1)
+        http.Error(w, "server full", http.StatusServiceUnavailable)
+        return
+    }
+
+    conn, err := s.upgrader.Upgrade(w, r, responseHeader)
     if err != nil {
+        s.connectionCount.Add(-1)
         slog.Error("Failed to upgrade connection",
             "error", err,
-            "remote_addr", r.RemoteAddr,
+            "remote_addr", s.remoteAddr(r),
             "timestamp", time.Now().Format(time.RFC3339))
         return
     }
+    if full {
+        rejectFull(conn)
+        s.connectionCount.Add(-1)
+        return
+    }
 
-    // Add connection to our map
-    s.Lock()
-    s.connections[conn] = true
-    s.connectionCount++
-    currentCount := s.connectionCount
-    s.Unlock()
+    // Writes are compressed under the client's write mutex like any other
+    // write, this only turns it on for connections that negotiated it
+    conn.EnableWriteCompression(s.cfg.Compression)
+
+    // Add connection to its room, or one of its own to practice in
+    role := parseClientRole(r.URL.Query().Get("role"))
+    practice, _ := strconv.ParseBool(r.URL.Query().Get("practice"))
+    if practice {
+        role = RolePlayer
+    }
+    client := NewClient(conn, identity, role, s.cfg.PaddleRate)
+    client.protocol = protocol
+    client.codec = codecFor(protocol)
+    client.addr = s.remoteAddr(r)
+    // Fewer frames for clients that can unpack batches
+    client.batch, _ = strconv.ParseBool(r.URL.Query().Get("batch"))
+    // Practice rooms go away with the connection, nothing to reconnect to
+    if s.cfg.ReconnectGrace > 0 && !practice {
+        client.reconnectToken = s.reconnects.Issue(channel, identity.OpaqueUserID)
+    }
+    var room *Room
+    for room == nil || !room.join(client) {
+        // Removed for being idle just now, a fresh one takes its place
+        if practice {
+            room = s.practiceRoom(client)
+        } else {
+            room = s.room(channel)
+        }
+    }
+    if practice {
+        room.assignTeam(client, PracticeTeam)
+        client.Send(TypeTeamAssign, TeamAssignment{Team: PracticeTeam})
+    }
+    s.totalConnections.Add(1)
+    s.webhook.Send(WebhookConnectionOpen, channel, client)
+    // Back from a dropped connection, take the old team back
+    if team, ok := s.reconnects.Claim(r.URL.Query().Get("reconnect"), channel, identity.OpaqueUserID); ok {
+        room.restoreTeam(client, team)
+    }
 
     slog.Info("New connection established",
-        "addr", conn.RemoteAddr(),
+        "addr", client.addr,
+        "conn_id", client.id,
+        "channel", channel,
+        "opaque_user_id", identity.OpaqueUserID,
+        "role", role,
+        "protocol", protocol,
+        "batch", client.batch,
+        "practice", practice,
         "total_connections", currentCount,
         "timestamp", time.Now().Format(time.RFC3339))
 
     // Remove connection when function returns
     defer func() {
-        s.Lock()
-        delete(s.connections, conn)
-        s.connectionCount--
-        currentCount := s.connectionCount
-        s.Unlock()
+        room.RLock()
+        team := client.team
+        room.RUnlock()
+        room.leave(client)
+        s.reconnects.Park(client.reconnectToken, team, s.cfg.ReconnectGrace)
+        s.webhook.Send(WebhookConnectionClose, channel, client)
+        currentCount := s.connectionCount.Add(-1)
         conn.Close()
         slog.Info("Connection closed",
-            "addr", conn.RemoteAddr(),
+            "addr", client.addr,
+            "conn_id", client.id,
+            "channel", channel,
             "remaining_connections", currentCount,
             "timestamp", time.Now().Format(time.RFC3339))
     }()
 
+    // Anything bigger than this closes the connection with 1009
+    conn.SetReadLimit(int64(s.cfg.MaxMessageSize))
+
+    // Drop the connection if it stops answering pings, or if it sends
+    // nothing at all for too long since some proxies eat control frames.
+    // Both callbacks run on this goroutine so no locking is needed.
+    lastPong := time.Now()
+    lastMessage := time.Now()
+    client.touch(lastMessage)
+    extendDeadline := func() error {
+        deadline := lastPong.Add(PongTimeout)
+        if s.cfg.IdleTimeout > 0 {
+            if idle := lastMessage.Add(s.cfg.IdleTimeout); idle.Before(deadline) {
+                deadline = idle
+            }
+        }
+        return conn.SetReadDeadline(deadline)
+    }
+    extendDeadline()
+    conn.SetPongHandler(func(appData string) error {
+        lastPong = time.Now()
+        client.recordPong(appData, lastPong)
+        return extendDeadline()
+    })
+    // Writer and keepalive goroutines live as long as the read loop, or
+    // until the server shuts down
+    ctx, cancel := context.WithCancel(r.Context())
+    defer cancel()
+    go client.writeLoop(ctx)
+    go client.pingLoop(ctx)
+
+    // A blocked read can't select on ctx, closing the connection is what
+    // wakes it up
+    go func() {
+        <-ctx.Done()
+        if r.Context().Err() != nil {
+            closeMsg := websocket.FormatCloseMessage(websocket.CloseGoingAway, "server shutting down")
+            conn.WriteControl(websocket.CloseMessage, closeMsg, time.Now().Add(WriteTimeout))
+        }
+        conn.Close()
+    }()
+
     // Keep connection alive
     for {
-        // Read message (required to detect disconnection)
-        if _, _, err := conn.ReadMessage(); err != nil {
+        // Read message (required to detect disconnection). Any error here
+        // means the connection itself is gone.
+        _, data, err := conn.ReadMessage()
+        if err != nil {
+            var netErr net.Error
+            if errors.As(err, &netErr) && netErr.Timeout() {
+                s.reapedConnections.Add(1)
+                if idle := time.Since(lastMessage); s.cfg.IdleTimeout > 0 && idle >= s.cfg.IdleTimeout {
+                    slog.Info("Reaping idle connection",
+                        "addr", client.addr,
+                        "conn_id", client.id,
+                        "idle_duration", idle.String(),
+                        "timestamp", time.Now().Format(time.RFC3339))
+                    break
+                }
+                slog.Info("Reaping connection that missed pongs",
+                    "addr", client.addr,
+                    "conn_id", client.id,
+                    "pong_timeout", PongTimeout.String(),
+                    "timestamp", time.Now().Format(time.RFC3339))
+                break
+            }
+            if errors.Is(err, websocket.ErrReadLimit) {
+                slog.Warn("Closing connection that sent an oversized message",
+                    "addr", client.addr,
+                    "conn_id", client.id,
+                    "max_message_size", s.cfg.MaxMessageSize,
+                    "timestamp", time.Now().Format(time.RFC3339))
+                break
+            }
+            if websocket.IsUnexpectedCloseError(err, websocket.CloseNormalClosure, websocket.CloseGoingAway) {
+                slog.Warn("Connection closed unexpectedly",
+                    "error", err,
+                    "addr", client.addr,
+                    "conn_id", client.id,
+                    "timestamp", time.Now().Format(time.RFC3339))
+                break
+            }
             slog.Debug("Connection read error",
                 "error", err,
-                "addr", conn.RemoteAddr(),
+                "addr", client.addr,
+                "conn_id", client.id,
                 "timestamp", time.Now().Format(time.RFC3339))
             break
         }
+
+        // Counts even when the message turns out to be garbage, the
+        // connection itself is alive
+        client.touch(time.Now())
+
+        // Garbage from a client that is otherwise fine doesn't cost it the
+        // connection
+        msg, code, err := parseMessage(data, client.codec, client.room.cfg, client.room.lockedPaddleSize)
+        if err != nil {
+            slog.Debug("Malformed message",
+                "error", err,
+                "code", code,
+                "addr", client.addr,
+                "conn_id", client.id,
+                "timestamp", time.Now().Format(time.RFC3339))
+            client.SendError(code, err.Error())
+            continue
+        }
+        lastMessage = time.Now()
+        extendDeadline()
+
+        switch msg.Type {
+        case TypePaddleUpdate:
+            s.handlePaddleUpdate(client, msg)
+        case TypeTeamAssign:
+            s.handleTeamAssign(client, msg)
+        case TypeJoin:
+            s.handleJoin(client, msg)
+        case TypeResetGame:
+            s.handleResetGame(client)
+        case TypeSetPaddleSize:
+            s.handleSetPaddleSize(client, msg)
+        case TypeSpawnBall:
+            s.handleSpawnBall(client)
+        case TypePause:
+            s.handlePause(client, true)
+        case TypeResume:
+            s.handlePause(client, false)
+        case TypeLatencyProbe:
+            s.handleLatencyProbe(client, msg)
+        case TypePing:
+            client.Send(TypePing, Ping{RTTMillis: float64(client.RTT()) / float64(time.Millisecond)})
+        case TypeChat:
+            s.handleChat(client, msg)
+        case TypeRequestState:
+            s.handleRequestState(client)
+        case TypeKick:
+            s.handleKick(client, msg)
+        case TypeBan:
+            s.handleBan(client, msg)
+        case TypeUnban:
+            s.handleUnban(client, msg)
+        default:
+            slog.Debug("Unknown message type",
+                "type", msg.Type,
+                "addr", client.addr,
+                "conn_id", client.id,
+                "timestamp", time.Now().Format(time.RFC3339))
+            client.SendError(ErrCodeUnknownType, fmt.Sprintf("unknown message type %q", msg.Type))
+        }
     }
 }
 
+func (s *Server) handlePaddleUpdate(client *Client, msg Message) {
+    if !client.paddleLimiter.Allow() {
+        slog.Debug("Rate limited paddle update",
+            "addr", client.addr,
+            "conn_id", client.id,
+            "timestamp", time.Now().Format(time.RFC3339))
+        client.SendError(ErrCodeRateLimited, "too many paddle updates")
+        return
+    }
+
+    pos, code, err := decodePaddleUpdate(msg.Payload, client.room.cfg.Canvas.Height, client.room.lockedPaddleSize, client.room.cfg.BoundsMode)
+    if err != nil {
+        slog.Error("Invalid paddle update",
+            "error", err,
+            "addr", client.addr,
+            "conn_id", client.id,
+            "timestamp", time.Now().Format(time.RFC3339))
+        client.SendError(code, err.Error())
+        return
+    }
+
+    // Reordered or repeated input would move the paddle backwards, clients
+    // that don't send a seq skip this
+    if pos.Seq != 0 {
+        if pos.Seq <= client.lastSeq {
+            slog.Debug("Dropping stale paddle update",
+                "seq", pos.Seq,
+                "last_seq", client.lastSeq,
+                "addr", client.addr,
+                "conn_id", client.id,
+                "timestamp", time.Now().Format(time.RFC3339))
+            client.SendError(ErrCodeStaleSeq, fmt.Sprintf("seq %d is not newer than %d", pos.Seq, client.lastSeq))
+            return
+        }
+    }
+    // Same for jitter reordering input from clients that stamp it with
+    // their clock instead
+    if pos.Timestamp != 0 && pos.Timestamp <= client.lastTimestamp {
+        slog.Debug("Dropping out of order paddle update",
+            "ts", pos.Timestamp,
+            "last_ts", client.lastTimestamp,
+            "addr", client.addr,
+            "conn_id", client.id,
+            "timestamp", time.Now().Format(time.RFC3339))
+        client.SendError(ErrCodeStaleTimestamp, fmt.Sprintf("ts %d is not newer than %d", pos.Timestamp, client.lastTimestamp))
+        return
+    }
+
+    room := client.room
+    room.Lock()
+    if client.role == RoleSpectator {
+        room.Unlock()
+        slog.Debug("Dropping paddle update from spectator",
+            "addr", client.addr,
+            "conn_id", client.id,
+            "timestamp", time.Now().Format(time.RFC3339))
+        client.SendError(ErrCodeForbidden, "spectators cannot move paddles")
+        return
+    }
+    if room.gameState.Paused && !room.cfg.InputWhilePaused {
+        room.Unlock()
+        client.SendError(ErrCodePaused, "the game is paused")
+        return
+    }
+    team := client.team
+    if pos.Side != team {
+        room.Unlock()
+        slog.Warn("Dropping paddle update for unassigned side",
+            "side", pos.Side,
+            "team", team,
+            "addr", client.addr,
+            "conn_id", client.id,
+            "timestamp", time.Now().Format(time.RFC3339))
+        client.SendError(ErrCodeWrongTeam, fmt.Sprintf("cannot move the %s paddle from team %q", pos.Side, team))
+        return
+    }
+    if room.cfg.BoundsMode == BoundsClamp {
+        pos = room.clampToCanvas(pos)
+    }
+    requested := pos.Y
+    pos, clamped := room.clampPaddleDelta(pos)
+    room.movePaddle(pos)
+    room.lastController[pos.Side] = client
+    client.lastInput = time.Now()
+    room.Unlock()
+    if clamped {
+        slog.Warn("Clamped paddle update jump",
+            "side", pos.Side,
+            "requested_y", requested,
+            "y", pos.Y,
+            "max_delta", room.cfg.MaxPaddleDelta,
+            "addr", client.addr,
+            "conn_id", client.id,
+            "timestamp", time.Now().Format(time.RFC3339))
+    }
+    s.paddleUpdates.Add(1)
+    client.lastSeq = max(client.lastSeq, pos.Seq)
+    client.lastTimestamp = max(client.lastTimestamp, pos.Timestamp)
+    s.events.PaddleMoved(room.channel, pos.Side, pos.Y)
+}
+
+func (s *Server) handleTeamAssign(client *Client, msg Message) {
+    assignment, code, err := decodeTeamAssign(msg.Payload)
+    if err != nil {
+        slog.Error("Invalid team assignment",
+            "error", err,
+            "addr", client.addr,
+            "conn_id", client.id,
+            "timestamp", time.Now().Format(time.RFC3339))
+        client.SendError(code, err.Error())
+        return
+    }
+
+    // Nobody plays the wall
+    if client.room.practice && assignment.Team != PracticeTeam {
+        client.SendError(ErrCodeBadTeam, fmt.Sprintf("practice is played from the %s", PracticeTeam))
+        return
+    }
+
+    team, position, promoted := client.room.assignTeam(client, assignment.Team)
+    // Auto balance may have picked the other team
+    assignment.Team = team
+    client.room.notifyPromoted(promoted)
+
+    // Full team, the client waits as a spectator
+    if position > 0 {
+        slog.Info("Team full, queued",
+            "team", assignment.Team,
+            "position", position,
+            "addr", client.addr,
+            "conn_id", client.id,
+            "timestamp", time.Now().Format(time.RFC3339))
+        client.Send(TypeQueued, Queued{Team: assignment.Team, Position: position})
+        return
+    }
+
+    slog.Info("Team assigned",
+        "team", assignment.Team,
+        "addr", client.addr,
+        "conn_id", client.id,
+        "timestamp", time.Now().Format(time.RFC3339))
+
+    // Let the client know its team was accepted
+    client.Send(TypeTeamAssign, assignment)
+}
+
+func (s *Server) handleJoin(client *Client, msg Message) {
+    join, code, err := decodeJoin(msg.Payload)
+    if err != nil {
+        slog.Error("Invalid join",
+            "error", err,
+            "addr", client.addr,
+            "conn_id", client.id,
+            "timestamp", time.Now().Format(time.RFC3339))
+        client.SendError(code, err.Error())
+        return
+    }
+
+    promoted := client.room.setRole(client, ClientRole(join.Role))
+    client.room.notifyPromoted(promoted)
+
+    slog.Info("Role changed",
+        "role", join.Role,
+        "addr", client.addr,
+        "conn_id", client.id,
+        "timestamp", time.Now().Format(time.RFC3339))
+
+    // Let the client know its role was accepted
+    client.Send(TypeJoin, join)
+}
+
+func (s *Server) handleSetPaddleSize(client *Client, msg Message) {
+    if !client.identity.Privileged() {
+        slog.Warn("Rejected paddle resize from unprivileged viewer",
+            "role", client.identity.Role,
+            "addr", client.addr,
+            "conn_id", client.id,
+            "timestamp", time.Now().Format(time.RFC3339))
+        client.SendError(ErrCodeForbidden, "only the broadcaster or a moderator can resize paddles")
+        return
+    }
+
+    size, code, err := decodePaddleSize(msg.Payload)
+    if err != nil {
+        slog.Error("Invalid paddle size",
+            "error", err,
+            "addr", client.addr,
+            "conn_id", client.id,
+            "timestamp", time.Now().Format(time.RFC3339))
+        client.SendError(code, err.Error())
+        return
+    }
+
+    room := client.room
+    room.Lock()
+    size = room.setPaddleSize(size)
+    room.Unlock()
+
+    slog.Info("Paddle resized",
+        "channel", room.channel,
+        "side", size.Side,
+        "height", size.Height,
+        "by", client.identity.OpaqueUserID,
+        "timestamp", time.Now().Format(time.RFC3339))
+
+    out, err := NewMessage(TypeSetPaddleSize, size)
+    if err != nil {
+        slog.Error("Failed to build paddle size",
+            "error", err,
+            "channel", room.channel,
+            "timestamp", time.Now().Format(time.RFC3339))
+        return
+    }
+    // Everyone needs the new size to render the paddle
+    room.broadcast(out)
+}
+
+// Echo the client's clock with ours. Answered right away and never rate
+// limited, any delay would skew the client's estimate.
+func (s *Server) handleLatencyProbe(client *Client, msg Message) {
+    probe, code, err := decodeLatencyProbe(msg.Payload)
+    if err != nil {
+        client.SendError(code, err.Error())
+        return
+    }
+    probe.ServerTime = time.Now().UnixNano()
+    client.Send(TypeLatencyProbe, probe)
+}
+
+func (s *Server) handleSpawnBall(client *Client) {
+    if !client.identity.Privileged() {
+        slog.Warn("Rejected spawn ball from unprivileged viewer",
+            "role", client.identity.Role,
+            "addr", client.addr,
+            "conn_id", client.id,
+            "timestamp", time.Now().Format(time.RFC3339))
+        client.SendError(ErrCodeForbidden, "only the broadcaster or a moderator can spawn balls")
+        return
+    }
+
+    room := client.room
+    room.Lock()
+    ball, ok := room.spawnBall()
+    room.Unlock()
+    if !ok {
+        client.SendError(ErrCodeTooManyBalls,
+            fmt.Sprintf("at most %d balls can be in play", room.cfg.MaxBalls))
+        return
+    }
+
+    slog.Info("Ball spawned",
+        "channel", room.channel,
+        "ball", ball.ID,
+        "by", client.identity.OpaqueUserID,
+        "timestamp", time.Now().Format(time.RFC3339))
+}
+
+func (s *Server) handleResetGame(client *Client) {
+    if !client.identity.Privileged() {
+        slog.Warn("Rejected reset from unprivileged viewer",
+            "role", client.identity.Role,
+            "addr", client.addr,
+            "conn_id", client.id,
+            "timestamp", time.Now().Format(time.RFC3339))
+        client.SendError(ErrCodeForbidden, "only the broadcaster or a moderator can reset the game")
+        return
+    }
+
+    room := client.room
+    room.Lock()
+    room.reset()
+    msg, err := room.initialStateMessage(nil)
+    room.Unlock()
+    if err != nil {
+        slog.Error("Failed to build initial state",
+            "error", err,
+            "channel", room.channel,
+            "timestamp", time.Now().Format(time.RFC3339))
+        return
+    }
+
+    slog.Info("Game reset",
+        "channel", room.channel,
+        "by", client.identity.OpaqueUserID,
+        "timestamp", time.Now().Format(time.RFC3339))
+
+    // Everyone starts over from the fresh state
+    room.broadcast(msg)
+}
+
+func (s *Server) handlePause(client *Client, paused bool) {
+    if !client.identity.Privileged() {
+        slog.Warn("Rejected pause from unprivileged viewer",
+            "role", client.identity.Role,
+            "paused", paused,
+            "addr", client.addr,
+            "conn_id", client.id,
+            "timestamp", time.Now().Format(time.RFC3339))
+        client.SendError(ErrCodeForbidden, "only the broadcaster or a moderator can pause the game")
+        return
+    }
+
+    room := client.room
+    if !room.setPaused(paused) {
+        return
+    }
+
+    slog.Info("Game pause changed",
+        "channel", room.channel,
+        "paused", paused,
+        "by", client.identity.OpaqueUserID,
+        "timestamp", time.Now().Format(time.RFC3339))
+
+    msg, err := NewMessage(TypePauseState, PauseState{Paused: paused})
+    if err != nil {
+        slog.Error("Failed to build pause state",
+            "error", err,
+            "channel", room.channel,
+            "timestamp", time.Now().Format(time.RFC3339))
+        return
+    }
+    room.broadcast(msg)
+}
+
+// Full snapshots are big, a client resyncing needs one every now and then
+// at most
+const (
+    StateRequestRate  = 0.2
+    StateRequestBurst = 2
+)
+
+func (s *Server) handleRequestState(client *Client) {
+    if !client.stateLimiter.Allow() {
+        slog.Debug("Rate limited state request",
+            "addr", client.addr,
+            "conn_id", client.id,
+            "timestamp", time.Now().Format(time.RFC3339))
+        client.SendError(ErrCodeRateLimited, "too many state requests")
+        return
+    }
+
+    room := client.room
+    room.RLock()
+    room.queueFullState(client)
+    room.RUnlock()
+    slog.Debug("Resent full state",
+        "channel", room.channel,
+        "addr", client.addr,
+        "conn_id", client.id,
+        "timestamp", time.Now().Format(time.RFC3339))
+}
+
+func (s *Server) handleChat(client *Client, msg Message) {
+    if !client.chatLimiter.Allow() {
+        slog.Debug("Rate limited chat",
+            "addr", client.addr,
+            "conn_id", client.id,
+            "timestamp", time.Now().Format(time.RFC3339))
+        client.SendError(ErrCodeRateLimited, "too many chat messages")
+        return
+    }
+
+    chat, code, err := decodeChat(msg.Payload)
+    if err != nil {
+        slog.Error("Invalid chat",
+            "error", err,
+            "addr", client.addr,
+            "conn_id", client.id,
+            "timestamp", time.Now().Format(time.RFC3339))
+        client.SendError(code, err.Error())
+        return
+    }
+
+    // Never trust the client with who it is
+    chat = Chat{User: client.displayName(), Text: sanitizeChat(chat.Text)}
+
+    relay, err := NewMessage(TypeChat, chat)
+    if err != nil {
+        slog.Error("Failed to build chat",
+            "error", err,
+            "addr", client.addr,
+            "conn_id", client.id,
+            "timestamp", time.Now().Format(time.RFC3339))
+        return
+    }
+    client.room.broadcast(relay)
+}
+
+// Handler routes every HTTP endpoint of the server, kept out of main so it
+// can be mounted on any listener, including httptest servers
+func (s *Server) Handler() http.Handler {
+    mux := http.NewServeMux()
+
+    // Serve the frontend if we have it, the backend works headless too
+    staticDir, err := filepath.Abs(s.cfg.StaticDir)
+    if err != nil {
+        staticDir = s.cfg.StaticDir
+    }
+    if info, err := os.Stat(staticDir); err != nil || !info.IsDir() {
+        slog.Warn("Static directory not found, not serving frontend",
+            "static_dir", staticDir,
+            "timestamp", time.Now().Format(time.RFC3339))
+    } else {
+        slog.Info("Serving static files",
+            "static_dir", staticDir,
+            "gzip", s.cfg.StaticGzip,
+            "max_age", s.cfg.StaticMaxAge,
+            "timestamp", time.Now().Format(time.RFC3339))
+        var fs http.Handler = http.FileServer(http.Dir(staticDir))
+        fs = newStaticCache(staticDir, s.cfg.StaticMaxAge).middleware(fs)
+        // Smaller downloads for viewers on slow connections
+        if s.cfg.StaticGzip {
+            fs = gzipMiddleware(fs)
+        }
+        mux.Handle("/", http.StripPrefix("/", fs))
+    }
+
+    // Handle WebSocket connections
+    mux.HandleFunc("/ws", s.handleWS)
+    slog.Info("Accepting websocket connections",
+        "max_connections", s.cfg.MaxConnections,
+        "full_mode", s.cfg.FullMode,
+        "max_message_size", s.cfg.MaxMessageSize,
+        "idle_timeout", s.cfg.IdleTimeout.String(),
+        "compression", s.cfg.Compression,
+        "allowed_origins", s.cfg.AllowedOrigins,
+        "trust_proxy", s.cfg.TrustProxy,
+        "timestamp", time.Now().Format(time.RFC3339))
+
+    // Play back recorded matches
+    mux.HandleFunc("/replay", s.handleReplay)
+
+    // Prometheus scrape endpoint
+    mux.HandleFunc("/metrics", s.handleMetrics)
+
+    // Dry run client messages without touching a game
+    mux.HandleFunc("/validate", s.handleValidate)
+
+    // Per room match statistics
+    mux.HandleFunc("/stats", s.handleStats)
+
+    // Per room recent results
+    mux.HandleFunc("/history", s.handleHistory)
+
+    // Readiness and liveness probe
+    mux.HandleFunc("/healthz", s.handleHealth)
+
+    // Everything we know, for troubleshooting. Off unless asked for since
+    // it shows who is connected from where.
+    if s.cfg.DebugState {
+        mux.HandleFunc("/debug/state", s.handleDebugState)
+        slog.Warn("Serving /debug/state, it shows who is connected",
+            "timestamp", time.Now().Format(time.RFC3339))
+    }
+
+    return corsMiddleware(mux, s.cfg.AllowedOrigins)
+}
+
 func main() {
-    // Setup JSON logger with timestamp
-    logHandler := slog.NewJSONHandler(os.Stdout, &slog.HandlerOptions{
-        Level: slog.LevelDebug,
-        AddSource: true,
-    })
-    logger := slog.New(logHandler)
+    // Setup logger with timestamp, JSON at debug level unless told otherwise
+    logSettings := LoadLogSettings()
+    logger := slog.New(logSettings.Handler(os.Stdout))
     slog.SetDefault(logger)
+    for _, name := range logSettings.Invalid {
+        slog.Warn("Invalid logging setting, using the default",
+            "setting", name,
+            "value", os.Getenv(name),
+            "timestamp", time.Now().Format(time.RFC3339))
+    }
 
-    server := NewServer()
+    cfg, err := LoadConfig()
+    if err != nil {
+        slog.Error("Invalid configuration",
+            "error", err,
+            "timestamp", time.Now().Format(time.RFC3339))
+        os.Exit(1)
+    }
 
-    // Log server configuration
+    // Room state lives in memory unless a directory is configured
+    var store StateStore = NewMemoryStore()
+    if cfg.StateDir != "" {
+        fileStore, err := NewFileStore(cfg.StateDir)
+        if err != nil {
+            slog.Error("Failed to open state directory",
+                "error", err,
+                "state_dir", cfg.StateDir,
+                "timestamp", time.Now().Format(time.RFC3339))
+            os.Exit(1)
+        }
+        store = fileStore
+        slog.Info("Saving room state to disk",
+            "state_dir", cfg.StateDir,
+            "timestamp", time.Now().Format(time.RFC3339))
+    }
+
+    server := NewServer(cfg, store)
+    if err := server.rulesets.Load(); err != nil {
+        slog.Error("Failed to load rulesets",
+            "error", err,
+            "rooms_file", cfg.RoomsFile,
+            "timestamp", time.Now().Format(time.RFC3339))
+        os.Exit(1)
+    }
+
+    // Gameplay events go to their own file for stats, off by default
+    if cfg.EventLog != "" {
+        eventFile, err := os.OpenFile(cfg.EventLog, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+        if err != nil {
+            slog.Error("Failed to open event log",
+                "error", err,
+                "event_log", cfg.EventLog,
+                "timestamp", time.Now().Format(time.RFC3339))
+            os.Exit(1)
+        }
+        defer eventFile.Close()
+        server.events = NewEventLogger(eventFile)
+        slog.Info("Writing gameplay events",
+            "event_log", cfg.EventLog,
+            "timestamp", time.Now().Format(time.RFC3339))
+    }
+
+    // Log server configuration, the rest is logged where it's used
     slog.Info("🦍 STRONK SERVER CONFIGURATION 🦍",
-        "port", 42069,
+        "port", cfg.Port,
         "timestamp", time.Now().Format(time.RFC3339),
         "version", "1.0.0",
-        "log_level", "debug")
+        "log_level", logSettings.Level.String(),
+        "log_format", logSettings.Format,
+        "log_source", logSettings.AddSource,
+        "auth_enabled", len(cfg.ExtensionSecret) > 0)
+
+    if len(cfg.ExtensionSecret) == 0 {
+        slog.Warn("EXTENSION_SECRET not set, connections are not authenticated",
+            "timestamp", time.Now().Format(time.RFC3339))
+    }
 
-    // Serve static files from /app/src directory
-    fs := http.FileServer(http.Dir("/app/src"))
-    http.Handle("/", http.StripPrefix("/", fs))
+    // Start the ball moving
+    server.Start()
 
-    // Handle WebSocket connections
-    http.HandleFunc("/ws", server.handleWS)
+    // Every request's context derives from this one, cancelling it ends
+    // all websocket connections
+    ctx, cancel := context.WithCancel(context.Background())
+    defer cancel()
 
-    slog.Info("🦍 STRONK SERVER STARTING ON PORT 42069 🦍",
+    // Only plain HTTP requests are held to these, upgraded websockets
+    // manage their own deadlines. Serving TLS also turns on HTTP/2 for
+    // them, websocket upgrades stay on HTTP/1.1.
+    httpServer := &http.Server{
+        Addr:              fmt.Sprintf(":%d", cfg.Port),
+        Handler:           server.Handler(),
+        BaseContext:       func(net.Listener) context.Context { return ctx },
+        ReadHeaderTimeout: cfg.HTTPReadHeaderTimeout,
+        WriteTimeout:      cfg.HTTPWriteTimeout,
+        IdleTimeout:       cfg.HTTPIdleTimeout,
+    }
+
+    go func() {
+        mode := "http"
+        if cfg.TLSCert != "" {
+            mode = "https"
+        }
+        slog.Info(fmt.Sprintf("🦍 STRONK SERVER STARTING ON PORT %d 🦍", cfg.Port),
+            "mode", mode,
+            "read_header_timeout", cfg.HTTPReadHeaderTimeout.String(),
+            "write_timeout", cfg.HTTPWriteTimeout.String(),
+            "idle_timeout", cfg.HTTPIdleTimeout.String(),
+            "timestamp", time.Now().Format(time.RFC3339))
+        var err error
+        if cfg.TLSCert != "" {
+            err = httpServer.ListenAndServeTLS(cfg.TLSCert, cfg.TLSKey)
+        } else {
+            err = httpServer.ListenAndServe()
+        }
+        if err != nil && err != http.ErrServerClosed {
+            slog.Error("Server failed to start",
+                "error", err,
+                "timestamp", time.Now().Format(time.RFC3339))
+            os.Exit(1)
+        }
+    }()
+
+    // Reload rulesets on SIGHUP, rooms that already exist keep theirs
+    reload := make(chan os.Signal, 1)
+    signal.Notify(reload, syscall.SIGHUP)
+    go func() {
+        for range reload {
+            if err := server.rulesets.Load(); err != nil {
+                slog.Error("Failed to reload rulesets, keeping the old ones",
+                    "error", err,
+                    "rooms_file", cfg.RoomsFile,
+                    "timestamp", time.Now().Format(time.RFC3339))
+            }
+        }
+    }()
+
+    // Wait for a shutdown signal
+    stop := make(chan os.Signal, 1)
+    signal.Notify(stop, os.Interrupt, syscall.SIGTERM)
+    sig := <-stop
+
+    slog.Info("🦍 STRONK SERVER SHUTTING DOWN 🦍",
+        "signal", sig.String(),
         "timestamp", time.Now().Format(time.RFC3339))
-    if err := http.ListenAndServe(":42069", nil); err != nil {
-        slog.Error("Server failed to start",
+
+    // Fail health checks so nothing new gets routed to us
+    server.shuttingDown.Store(true)
+    server.announceShutdown("server restarting")
+
+    shutdownCtx, cancelShutdown := context.WithTimeout(context.Background(), 5*time.Second)
+    defer cancelShutdown()
+    if err := httpServer.Shutdown(shutdownCtx); err != nil {
+        slog.Error("Server shutdown failed",
             "error", err,
             "timestamp", time.Now().Format(time.RFC3339))
-        os.Exit(1)
     }
+    // Shutdown doesn't track hijacked websocket connections, this closes
+    // them
+    cancel()
+    server.Stop()
 }
@@ -0,0 +1,442 @@
+package main
+
+import (
+    "net/http"
+    "runtime"
+    "strings"
+    "testing"
+    "time"
+
+    "github.com/ThePrimeagen/twitch-ext-pong/server/game"
+    "github.com/gorilla/websocket"
+)
+
+// Connects a player to channel and puts it on team
+func dialPlayer(t *testing.T, ts *testServer, channel, team string) *testClient {
+    t.Helper()
+    c := ts.dial(t, "channel="+channel)
+    c.expect(TypeInitialState)
+    c.send(TypeTeamAssign, TeamAssignment{Team: team})
+    c.expectMatch(TypeTeamAssign, func(msg Message) bool {
+        return decode[TeamAssignment](t, msg).Team == team
+    })
+    return c
+}
+
+func TestPaddleUpdateMovesOwnTeam(t *testing.T) {
+    ts := newTestServer(t, testConfig())
+    c := dialPlayer(t, ts, "route", "right")
+    start := game.CenteredPaddle(ts.cfg.Canvas, "left", game.PaddleHeight)
+
+    const y = 150
+    c.send(TypePaddleUpdate, game.PaddlePosition{Side: "right", Y: y})
+    state := c.expectState(func(s game.State) bool {
+        return s.RightPaddle.Y == y
+    })
+    if state.LeftPaddle.Y != start.Y {
+        t.Fatalf("left paddle moved to %v, want %v", state.LeftPaddle.Y, start.Y)
+    }
+}
+
+func TestOutOfBoundsPaddleUpdateErrors(t *testing.T) {
+    ts := newTestServer(t, testConfig())
+    c := dialPlayer(t, ts, "bounds", "left")
+
+    c.send(TypePaddleUpdate, game.PaddlePosition{Side: "left", Y: ts.cfg.Canvas.Height + 1})
+    payload := c.expectError(ErrCodeInvalidPosition)
+    if payload.Message == "" {
+        t.Fatalf("error has no message")
+    }
+}
+
+func TestPaddleMustFitAtItsSize(t *testing.T) {
+    ts := newTestServer(t, testConfig())
+    c := dialPlayer(t, ts, "fit", "left")
+    room := ts.room(t, "fit")
+    room.Lock()
+    room.gameState.LeftPaddle.Height = 200
+    room.Unlock()
+
+    // Fits a default paddle, the resized one would hang off the bottom
+    y := ts.cfg.Canvas.Height - 200 + 1
+    c.send(TypePaddleUpdate, game.PaddlePosition{Side: "left", Y: y})
+    c.expectError(ErrCodeInvalidPosition)
+}
+
+// A MemoryStore whose loads for one channel wait until release is closed
+type slowStore struct {
+    *MemoryStore
+    channel string
+    loading chan struct{}
+    release chan struct{}
+}
+
+func (s *slowStore) Load(room string) (game.State, error) {
+    if room == s.channel {
+        s.loading <- struct{}{}
+        <-s.release
+    }
+    return s.MemoryStore.Load(room)
+}
+
+func TestSlowLoadDoesNotBlockOtherRooms(t *testing.T) {
+    store := &slowStore{
+        MemoryStore: NewMemoryStore(),
+        channel:     "slow",
+        loading:     make(chan struct{}, 2),
+        release:     make(chan struct{}),
+    }
+    s := NewServer(testConfig(), store)
+    s.Start()
+    t.Cleanup(s.Stop)
+
+    rooms := make(chan *Room, 2)
+    for i := 0; i < 2; i++ {
+        go func() {
+            rooms <- s.room("slow")
+        }()
+    }
+    // Both load at once, so neither holds the server lock while loading
+    for i := 0; i < 2; i++ {
+        select {
+        case <-store.loading:
+        case <-time.After(testTimeout):
+            t.Fatalf("only %d of 2 lookups got to load", i)
+        }
+    }
+    fast := make(chan *Room, 1)
+    go func() {
+        fast <- s.room("fast")
+    }()
+    select {
+    case <-fast:
+    case <-time.After(testTimeout):
+        t.Fatalf("another channel's room waited on the slow load")
+    }
+
+    close(store.release)
+    if a, b := <-rooms, <-rooms; a != b {
+        t.Fatalf("two rooms created for one channel")
+    }
+}
+
+func TestPlayerCountReachesEveryone(t *testing.T) {
+    ts := newTestServer(t, testConfig())
+    a := ts.dial(t, "channel=count")
+    b := ts.dial(t, "channel=count&role=spectator")
+
+    for _, c := range []*testClient{a, b} {
+        c.expectMatch(TypePlayerCount, func(msg Message) bool {
+            return decode[PlayerCount](t, msg).Count == 2
+        })
+    }
+}
+
+func TestRoomsAreIsolated(t *testing.T) {
+    ts := newTestServer(t, testConfig())
+    player := dialPlayer(t, ts, "roomA", "left")
+    other := ts.dial(t, "channel=roomB")
+    initial := decode[InitialState](t, other.expect(TypeInitialState))
+
+    const y = 150
+    player.send(TypePaddleUpdate, game.PaddlePosition{Side: "left", Y: y})
+    player.expectState(func(s game.State) bool {
+        return s.LeftPaddle.Y == y
+    })
+
+    // Room A's paddle has arrived, anything leaking would show by now
+    deadline := time.Now().Add(200 * time.Millisecond)
+    for time.Now().Before(deadline) {
+        msg := other.receive()
+        if msg.Type == TypePaddleUpdate {
+            t.Fatalf("room B got a paddle update: %s", msg.Payload)
+        }
+        if msg.Type != TypeStateUpdate {
+            continue
+        }
+        if s := decode[game.State](t, msg); s.LeftPaddle.Y != initial.LeftPaddle.Y {
+            t.Fatalf("room B left paddle moved to %v", s.LeftPaddle.Y)
+        }
+    }
+}
+
+func TestSpectatorCannotMovePaddle(t *testing.T) {
+    ts := newTestServer(t, testConfig())
+    c := ts.dial(t, "channel=watch&role=spectator")
+    initial := decode[InitialState](t, c.expect(TypeInitialState))
+
+    c.send(TypePaddleUpdate, game.PaddlePosition{Side: "left", Y: 150})
+    c.expectError(ErrCodeForbidden)
+    if got := ts.room(t, "watch").snapshot(); got.LeftTarget != initial.LeftTarget {
+        t.Fatalf("left target = %v, want %v", got.LeftTarget, initial.LeftTarget)
+    }
+}
+
+func TestOversizedMessageClosesConnection(t *testing.T) {
+    ts := newTestServer(t, testConfig())
+    c := ts.dial(t, "channel=big")
+    c.expect(TypeInitialState)
+
+    big := make([]byte, 2048)
+    for i := range big {
+        big[i] = 'a'
+    }
+    c.sendRaw(frame(t, TypeChat, Chat{Text: string(big)}))
+    err := c.expectClosed()
+    if !websocket.IsCloseError(err, websocket.CloseMessageTooBig) {
+        t.Fatalf("closed with %v, want close code %d", err, websocket.CloseMessageTooBig)
+    }
+}
+
+func TestIdleConnectionReaped(t *testing.T) {
+    logs := captureLogs(t)
+    cfg := testConfig()
+    cfg.IdleTimeout = 100 * time.Millisecond
+    ts := newTestServer(t, cfg)
+    c := ts.dial(t, "channel=idle")
+
+    start := time.Now()
+    c.expectClosed()
+    if idle := time.Since(start); idle < cfg.IdleTimeout {
+        t.Fatalf("closed after %s, before the %s timeout", idle, cfg.IdleTimeout)
+    }
+    eventually(t, func() bool {
+        return ts.reapedConnections.Load() == 1
+    })
+    out := logs.String()
+    for _, want := range []string{`"msg":"Reaping idle connection"`, `"addr":"127.0.0.1"`, `"idle_duration":`} {
+        if !strings.Contains(out, want) {
+            t.Fatalf("reap log is missing %s:\n%s", want, out)
+        }
+    }
+}
+
+func TestMalformedFrameKeepsConnection(t *testing.T) {
+    ts := newTestServer(t, testConfig())
+    c := dialPlayer(t, ts, "garbage", "left")
+
+    c.sendRaw([]byte(`{"type": "paddle_update", "payload": {`))
+    c.expectError(ErrCodeBadMessage)
+
+    const y = 150
+    c.send(TypePaddleUpdate, game.PaddlePosition{Side: "left", Y: y})
+    c.expectState(func(s game.State) bool {
+        return s.LeftPaddle.Y == y
+    })
+}
+
+func TestCompressedInitialState(t *testing.T) {
+    ts := newTestServer(t, testConfig())
+    // Paused so the state we compare against holds still
+    room := ts.Server.room("deflate")
+    room.Lock()
+    room.gameState.Paused = true
+    room.Unlock()
+
+    c, resp, err := ts.dialer(t, &websocket.Dialer{EnableCompression: true}, "/ws", "channel=deflate", nil)
+    if err != nil {
+        t.Fatalf("dial: %v", err)
+    }
+    if ext := resp.Header.Get("Sec-Websocket-Extensions"); !strings.Contains(ext, "permessage-deflate") {
+        t.Fatalf("extensions = %q, want permessage-deflate", ext)
+    }
+    initial := decode[InitialState](t, c.expect(TypeInitialState))
+    if want := room.snapshot(); !initial.State.Equal(want) {
+        t.Fatalf("initial state = %+v, want %+v", initial.State, want)
+    }
+}
+
+func TestCancelledContextEndsConnection(t *testing.T) {
+    ts := newTestServer(t, testConfig())
+    c := ts.dial(t, "channel=ctx")
+    c.expect(TypeInitialState)
+    room := ts.room(t, "ctx")
+    // Handler, writer, pinger and the goroutine that closes the socket
+    before := runtime.NumGoroutine()
+
+    ts.cancel()
+
+    if err := c.expectClosed(); !websocket.IsCloseError(err, websocket.CloseGoingAway) {
+        t.Fatalf("closed with %v, want close code %d", err, websocket.CloseGoingAway)
+    }
+    eventually(t, func() bool {
+        room.RLock()
+        defer room.RUnlock()
+        return len(room.connections) == 0 && ts.connectionCount.Load() == 0
+    })
+    eventually(t, func() bool {
+        return runtime.NumGoroutine() <= before-4
+    })
+}
+
+func TestStaleSeqRejected(t *testing.T) {
+    ts := newTestServer(t, testConfig())
+    c := dialPlayer(t, ts, "seq", "left")
+
+    c.send(TypePaddleUpdate, game.PaddlePosition{Side: "left", Y: 200, Seq: 1})
+    c.send(TypePaddleUpdate, game.PaddlePosition{Side: "left", Y: 210, Seq: 3})
+    c.send(TypePaddleUpdate, game.PaddlePosition{Side: "left", Y: 150, Seq: 2})
+    c.expectError(ErrCodeStaleSeq)
+
+    // The paddle settles where seq 3 put it and reports that seq back
+    state := c.expectState(func(s game.State) bool {
+        return s.LeftPaddle.Y == 210
+    })
+    if state.LeftTarget != 210 || state.LeftPaddle.Seq != 3 {
+        t.Fatalf("left target %v at seq %d, want 210 at seq 3", state.LeftTarget, state.LeftPaddle.Seq)
+    }
+}
+
+func TestLatencyProbeEcho(t *testing.T) {
+    ts := newTestServer(t, testConfig())
+    c := ts.dial(t, "channel=probe")
+    c.expect(TypeInitialState)
+
+    // Odd precision and a fraction, it has to come back byte for byte
+    const clientTime = `1712345678901.123456789`
+    before := time.Now().UnixNano()
+    c.sendRaw([]byte(`{"type":"latency_probe","payload":{"clientTime":` + clientTime + `}}`))
+    msg := c.expect(TypeLatencyProbe)
+    after := time.Now().UnixNano()
+
+    probe := decode[LatencyProbe](t, msg)
+    if string(probe.ClientTime) != clientTime {
+        t.Fatalf("clientTime = %s, want %s", probe.ClientTime, clientTime)
+    }
+    if probe.ServerTime < before || probe.ServerTime > after {
+        t.Fatalf("serverTime = %d, want between %d and %d", probe.ServerTime, before, after)
+    }
+}
+
+func TestPaddleJumpClampedToMaxDelta(t *testing.T) {
+    cfg := testConfig()
+    cfg.MaxPaddleDelta = 100
+    ts := newTestServer(t, cfg)
+    c := dialPlayer(t, ts, "jump", "left")
+    start := game.CenteredPaddle(cfg.Canvas, "left", cfg.PaddleHeight).Y
+
+    // All the way to the top in one update only gets it 100 px closer
+    c.send(TypePaddleUpdate, game.PaddlePosition{Side: "left", Y: 0})
+    want := start - 100
+    state := c.expectState(func(s game.State) bool {
+        return s.LeftTarget != start
+    })
+    if state.LeftTarget != want {
+        t.Fatalf("left target = %v after a jump to 0 from %v, want %v", state.LeftTarget, start, want)
+    }
+}
+
+func TestWebsocketOutlivesHTTPTimeouts(t *testing.T) {
+    cfg := testConfig()
+    cfg.HTTPReadHeaderTimeout = 50 * time.Millisecond
+    cfg.HTTPWriteTimeout = 50 * time.Millisecond
+    cfg.HTTPIdleTimeout = 50 * time.Millisecond
+    ts := newTestServer(t, cfg)
+    c := ts.dial(t, "channel=timeouts")
+    c.expect(TypeInitialState)
+
+    // Well past every HTTP timeout, the socket keeps going both ways
+    time.Sleep(200 * time.Millisecond)
+    c.send(TypePing, nil)
+    c.expect(TypePing)
+    c.expect(TypeStateUpdate)
+
+    // Plain requests still get served under the same limits
+    if resp := ts.get(t, "/healthz"); resp.StatusCode != http.StatusOK {
+        t.Fatalf("GET /healthz = %d, want %d", resp.StatusCode, http.StatusOK)
+    }
+}
+
+func TestDecreasingTimestampsRejected(t *testing.T) {
+    ts := newTestServer(t, testConfig())
+    c := dialPlayer(t, ts, "ts", "left")
+
+    c.send(TypePaddleUpdate, game.PaddlePosition{Side: "left", Y: 210, Timestamp: 3000})
+    c.send(TypePaddleUpdate, game.PaddlePosition{Side: "left", Y: 150, Timestamp: 2000})
+    c.expectError(ErrCodeStaleTimestamp)
+    c.send(TypePaddleUpdate, game.PaddlePosition{Side: "left", Y: 120, Timestamp: 1000})
+    c.expectError(ErrCodeStaleTimestamp)
+    // Equal isn't newer either
+    c.send(TypePaddleUpdate, game.PaddlePosition{Side: "left", Y: 100, Timestamp: 3000})
+    c.expectError(ErrCodeStaleTimestamp)
+
+    // Only the newest got applied
+    state := c.expectState(func(s game.State) bool {
+        return s.LeftPaddle.Y == 210
+    })
+    if state.LeftTarget != 210 {
+        t.Fatalf("left target = %v, want 210 from the newest update", state.LeftTarget)
+    }
+}
+
+func TestShutdownNoticeBeforeClose(t *testing.T) {
+    cfg := testConfig()
+    cfg.ShutdownReconnectAfter = 7 * time.Second
+    ts := newTestServer(t, cfg)
+    c := ts.dial(t, "channel=bye")
+    c.expect(TypeInitialState)
+
+    // What main does on SIGTERM
+    ts.shuttingDown.Store(true)
+    ts.announceShutdown("server restarting")
+    ts.cancel()
+
+    notice := decode[ServerShutdown](t, c.expect(TypeServerShutdown))
+    if notice.Reason != "server restarting" || notice.ReconnectAfterSeconds != 7 {
+        t.Fatalf("shutdown notice = %+v, want the reason and 7 s", notice)
+    }
+    if err := c.expectClosed(); !websocket.IsCloseError(err, websocket.CloseGoingAway) {
+        t.Fatalf("closed with %v, want close code %d", err, websocket.CloseGoingAway)
+    }
+}
+
+func TestRequestStateOnlyToRequester(t *testing.T) {
+    ts := newTestServer(t, testConfig())
+    asker := ts.dial(t, "channel=resync")
+    id := decode[InitialState](t, asker.expect(TypeInitialState)).ConnectionID
+    other := ts.dial(t, "channel=resync")
+    other.expect(TypeInitialState)
+    room := ts.room(t, "resync")
+    room.Lock()
+    room.gameState.LeftScore = 3
+    room.gameState.Paused = true
+    room.Unlock()
+
+    asker.send(TypeRequestState, nil)
+    asker.expect(TypeConfig)
+    state := decode[InitialState](t, asker.expect(TypeInitialState))
+    if state.ConnectionID != id || state.LeftScore != 3 || !state.Paused {
+        t.Fatalf("snapshot for %s = %+v, want its own with the score at 3, paused", id, state)
+    }
+    other.expectNone(TypeInitialState, 200*time.Millisecond)
+}
+
+func TestVelocityHintEchoedInBounds(t *testing.T) {
+    cfg := testConfig()
+    ts := newTestServer(t, cfg)
+    c := dialPlayer(t, ts, "analog", "left")
+    bottom := cfg.Canvas.Height - cfg.PaddleHeight
+
+    // Faster than a paddle may go, heading off the bottom
+    c.send(TypePaddleUpdate, game.PaddlePosition{Side: "left", Y: 390, Velocity: 5000})
+    hinted := c.expectState(func(s game.State) bool {
+        return s.LeftPaddle.Velocity != 0
+    })
+    if hinted.LeftPaddle.Velocity != float64(cfg.MaxPaddleSpeed) {
+        t.Fatalf("echoed velocity %v, want it clamped to %d", hinted.LeftPaddle.Velocity, cfg.MaxPaddleSpeed)
+    }
+
+    // Extrapolated past where it was sent, never off the canvas, and the
+    // hint runs out
+    var extrapolated bool
+    c.expectState(func(s game.State) bool {
+        if s.LeftTarget > bottom || s.LeftPaddle.Y > bottom || s.LeftPaddle.Y < 0 {
+            t.Fatalf("paddle at %v heading for %v, off a canvas that ends at %v", s.LeftPaddle.Y, s.LeftTarget, bottom)
+        }
+        extrapolated = extrapolated || s.LeftTarget > 390
+        return s.LeftPaddle.Velocity == 0
+    })
+    if !extrapolated {
+        t.Fatalf("target never moved past the sent 390")
+    }
+}
@@ -0,0 +1,308 @@
+package main
+
+import (
+    "encoding/json"
+    "errors"
+    "fmt"
+
+    "github.com/ThePrimeagen/twitch-ext-pong/server/game"
+)
+
+// MessageType identifies what kind of payload a Message carries
+type MessageType string
+
+const (
+    // Server -> client: settings needed to render the game, sent right
+    // before initial_state
+    TypeConfig MessageType = "config"
+    // Server -> client: full game state sent right after connecting
+    TypeInitialState MessageType = "initial_state"
+    // Both directions: a paddle moved
+    TypePaddleUpdate MessageType = "paddle_update"
+    // Client -> server: pick a team, echoed back once accepted
+    TypeTeamAssign MessageType = "team_assign"
+    // Server -> client: one ball's position, sent every tick per ball in
+    // immediate mode
+    TypeBallUpdate MessageType = "ball_update"
+    // Server -> client: full game state, sent every tick
+    TypeStateUpdate MessageType = "state_update"
+    // Server -> client: someone scored
+    TypeScoreUpdate MessageType = "score_update"
+    // Server -> client: a side reached the win score, the match restarts
+    TypeGameOver MessageType = "game_over"
+    // Server -> client: the team is full, you're spectating until a slot frees
+    TypeQueued MessageType = "queued"
+    // Client -> server: switch between player and spectator, echoed back.
+    // Also sent by the server when it demotes an AFK player.
+    TypeJoin MessageType = "join"
+    // Client -> server: start the match over, broadcaster and mods only
+    TypeResetGame MessageType = "reset_game"
+    // Client -> server: freeze or unfreeze the ball, broadcaster and mods only
+    TypePause  MessageType = "pause"
+    TypeResume MessageType = "resume"
+    // Server -> client: the game was paused or resumed
+    TypePauseState MessageType = "pause_state"
+    // Both directions: chat text, relayed to the whole room
+    TypeChat MessageType = "chat"
+    // Client -> server: resize a team's paddle, broadcaster and mods only.
+    // Broadcast to everyone once applied.
+    TypeSetPaddleSize MessageType = "set_paddle_size"
+    // Server -> client: whole seconds left before the ball is served, 0
+    // when it goes
+    TypeCountdown MessageType = "countdown"
+    // Client -> server: put another ball in play, broadcaster and mods only
+    TypeSpawnBall MessageType = "spawn_ball"
+    // Client -> server: disconnect a connection or every connection of a
+    // viewer, optionally banning the viewer until the server restarts.
+    // Broadcaster and mods only.
+    TypeKick MessageType = "kick"
+    // Client -> server: keep a viewer out of the channel, for a while or
+    // until the server restarts, and disconnect them. Broadcaster and mods
+    // only.
+    TypeBan MessageType = "ban"
+    // Client -> server: lift a ban, broadcaster and mods only
+    TypeUnban MessageType = "unban"
+    // Client -> server: send config and initial_state again, for clients
+    // that lost track of the game. Rate limited.
+    TypeRequestState MessageType = "request_state"
+    // Server -> client: who controls each paddle, sent when that changes
+    TypePlayers MessageType = "players"
+    // Server -> client: how many people are connected
+    TypePlayerCount MessageType = "player_count"
+    // Both directions: ask for and get back the connection's measured
+    // round trip
+    TypePing MessageType = "ping"
+    // Both directions: the client sends its clock, the server echoes it
+    // right back with its own for clock sync. Not rate limited.
+    TypeLatencyProbe MessageType = "latency_probe"
+    // Server -> client: several messages in one frame, payload is an array
+    // of messages to handle in order. Only sent to clients that connected
+    // with ?batch=true.
+    TypeBatch MessageType = "batch"
+    // Server -> client: the server is going down, sent right before every
+    // connection is closed
+    TypeServerShutdown MessageType = "server_shutdown"
+    // Server -> client: the client's last message was dropped
+    TypeError MessageType = "error"
+)
+
+// ErrorCode tells clients why a message was dropped
+type ErrorCode string
+
+const (
+    ErrCodeBadMessage      ErrorCode = "BAD_MESSAGE"
+    ErrCodeUnknownType     ErrorCode = "UNKNOWN_TYPE"
+    ErrCodeInvalidPosition ErrorCode = "INVALID_POSITION"
+    ErrCodeInvalidSide     ErrorCode = "INVALID_SIDE"
+    ErrCodeRateLimited     ErrorCode = "RATE_LIMITED"
+    ErrCodeBadTeam         ErrorCode = "BAD_TEAM"
+    ErrCodeWrongTeam       ErrorCode = "WRONG_TEAM"
+    ErrCodeForbidden       ErrorCode = "FORBIDDEN"
+    ErrCodeBadRole         ErrorCode = "BAD_ROLE"
+    ErrCodePaused          ErrorCode = "PAUSED"
+    ErrCodeTooManyBalls    ErrorCode = "TOO_MANY_BALLS"
+    ErrCodeServerFull      ErrorCode = "SERVER_FULL"
+    ErrCodeStaleSeq        ErrorCode = "STALE_SEQ"
+    ErrCodeStaleTimestamp  ErrorCode = "STALE_TIMESTAMP"
+    ErrCodeNotConnected    ErrorCode = "NOT_CONNECTED"
+    ErrCodeNotBanned       ErrorCode = "NOT_BANNED"
+)
+
+// Chat is the payload of a chat message. User is filled in by the server,
+// whatever the client sends there is ignored.
+type Chat struct {
+    User string `json:"user"`
+    Text string `json:"text"`
+}
+
+// Ping is the payload of the server's ping reply
+type Ping struct {
+    // Smoothed websocket ping round trip, 0 until it has been measured
+    RTTMillis float64 `json:"rttMs"`
+}
+
+// LatencyProbe is the payload of a latency_probe message
+type LatencyProbe struct {
+    // Whatever the client sent, echoed back byte for byte
+    ClientTime json.RawMessage `json:"clientTime"`
+    // Server clock in Unix nanoseconds when the probe was answered
+    ServerTime int64 `json:"serverTime"`
+}
+
+// Players is the payload of a players message, the opaque ids of whoever
+// controls each paddle, longest playing first
+type Players struct {
+    Left  []string `json:"left"`
+    Right []string `json:"right"`
+}
+
+// ServerShutdown is the payload of a server_shutdown message
+type ServerShutdown struct {
+    Reason string `json:"reason,omitempty"`
+    // Reconnecting sooner likely hits the server still down, 0 when unknown
+    ReconnectAfterSeconds int `json:"reconnectAfterSeconds"`
+}
+
+// PlayerCount is the payload of a player_count message
+type PlayerCount struct {
+    Count int64 `json:"count"`
+}
+
+// ErrorPayload is the payload of an error message
+type ErrorPayload struct {
+    Code    ErrorCode `json:"code"`
+    Message string    `json:"message"`
+}
+
+// Message is the envelope for everything sent over the websocket
+type Message struct {
+    Type    MessageType     `json:"type"`
+    Payload json.RawMessage `json:"payload"`
+    // Per room number of state and ball frames, counting up from 1 since
+    // the last reset so clients can spot dropped or reordered frames.
+    // Throttled spectators count separately. Unset on every other message.
+    Frame uint64 `json:"frame,omitempty"`
+}
+
+// NewMessage marshals v into the payload of a message of type t
+func NewMessage(t MessageType, v any) (Message, error) {
+    payload, err := json.Marshal(v)
+    if err != nil {
+        return Message{}, err
+    }
+    return Message{Type: t, Payload: payload}, nil
+}
+
+// TeamAssignment is the payload of a team_assign message
+type TeamAssignment struct {
+    Team string `json:"team"`
+}
+
+// Validate makes sure the team is one we actually have a paddle for
+func (t TeamAssignment) Validate() error {
+    if t.Team != "left" && t.Team != "right" {
+        return fmt.Errorf("invalid team %q: must be \"left\" or \"right\"", t.Team)
+    }
+    return nil
+}
+
+// Queued is the payload of a queued message
+type Queued struct {
+    Team     string `json:"team"`
+    Position int    `json:"position"`
+}
+
+// PaddleSize is the payload of a set_paddle_size message
+type PaddleSize struct {
+    Side   string  `json:"side"`
+    Height float64 `json:"height"`
+}
+
+// Validate makes sure the side is one we know, the height gets clamped
+// instead of rejected
+func (p PaddleSize) Validate() error {
+    return game.ValidateSide(p.Side)
+}
+
+// Kick is the payload of a kick message
+type Kick struct {
+    // Connection id as sent in initial_state
+    ConnectionID string `json:"connectionId,omitempty"`
+    // Opaque user id, kicks every connection of the viewer
+    UserID string `json:"userId,omitempty"`
+    // Keep the viewer out of the channel until the server restarts
+    Ban bool `json:"ban,omitempty"`
+}
+
+// Validate makes sure the kick names someone
+func (k Kick) Validate() error {
+    if k.ConnectionID == "" && k.UserID == "" {
+        return errors.New("kick needs a connectionId or userId")
+    }
+    return nil
+}
+
+// Ban is the payload of ban and unban messages
+type Ban struct {
+    // Opaque user id of the viewer
+    UserID string `json:"userId"`
+    // How long the ban lasts, 0 until the server restarts. Ignored by unban.
+    TTLSeconds int `json:"ttlSeconds,omitempty"`
+}
+
+// Validate makes sure the ban names someone and doesn't run backwards
+func (b Ban) Validate() error {
+    if b.UserID == "" {
+        return errors.New("ban needs a userId")
+    }
+    if b.TTLSeconds < 0 {
+        return fmt.Errorf("invalid ttlSeconds %d: must not be negative", b.TTLSeconds)
+    }
+    return nil
+}
+
+// Join is the payload of a join message
+type Join struct {
+    Role string `json:"role"`
+    // Why the server changed the role on its own, empty when the client
+    // asked for it
+    Reason string `json:"reason,omitempty"`
+}
+
+// Validate makes sure the role is one we know
+func (j Join) Validate() error {
+    if ClientRole(j.Role) != RolePlayer && ClientRole(j.Role) != RoleSpectator {
+        return fmt.Errorf("invalid role %q: must be \"player\" or \"spectator\"", j.Role)
+    }
+    return nil
+}
+
+// Countdown is the payload of a countdown message
+type Countdown struct {
+    Seconds int `json:"seconds"`
+}
+
+// PauseState is the payload of a pause_state message
+type PauseState struct {
+    Paused bool `json:"paused"`
+}
+
+// ClientConfig is the payload of a config message
+type ClientConfig struct {
+    Canvas game.Canvas `json:"canvas"`
+    // Paddle size before any resizing, and gap between paddle and edge
+    PaddleWidth  float64 `json:"paddleWidth"`
+    PaddleHeight float64 `json:"paddleHeight"`
+    PaddleOffset float64 `json:"paddleOffset"`
+    BallRadius   float64 `json:"ballRadius"`
+    TickRate     int     `json:"tickRate"`
+    WinScore     int     `json:"winScore"`
+}
+
+// InitialState is the payload of an initial_state message, the game state
+// plus what clients need to scale it to their screen
+type InitialState struct {
+    game.State
+    Canvas game.Canvas `json:"canvas"`
+    // Who controls each paddle right now, players messages follow changes
+    Players Players `json:"players"`
+    // Id of the receiving connection, quote it when reporting problems.
+    // Empty when the state is sent to the whole room.
+    ConnectionID string `json:"connectionId,omitempty"`
+    // Pass as ?reconnect= after a dropped connection to get the team back.
+    // Only sent to the receiving connection.
+    ReconnectToken string `json:"reconnectToken,omitempty"`
+}
+
+// Score is the payload of a score_update message
+type Score struct {
+    Left  int `json:"left"`
+    Right int `json:"right"`
+}
+
+// GameOver is the payload of a game_over message
+type GameOver struct {
+    Winner string `json:"winner"`
+    Left   int    `json:"left"`
+    Right  int    `json:"right"`
+}
@@ -0,0 +1,21 @@
+package main
+
+import (
+    "testing"
+)
+
+func TestInitialStateMatchesGameState(t *testing.T) {
+    ts := newTestServer(t, testConfig())
+    // Paused so the loop leaves the state alone while we compare
+    room := ts.Server.room("initial")
+    room.Lock()
+    room.gameState.Paused = true
+    room.Unlock()
+
+    c := ts.dial(t, "channel=initial")
+    initial := decode[InitialState](t, c.expect(TypeInitialState))
+
+    if want := room.snapshot(); !initial.State.Equal(want) {
+        t.Fatalf("initial state = %+v, want %+v", initial.State, want)
+    }
+}
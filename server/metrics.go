@@ -0,0 +1,142 @@
+package main
+
+import (
+    "fmt"
+    "net/http"
+    "sort"
+    "strings"
+    "time"
+)
+
+// Write a single metric in Prometheus text format
+func writeMetric(b *strings.Builder, name, kind, help string, value int64) {
+    fmt.Fprintf(b, "# HELP %s %s\n", name, help)
+    fmt.Fprintf(b, "# TYPE %s %s\n", name, kind)
+    fmt.Fprintf(b, "%s %d\n", name, value)
+}
+
+// Write a single metric with a fractional value
+func writeFloatMetric(b *strings.Builder, name, kind, help string, value float64) {
+    fmt.Fprintf(b, "# HELP %s %s\n", name, help)
+    fmt.Fprintf(b, "# TYPE %s %s\n", name, kind)
+    fmt.Fprintf(b, "%s %g\n", name, value)
+}
+
+// Mean of every connection's smoothed RTT in seconds, connections that
+// haven't answered a ping yet don't count
+func (s *Server) averageRTT() float64 {
+    var total time.Duration
+    var n int
+
+    s.RLock()
+    defer s.RUnlock()
+    for _, room := range s.rooms {
+        room.RLock()
+        for client := range room.connections {
+            if rtt := client.RTT(); rtt > 0 {
+                total += rtt
+                n++
+            }
+        }
+        room.RUnlock()
+    }
+    if n == 0 {
+        return 0
+    }
+    return (total / time.Duration(n)).Seconds()
+}
+
+// roomActivity is how many of a room's connections sent something
+// recently and how many went quiet
+type roomActivity struct {
+    channel string
+    active  int64
+    stale   int64
+}
+
+// Per room active and stale connection counts, sorted by channel.
+// Practice rooms come and go with every session, they are summed into
+// one entry instead so the label set stays bounded.
+func (s *Server) roomActivity() (rooms []roomActivity, practice roomActivity) {
+    now := time.Now()
+    staleAfter := s.cfg.StaleAfter
+
+    s.RLock()
+    rooms = make([]roomActivity, 0, len(s.rooms))
+    for channel, room := range s.rooms {
+        activity := roomActivity{channel: channel}
+        if room.practice {
+            activity = practice
+        }
+        room.RLock()
+        for client := range room.connections {
+            if client.stale(now, staleAfter) {
+                activity.stale++
+            } else {
+                activity.active++
+            }
+        }
+        room.RUnlock()
+        if room.practice {
+            practice = activity
+            continue
+        }
+        rooms = append(rooms, activity)
+    }
+    s.RUnlock()
+
+    sort.Slice(rooms, func(i, j int) bool { return rooms[i].channel < rooms[j].channel })
+    return rooms, practice
+}
+
+// Write the per room connection gauge, one sample per room and state, and
+// the practice rooms' total. Channel ids are restricted to safe characters
+// so they need no escaping. Returns the stale connections across all of
+// them.
+func writeRoomActivity(b *strings.Builder, rooms []roomActivity, practice roomActivity) int64 {
+    var stale int64
+    b.WriteString("# HELP pong_room_connections Open connections per room, stale ones sent nothing for STALE_AFTER_SECONDS.\n")
+    b.WriteString("# TYPE pong_room_connections gauge\n")
+    for _, room := range rooms {
+        fmt.Fprintf(b, "pong_room_connections{channel=%q,state=\"active\"} %d\n", room.channel, room.active)
+        fmt.Fprintf(b, "pong_room_connections{channel=%q,state=\"stale\"} %d\n", room.channel, room.stale)
+        stale += room.stale
+    }
+    b.WriteString("# HELP pong_practice_connections Open connections in practice rooms, stale ones sent nothing for STALE_AFTER_SECONDS.\n")
+    b.WriteString("# TYPE pong_practice_connections gauge\n")
+    fmt.Fprintf(b, "pong_practice_connections{state=\"active\"} %d\n", practice.active)
+    fmt.Fprintf(b, "pong_practice_connections{state=\"stale\"} %d\n", practice.stale)
+    return stale + practice.stale
+}
+
+// handleMetrics exposes server counters in Prometheus text format
+func (s *Server) handleMetrics(w http.ResponseWriter, r *http.Request) {
+    var b strings.Builder
+    writeMetric(&b, "pong_connections", "gauge",
+        "Currently open websocket connections.", s.connectionCount.Load())
+    writeMetric(&b, "pong_players", "gauge",
+        "Connections controlling a paddle.", s.players.Load())
+    writeMetric(&b, "pong_spectators", "gauge",
+        "Connections only watching.", s.spectators.Load())
+    writeMetric(&b, "pong_connections_total", "counter",
+        "Websocket connections accepted since start.", s.totalConnections.Load())
+    writeMetric(&b, "pong_paddle_updates_total", "counter",
+        "Paddle updates applied.", s.paddleUpdates.Load())
+    writeMetric(&b, "pong_broadcasts_total", "counter",
+        "Messages broadcast to a room.", s.broadcasts.Load())
+    writeMetric(&b, "pong_reaped_connections_total", "counter",
+        "Connections dropped for missing pongs or going idle.", s.reapedConnections.Load())
+    writeMetric(&b, "pong_slow_consumers_total", "counter",
+        "Connections dropped because their send buffer filled up.", s.slowConsumers.Load())
+    writeMetric(&b, "pong_game_loop_panics_total", "counter",
+        "Game loop panics recovered by resetting the room.", s.gameLoopPanics.Load())
+    writeFloatMetric(&b, "pong_rtt_seconds", "gauge",
+        "Average smoothed websocket ping round trip across connections.", s.averageRTT())
+    rooms, practice := s.roomActivity()
+    stale := writeRoomActivity(&b, rooms, practice)
+    writeMetric(&b, "pong_stale_connections", "gauge",
+        "Connections that sent nothing for STALE_AFTER_SECONDS across rooms.", stale)
+
+    w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+    w.Write([]byte(b.String()))
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+    "bufio"
+    "strings"
+    "testing"
+    "time"
+)
+
+// Scrapes /metrics into samples keyed by name and labels, as written
+func scrape(t *testing.T, ts *testServer) map[string]string {
+    t.Helper()
+    resp := ts.get(t, "/metrics")
+    samples := make(map[string]string)
+    scanner := bufio.NewScanner(resp.Body)
+    for scanner.Scan() {
+        line := scanner.Text()
+        if strings.HasPrefix(line, "#") {
+            continue
+        }
+        if i := strings.LastIndexByte(line, ' '); i > 0 {
+            samples[line[:i]] = line[i+1:]
+        }
+    }
+    if err := scanner.Err(); err != nil {
+        t.Fatalf("read metrics: %v", err)
+    }
+    return samples
+}
+
+func TestMetricsCountConnection(t *testing.T) {
+    ts := newTestServer(t, testConfig())
+    c := ts.dial(t, "channel=metrics")
+    c.expect(TypeInitialState)
+
+    samples := scrape(t, ts)
+    for name, want := range map[string]string{
+        "pong_connections":       "1",
+        "pong_connections_total": "1",
+        "pong_players":           "1",
+        "pong_spectators":        "0",
+    } {
+        if got := samples[name]; got != want {
+            t.Errorf("%s = %q, want %q", name, got, want)
+        }
+    }
+}
+
+func TestStaleGaugeCountsIdleConnection(t *testing.T) {
+    cfg := testConfig()
+    cfg.StaleAfter = 300 * time.Millisecond
+    ts := newTestServer(t, cfg)
+    active := ts.dial(t, "channel=stale")
+    active.expect(TypeInitialState)
+    idle := ts.dial(t, "channel=stale")
+    idle.expect(TypeInitialState)
+
+    // Only one of them keeps talking past the stale threshold
+    deadline := time.Now().Add(2 * cfg.StaleAfter)
+    for time.Now().Before(deadline) {
+        active.send(TypePing, nil)
+        active.expect(TypePing)
+        time.Sleep(50 * time.Millisecond)
+    }
+
+    samples := scrape(t, ts)
+    for name, want := range map[string]string{
+        "pong_stale_connections": "1",
+        `pong_room_connections{channel="stale",state="active"}`: "1",
+        `pong_room_connections{channel="stale",state="stale"}`:  "1",
+    } {
+        if got := samples[name]; got != want {
+            t.Errorf("%s = %q, want %q", name, got, want)
+        }
+    }
+}
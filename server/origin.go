@@ -0,0 +1,59 @@
+package main
+
+import (
+    "net/http"
+    "net/url"
+    "strings"
+    "time"
+
+    "golang.org/x/exp/slog"
+)
+
+// Origins allowed to open a websocket when ALLOWED_ORIGINS isn't set
+var DefaultAllowedOrigins = []string{"*.ext-twitch.tv"}
+
+// originAllowed checks origin against the allowlist. Entries can be a full
+// origin ("http://localhost:8080"), a host ("localhost") or a wildcard
+// subdomain ("*.ext-twitch.tv").
+func originAllowed(origin string, allowed []string) bool {
+    u, err := url.Parse(origin)
+    if err != nil || u.Host == "" {
+        return false
+    }
+    host := strings.ToLower(u.Hostname())
+
+    for _, pattern := range allowed {
+        pattern = strings.ToLower(pattern)
+        switch {
+        case strings.HasPrefix(pattern, "*."):
+            if strings.HasSuffix(host, pattern[1:]) {
+                return true
+            }
+        case strings.Contains(pattern, "://"):
+            if strings.ToLower(origin) == pattern {
+                return true
+            }
+        case host == pattern || strings.ToLower(u.Host) == pattern:
+            return true
+        }
+    }
+    return false
+}
+
+// checkOrigin is the upgrader's CheckOrigin, a false return makes the
+// upgrade fail with a 403
+func (s *Server) checkOrigin(r *http.Request) bool {
+    origin := r.Header.Get("Origin")
+    // Non browser clients don't send an origin
+    if origin == "" {
+        return true
+    }
+    if originAllowed(origin, s.cfg.AllowedOrigins) {
+        return true
+    }
+    slog.Warn("Rejected connection from disallowed origin",
+        "origin", origin,
+        "remote_addr", s.remoteAddr(r),
+        "timestamp", time.Now().Format(time.RFC3339))
+    return false
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+    "net/http"
+    "testing"
+)
+
+func TestUpgradeChecksOrigin(t *testing.T) {
+    cfg := testConfig()
+    cfg.AllowedOrigins = []string{"*.ext-twitch.tv", "http://localhost:8080"}
+    ts := newTestServer(t, cfg)
+
+    for _, tc := range []struct {
+        origin string
+        ok     bool
+    }{
+        {"", true},
+        {"https://abc123.ext-twitch.tv", true},
+        {"http://localhost:8080", true},
+        {"https://evil.example.com", false},
+        {"https://ext-twitch.tv.evil.com", false},
+        {"http://localhost:9090", false},
+    } {
+        header := http.Header{}
+        if tc.origin != "" {
+            header.Set("Origin", tc.origin)
+        }
+        _, resp, err := ts.dialWith(t, "", header)
+        if (err == nil) != tc.ok {
+            t.Errorf("origin %q: dial error %v, want ok %v", tc.origin, err, tc.ok)
+            continue
+        }
+        if !tc.ok && resp.StatusCode != http.StatusForbidden {
+            t.Errorf("origin %q: status %d, want %d", tc.origin, resp.StatusCode, http.StatusForbidden)
+        }
+    }
+}
+
+func TestOriginAllowed(t *testing.T) {
+    allowed := []string{"*.ext-twitch.tv", "localhost", "http://127.0.0.1:8080"}
+    for _, tc := range []struct {
+        origin string
+        want   bool
+    }{
+        {"https://abc.ext-twitch.tv", true},
+        {"https://ABC.EXT-TWITCH.TV", true},
+        {"http://localhost:3000", true},
+        {"http://127.0.0.1:8080", true},
+        {"http://127.0.0.1:9090", false},
+        {"https://ext-twitch.tv", false},
+        {"not a url", false},
+    } {
+        if got := originAllowed(tc.origin, allowed); got != tc.want {
+            t.Errorf("originAllowed(%q) = %v, want %v", tc.origin, got, tc.want)
+        }
+    }
+}
@@ -0,0 +1,39 @@
+package main
+
+import "time"
+
+// Side a practicing viewer plays from, the other one is a wall
+const PracticeTeam = "left"
+
+// Practice rooms are removed this soon after their player leaves, nobody
+// else can get in
+const PracticeIdleTimeout = time.Second
+
+// Practice rooms aren't channels, the colon keeps ?channel= from ever
+// reaching one
+func practiceRoomKey(connID string) string {
+    return "practice:" + connID
+}
+
+// NewPracticeRoom creates a room for one viewer rallying against a wall on
+// the right. Nothing is loaded, saved or recorded.
+func NewPracticeRoom(server *Server, key string) *Room {
+    cfg := server.cfg
+    cfg.RoomIdleTimeout = PracticeIdleTimeout
+    // Keep the player on the left and the wall side empty
+    cfg.AutoBalance = false
+    cfg.AIEnabled = false
+    r := newRoom(server, key, cfg)
+    r.practice = true
+    return r
+}
+
+// Create and start a practice room for client
+func (s *Server) practiceRoom(client *Client) *Room {
+    s.Lock()
+    defer s.Unlock()
+
+    room := NewPracticeRoom(s, practiceRoomKey(client.id))
+    s.startRoomLocked(room)
+    return room
+}
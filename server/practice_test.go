@@ -0,0 +1,40 @@
+package main
+
+import (
+    "testing"
+
+    "github.com/ThePrimeagen/twitch-ext-pong/server/game"
+)
+
+func TestPracticeBallBouncesOffRightWall(t *testing.T) {
+    ts := newTestServer(t, testConfig())
+    c := ts.dial(t, "practice=true")
+    id := decode[InitialState](t, c.expect(TypeInitialState)).ConnectionID
+    if team := decode[TeamAssignment](t, c.expect(TypeTeamAssign)).Team; team != PracticeTeam {
+        t.Fatalf("practicing on %q, want %q", team, PracticeTeam)
+    }
+    room := ts.room(t, practiceRoomKey(id))
+
+    // Heading into the wall, well away from where the right paddle sits
+    room.Lock()
+    room.gameState.Countdown = 0
+    room.gameState.Balls = []game.Ball{{X: ts.cfg.Canvas.Width - 60, Y: 40, VX: 600}}
+    room.Unlock()
+
+    state := c.expectState(func(s game.State) bool {
+        return len(s.Balls) == 1 && s.Balls[0].VX < 0
+    })
+    if state.LeftScore != 0 || state.RightScore != 0 {
+        t.Fatalf("score %d-%d after hitting the wall, want 0-0", state.LeftScore, state.RightScore)
+    }
+
+    samples := scrape(t, ts)
+    if got := samples[`pong_practice_connections{state="active"}`]; got != "1" {
+        t.Errorf("practice connections = %q, want 1", got)
+    }
+    for name := range samples {
+        if name == `pong_room_connections{channel="`+practiceRoomKey(id)+`",state="active"}` {
+            t.Errorf("practice room listed as a channel: %s", name)
+        }
+    }
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+    "net/http"
+    "slices"
+    "strings"
+
+    "github.com/gorilla/websocket"
+)
+
+// Message format versions, offered as websocket subprotocols
+const (
+    ProtocolV1 = "pong.v1"
+    // Same messages as v1, sent as MessagePack binary frames
+    ProtocolV1MsgPack = "pong.v1.msgpack"
+    // Every version starts with this, anything else clients offer (like a
+    // JWT) isn't a version request
+    protocolPrefix = "pong."
+)
+
+// Versions we speak, preferred first
+var SupportedProtocols = []string{ProtocolV1MsgPack, ProtocolV1}
+
+// negotiateProtocol picks the message format version for r. Returns the
+// version, whether the client asked for it so it must be echoed back, and
+// false if the client only asked for versions we don't speak. Clients that
+// don't ask get v1 since that's what they were written against.
+func negotiateProtocol(r *http.Request) (protocol string, requested bool, ok bool) {
+    var offered []string
+    for _, p := range websocket.Subprotocols(r) {
+        if strings.HasPrefix(p, protocolPrefix) {
+            offered = append(offered, p)
+        }
+    }
+    if len(offered) == 0 {
+        return ProtocolV1, false, true
+    }
+    for _, p := range SupportedProtocols {
+        if slices.Contains(offered, p) {
+            return p, true, true
+        }
+    }
+    return "", false, false
+}
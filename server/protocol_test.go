@@ -0,0 +1,37 @@
+package main
+
+import (
+    "net/http"
+    "testing"
+)
+
+func TestProtocolNegotiation(t *testing.T) {
+    ts := newTestServer(t, testConfig())
+
+    for _, tc := range []struct {
+        offered string
+        want    string
+        status  int
+    }{
+        {"", "", http.StatusSwitchingProtocols},
+        {ProtocolV1, ProtocolV1, http.StatusSwitchingProtocols},
+        {ProtocolV1 + ", " + ProtocolV1MsgPack, ProtocolV1MsgPack, http.StatusSwitchingProtocols},
+        {"pong.v99", "", http.StatusBadRequest},
+    } {
+        header := http.Header{}
+        if tc.offered != "" {
+            header.Set("Sec-WebSocket-Protocol", tc.offered)
+        }
+        c, resp, err := ts.dialWith(t, "", header)
+        if resp == nil {
+            t.Fatalf("offering %q: %v", tc.offered, err)
+        }
+        if resp.StatusCode != tc.status {
+            t.Errorf("offering %q: status %d, want %d", tc.offered, resp.StatusCode, tc.status)
+            continue
+        }
+        if c != nil && c.conn.Subprotocol() != tc.want {
+            t.Errorf("offering %q: got %q, want %q", tc.offered, c.conn.Subprotocol(), tc.want)
+        }
+    }
+}
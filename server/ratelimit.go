@@ -0,0 +1,39 @@
+package main
+
+import (
+    "time"
+)
+
+// RateLimiter is a token bucket. It is only used from a connection's read
+// loop so it isn't safe for concurrent use.
+type RateLimiter struct {
+    // Tokens added per second
+    rate float64
+    // Max tokens the bucket holds
+    burst  float64
+    tokens float64
+    last   time.Time
+}
+
+// NewRateLimiter allows rate events per second with bursts up to burst
+func NewRateLimiter(rate float64, burst int) *RateLimiter {
+    return &RateLimiter{
+        rate:   rate,
+        burst:  float64(burst),
+        tokens: float64(burst),
+        last:   time.Now(),
+    }
+}
+
+// Allow takes a token if one is available
+func (l *RateLimiter) Allow() bool {
+    now := time.Now()
+    l.tokens = min(l.burst, l.tokens+now.Sub(l.last).Seconds()*l.rate)
+    l.last = now
+
+    if l.tokens < 1 {
+        return false
+    }
+    l.tokens--
+    return true
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+    "testing"
+    "time"
+
+    "github.com/ThePrimeagen/twitch-ext-pong/server/game"
+)
+
+func TestPaddleUpdatesRateLimited(t *testing.T) {
+    t.Setenv("PADDLE_RATE_LIMIT", "20")
+    cfg, err := LoadConfig()
+    if err != nil {
+        t.Fatal(err)
+    }
+    cfg.StaticDir = ""
+    cfg.RoomsFile = ""
+    ts := newTestServer(t, cfg)
+    c := dialPlayer(t, ts, "flood", "left")
+
+    start := time.Now()
+    for i := 0; i < 500; i++ {
+        c.send(TypePaddleUpdate, game.PaddlePosition{Side: "left", Y: float64(200 + i%100)})
+        // Each rejection is answered, reading them now and then keeps us
+        // from being dropped as a slow consumer. Messages are handled in
+        // order so the reply means everything before it was read.
+        if i%50 == 49 {
+            c.send(TypePing, nil)
+            c.expect(TypePing)
+        }
+    }
+
+    applied := ts.paddleUpdates.Load()
+    limit := int64(cfg.PaddleRate) + int64(time.Since(start).Seconds()*float64(cfg.PaddleRate)) + 1
+    if applied == 0 || applied > limit {
+        t.Fatalf("applied %d of 500 updates, want 1 to %d", applied, limit)
+    }
+}
+
+func TestRateLimiterRefills(t *testing.T) {
+    l := NewRateLimiter(10, 2)
+    if !l.Allow() || !l.Allow() {
+        t.Fatalf("burst of 2 not allowed")
+    }
+    if l.Allow() {
+        t.Fatalf("allowed past the burst")
+    }
+    // A tenth of a second at 10 per second is one more token
+    l.last = l.last.Add(-100 * time.Millisecond)
+    if !l.Allow() {
+        t.Fatalf("no token after refilling")
+    }
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+    "crypto/rand"
+    "encoding/hex"
+    "sync"
+    "time"
+
+    "golang.org/x/exp/slog"
+)
+
+// How long a dropped player's team is held for them unless configured
+// otherwise
+const DefaultReconnectGrace = 30 * time.Second
+
+// pendingReconnect is a token handed out on join. Once its connection
+// drops it remembers the team until it expires.
+type pendingReconnect struct {
+    channel string
+    userID  string
+    team    string
+    // Zero while the connection is still up
+    expires time.Time
+}
+
+// Reconnects holds reconnection tokens so a player whose connection blips
+// gets their team back. Safe for concurrent use.
+type Reconnects struct {
+    sync.Mutex
+    pending map[string]pendingReconnect
+}
+
+func NewReconnects() *Reconnects {
+    return &Reconnects{pending: make(map[string]pendingReconnect)}
+}
+
+// Issue returns a new token for userID in channel
+func (rc *Reconnects) Issue(channel, userID string) string {
+    var b [16]byte
+    if _, err := rand.Read(b[:]); err != nil {
+        // Not worth failing the connection over, it just can't reconnect
+        return ""
+    }
+    token := hex.EncodeToString(b[:])
+
+    rc.Lock()
+    defer rc.Unlock()
+    rc.pending[token] = pendingReconnect{channel: channel, userID: userID}
+    return token
+}
+
+// Park starts the grace period for a dropped connection's token, holding
+// team for it. Tokens of connections that had no team are dropped.
+func (rc *Reconnects) Park(token, team string, grace time.Duration) {
+    if token == "" {
+        return
+    }
+    rc.Lock()
+    defer rc.Unlock()
+    rc.expire(time.Now())
+
+    p, ok := rc.pending[token]
+    if !ok {
+        return
+    }
+    if team == "" {
+        delete(rc.pending, token)
+        return
+    }
+    p.team = team
+    p.expires = time.Now().Add(grace)
+    rc.pending[token] = p
+}
+
+// Claim uses up a parked token, returning the team it held. The token only
+// works for the same viewer in the same channel within the grace period.
+func (rc *Reconnects) Claim(token, channel, userID string) (string, bool) {
+    if token == "" {
+        return "", false
+    }
+    rc.Lock()
+    defer rc.Unlock()
+    rc.expire(time.Now())
+
+    p, ok := rc.pending[token]
+    if !ok || p.expires.IsZero() || p.channel != channel || p.userID != userID {
+        return "", false
+    }
+    delete(rc.pending, token)
+    return p.team, true
+}
+
+// Forget tokens whose grace period ran out. Caller must hold the lock.
+func (rc *Reconnects) expire(now time.Time) {
+    for token, p := range rc.pending {
+        if !p.expires.IsZero() && now.After(p.expires) {
+            delete(rc.pending, token)
+        }
+    }
+}
+
+// Put a reconnected client back on the team it held, skipping auto balance
+// since the team was already fair when it left. Tells the client the same
+// way a team_assign would.
+func (r *Room) restoreTeam(client *Client, team string) {
+    r.Lock()
+    position, promoted := r.assignTeamLocked(client, team)
+    r.Unlock()
+    r.notifyPromoted(promoted)
+
+    slog.Info("Restored team after reconnect",
+        "team", team,
+        "position", position,
+        "addr", client.addr,
+        "conn_id", client.id,
+        "timestamp", time.Now().Format(time.RFC3339))
+    if position > 0 {
+        client.Send(TypeQueued, Queued{Team: team, Position: position})
+        return
+    }
+    client.Send(TypeTeamAssign, TeamAssignment{Team: team})
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+    "net/url"
+    "testing"
+    "time"
+)
+
+func TestReconnectRestoresTeam(t *testing.T) {
+    cfg := testConfig()
+    cfg.ExtensionSecret = testSecret
+    ts := newTestServer(t, cfg)
+    token := signTestJWT(t, TwitchClaims{ChannelID: "blip", OpaqueUserID: "U5", Role: "viewer"})
+
+    c := ts.dial(t, "token="+token)
+    reconnect := decode[InitialState](t, c.expect(TypeInitialState)).ReconnectToken
+    if reconnect == "" {
+        t.Fatalf("initial state has no reconnect token")
+    }
+    c.send(TypeTeamAssign, TeamAssignment{Team: "right"})
+    c.expect(TypeTeamAssign)
+    c.conn.Close()
+    room := ts.room(t, "blip")
+    eventually(t, func() bool {
+        room.RLock()
+        defer room.RUnlock()
+        return len(room.connections) == 0
+    })
+
+    query := url.Values{"token": {token}, "reconnect": {reconnect}}
+    back := ts.dial(t, query.Encode())
+    back.expect(TypeInitialState)
+    if got := decode[TeamAssignment](t, back.expect(TypeTeamAssign)).Team; got != "right" {
+        t.Fatalf("team after reconnect = %q, want right", got)
+    }
+
+    // Used up, a second try gets no team
+    again := ts.dial(t, query.Encode())
+    again.expect(TypeInitialState)
+    again.expectNone(TypeTeamAssign, 200*time.Millisecond)
+}
+
+func TestReconnectTokenBoundToViewer(t *testing.T) {
+    rc := NewReconnects()
+    token := rc.Issue("blip", "U5")
+    if _, ok := rc.Claim(token, "blip", "U5"); ok {
+        t.Fatalf("claimed a token whose connection is still up")
+    }
+    rc.Park(token, "left", time.Minute)
+    if _, ok := rc.Claim(token, "blip", "U6"); ok {
+        t.Fatalf("another viewer claimed the token")
+    }
+    if _, ok := rc.Claim(token, "other", "U5"); ok {
+        t.Fatalf("token worked in another channel")
+    }
+    if team, ok := rc.Claim(token, "blip", "U5"); !ok || team != "left" {
+        t.Fatalf("Claim = %q, %v, want left", team, ok)
+    }
+
+    expired := rc.Issue("blip", "U5")
+    rc.Park(expired, "left", time.Millisecond)
+    time.Sleep(5 * time.Millisecond)
+    if _, ok := rc.Claim(expired, "blip", "U5"); ok {
+        t.Fatalf("claimed a token past its grace period")
+    }
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+    "bufio"
+    "encoding/json"
+    "fmt"
+    "os"
+    "path/filepath"
+    "sync"
+    "time"
+
+    "golang.org/x/exp/slog"
+)
+
+// Recording settings
+const (
+    // Messages waiting to be written before new ones get dropped
+    RecordBufferSize = 1024
+    // How often buffered recordings are flushed to disk
+    RecordFlushInterval = time.Second
+)
+
+// RecordedMessage is one line of a recording
+type RecordedMessage struct {
+    At      time.Time `json:"at"`
+    Message Message   `json:"message"`
+}
+
+// Recorder writes every message broadcast in a room to a newline delimited
+// JSON file. Record never blocks, writing happens on its own goroutine.
+type Recorder struct {
+    // Guards closed so Record never sends on a closed channel
+    mu     sync.RWMutex
+    closed bool
+    path   string
+    file   *os.File
+    queue  chan RecordedMessage
+    wg     sync.WaitGroup
+}
+
+// NewRecorder creates dir/<channel>-<start>.ndjson and starts writing to it
+func NewRecorder(dir, channel string, start time.Time) (*Recorder, error) {
+    if err := os.MkdirAll(dir, 0o755); err != nil {
+        return nil, err
+    }
+    name := fmt.Sprintf("%s-%s.ndjson", channel, start.UTC().Format("20060102T150405Z"))
+    path := filepath.Join(dir, name)
+    // Appending, a room that loses the race to be created for the same
+    // channel in the same second mustn't truncate the winner's recording
+    file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o644)
+    if err != nil {
+        return nil, err
+    }
+
+    r := &Recorder{
+        path:  path,
+        file:  file,
+        queue: make(chan RecordedMessage, RecordBufferSize),
+    }
+    r.wg.Add(1)
+    go r.writeLoop()
+    return r, nil
+}
+
+// Record queues msg with the current time. Messages are dropped if the
+// writer can't keep up or the recorder is closed.
+func (r *Recorder) Record(msg Message) {
+    r.mu.RLock()
+    defer r.mu.RUnlock()
+
+    if r.closed {
+        return
+    }
+    select {
+    case r.queue <- RecordedMessage{At: time.Now(), Message: msg}:
+    default:
+        slog.Warn("Recorder falling behind, dropping message",
+            "path", r.path,
+            "type", msg.Type,
+            "timestamp", time.Now().Format(time.RFC3339))
+    }
+}
+
+// Close writes out everything queued and closes the file
+func (r *Recorder) Close() error {
+    r.mu.Lock()
+    if r.closed {
+        r.mu.Unlock()
+        return nil
+    }
+    r.closed = true
+    close(r.queue)
+    r.mu.Unlock()
+
+    r.wg.Wait()
+    return r.file.Close()
+}
+
+func (r *Recorder) writeLoop() {
+    defer r.wg.Done()
+
+    w := bufio.NewWriter(r.file)
+    enc := json.NewEncoder(w)
+    flush := func() {
+        if err := w.Flush(); err != nil {
+            slog.Error("Failed to flush recording",
+                "error", err,
+                "path", r.path,
+                "timestamp", time.Now().Format(time.RFC3339))
+        }
+    }
+
+    ticker := time.NewTicker(RecordFlushInterval)
+    defer ticker.Stop()
+
+    for {
+        select {
+        case rec, ok := <-r.queue:
+            if !ok {
+                flush()
+                return
+            }
+            if err := enc.Encode(rec); err != nil {
+                slog.Error("Failed to write recording",
+                    "error", err,
+                    "path", r.path,
+                    "timestamp", time.Now().Format(time.RFC3339))
+            }
+        case <-ticker.C:
+            flush()
+        }
+    }
+}
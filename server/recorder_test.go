@@ -0,0 +1,82 @@
+package main
+
+import (
+    "bufio"
+    "encoding/json"
+    "os"
+    "testing"
+    "time"
+)
+
+// Records a chat message per text into dir and returns the recording's
+// path once it is closed
+func recordChats(t *testing.T, dir string, texts ...string) string {
+    t.Helper()
+    rec, err := NewRecorder(dir, "test", time.Now())
+    if err != nil {
+        t.Fatalf("NewRecorder: %v", err)
+    }
+    for _, text := range texts {
+        msg, err := NewMessage(TypeChat, Chat{Text: text})
+        if err != nil {
+            t.Fatal(err)
+        }
+        rec.Record(msg)
+    }
+    if err := rec.Close(); err != nil {
+        t.Fatalf("Close: %v", err)
+    }
+    return rec.path
+}
+
+func TestRecorderWritesMessages(t *testing.T) {
+    texts := []string{"one", "two", "three"}
+    path := recordChats(t, t.TempDir(), texts...)
+
+    file, err := os.Open(path)
+    if err != nil {
+        t.Fatalf("open recording: %v", err)
+    }
+    defer file.Close()
+
+    var got []string
+    var prev time.Time
+    scanner := bufio.NewScanner(file)
+    for scanner.Scan() {
+        var rec RecordedMessage
+        if err := json.Unmarshal(scanner.Bytes(), &rec); err != nil {
+            t.Fatalf("line %d: %v", len(got)+1, err)
+        }
+        if rec.Message.Type != TypeChat {
+            t.Fatalf("line %d type = %q, want %q", len(got)+1, rec.Message.Type, TypeChat)
+        }
+        if rec.At.Before(prev) {
+            t.Fatalf("line %d recorded at %v, before the line above", len(got)+1, rec.At)
+        }
+        prev = rec.At
+        var chat Chat
+        json.Unmarshal(rec.Message.Payload, &chat)
+        got = append(got, chat.Text)
+    }
+    if len(got) != len(texts) {
+        t.Fatalf("read back %v, want %v", got, texts)
+    }
+    for i := range texts {
+        if got[i] != texts[i] {
+            t.Fatalf("read back %v, want %v", got, texts)
+        }
+    }
+}
+
+func TestRecorderDropsAfterClose(t *testing.T) {
+    rec, err := NewRecorder(t.TempDir(), "test", time.Now())
+    if err != nil {
+        t.Fatalf("NewRecorder: %v", err)
+    }
+    rec.Close()
+    // Must not panic on the closed queue
+    rec.Record(Message{Type: TypeChat})
+    if err := rec.Close(); err != nil {
+        t.Fatalf("second Close: %v", err)
+    }
+}
@@ -0,0 +1,33 @@
+package main
+
+import (
+    "net"
+    "net/http"
+    "strings"
+)
+
+// clientIP returns the address of whoever made r. Behind a proxy we trust
+// that is the first X-Forwarded-For entry or X-Real-IP, anyone can send
+// those headers so otherwise it is always the TCP peer.
+func clientIP(r *http.Request, trustProxy bool) string {
+    if trustProxy {
+        if forwarded := r.Header.Get("X-Forwarded-For"); forwarded != "" {
+            first, _, _ := strings.Cut(forwarded, ",")
+            if ip := strings.TrimSpace(first); net.ParseIP(ip) != nil {
+                return ip
+            }
+        }
+        if ip := strings.TrimSpace(r.Header.Get("X-Real-IP")); net.ParseIP(ip) != nil {
+            return ip
+        }
+    }
+    if host, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+        return host
+    }
+    return r.RemoteAddr
+}
+
+// remoteAddr is clientIP with the server's proxy setting
+func (s *Server) remoteAddr(r *http.Request) string {
+    return clientIP(r, s.cfg.TrustProxy)
+}
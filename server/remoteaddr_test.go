@@ -0,0 +1,35 @@
+package main
+
+import (
+    "net/http/httptest"
+    "testing"
+)
+
+func TestClientIP(t *testing.T) {
+    for _, tc := range []struct {
+        name      string
+        trust     bool
+        forwarded string
+        realIP    string
+        want      string
+    }{
+        {"untrusted ignores forwarded", false, "203.0.113.7", "", "192.0.2.1"},
+        {"untrusted ignores real ip", false, "", "203.0.113.7", "192.0.2.1"},
+        {"trusted takes the first forwarded", true, "203.0.113.7, 10.0.0.1", "", "203.0.113.7"},
+        {"trusted falls back to real ip", true, "", "203.0.113.8", "203.0.113.8"},
+        {"trusted skips garbage", true, "not-an-ip", "", "192.0.2.1"},
+        {"trusted without headers", true, "", "", "192.0.2.1"},
+    } {
+        r := httptest.NewRequest("GET", "/ws", nil)
+        r.RemoteAddr = "192.0.2.1:5555"
+        if tc.forwarded != "" {
+            r.Header.Set("X-Forwarded-For", tc.forwarded)
+        }
+        if tc.realIP != "" {
+            r.Header.Set("X-Real-IP", tc.realIP)
+        }
+        if got := clientIP(r, tc.trust); got != tc.want {
+            t.Errorf("%s: clientIP = %q, want %q", tc.name, got, tc.want)
+        }
+    }
+}
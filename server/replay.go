@@ -0,0 +1,148 @@
+package main
+
+import (
+    "bufio"
+    "encoding/json"
+    "net/http"
+    "os"
+    "path/filepath"
+    "strconv"
+    "strings"
+    "time"
+
+    "github.com/gorilla/websocket"
+    "golang.org/x/exp/slog"
+)
+
+// Playback speed limits for /replay
+const (
+    MinReplaySpeed = 0.1
+    MaxReplaySpeed = 16
+)
+
+// Longest recorded line we accept
+const maxReplayLine = 1 << 20
+
+// Resolve a recording name from the query to a path inside the record
+// directory. Only bare file names are accepted so nothing outside the
+// directory can be read.
+func replayPath(dir, name string) (string, bool) {
+    if dir == "" || name == "" || name != filepath.Base(name) || strings.HasPrefix(name, ".") {
+        return "", false
+    }
+    if !strings.HasSuffix(name, ".ndjson") {
+        return "", false
+    }
+    return filepath.Join(dir, name), true
+}
+
+// handleReplay streams a recorded match over a websocket, keeping the
+// original gaps between messages divided by the speed query param
+func (s *Server) handleReplay(w http.ResponseWriter, r *http.Request) {
+    path, ok := replayPath(s.cfg.RecordDir, r.URL.Query().Get("file"))
+    if !ok {
+        http.Error(w, "invalid recording", http.StatusBadRequest)
+        return
+    }
+
+    speed := 1.0
+    if v := r.URL.Query().Get("speed"); v != "" {
+        parsed, err := strconv.ParseFloat(v, 64)
+        if err != nil || parsed < MinReplaySpeed || parsed > MaxReplaySpeed {
+            http.Error(w, "invalid speed", http.StatusBadRequest)
+            return
+        }
+        speed = parsed
+    }
+
+    file, err := os.Open(path)
+    if err != nil {
+        http.Error(w, "recording not found", http.StatusNotFound)
+        return
+    }
+    defer file.Close()
+
+    conn, err := s.upgrader.Upgrade(w, r, nil)
+    if err != nil {
+        slog.Error("Failed to upgrade replay connection",
+            "error", err,
+            "remote_addr", s.remoteAddr(r),
+            "timestamp", time.Now().Format(time.RFC3339))
+        return
+    }
+    defer conn.Close()
+
+    slog.Info("Replay started",
+        "file", filepath.Base(path),
+        "speed", speed,
+        "addr", s.remoteAddr(r),
+        "timestamp", time.Now().Format(time.RFC3339))
+
+    // Watch for the viewer leaving, we never expect messages from them
+    gone := make(chan struct{})
+    go func() {
+        defer close(gone)
+        for {
+            if _, _, err := conn.NextReader(); err != nil {
+                return
+            }
+        }
+    }()
+
+    scanner := bufio.NewScanner(file)
+    scanner.Buffer(make([]byte, 0, 64*1024), maxReplayLine)
+
+    sent := 0
+    var prev time.Time
+    for scanner.Scan() {
+        var rec RecordedMessage
+        if err := json.Unmarshal(scanner.Bytes(), &rec); err != nil {
+            slog.Warn("Skipping bad replay line",
+                "error", err,
+                "file", filepath.Base(path),
+                "timestamp", time.Now().Format(time.RFC3339))
+            continue
+        }
+
+        if !prev.IsZero() {
+            wait := time.Duration(float64(rec.At.Sub(prev)) / speed)
+            if wait > 0 {
+                select {
+                case <-gone:
+                    return
+                case <-r.Context().Done():
+                    // Server shutting down
+                    return
+                case <-time.After(wait):
+                }
+            }
+        }
+        prev = rec.At
+
+        conn.SetWriteDeadline(time.Now().Add(WriteTimeout))
+        if err := conn.WriteJSON(rec.Message); err != nil {
+            slog.Debug("Replay write failed",
+                "error", err,
+                "addr", s.remoteAddr(r),
+                "timestamp", time.Now().Format(time.RFC3339))
+            return
+        }
+        sent++
+    }
+    if err := scanner.Err(); err != nil {
+        slog.Error("Failed to read recording",
+            "error", err,
+            "file", filepath.Base(path),
+            "timestamp", time.Now().Format(time.RFC3339))
+    }
+
+    slog.Info("Replay finished",
+        "file", filepath.Base(path),
+        "messages", sent,
+        "addr", s.remoteAddr(r),
+        "timestamp", time.Now().Format(time.RFC3339))
+
+    // Let the viewer know this was the end rather than a dropped connection
+    closeMsg := websocket.FormatCloseMessage(websocket.CloseNormalClosure, "replay finished")
+    conn.WriteControl(websocket.CloseMessage, closeMsg, time.Now().Add(WriteTimeout))
+}
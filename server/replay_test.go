@@ -0,0 +1,49 @@
+package main
+
+import (
+    "path/filepath"
+    "testing"
+
+    "github.com/gorilla/websocket"
+)
+
+func TestReplayPlaysBackRecording(t *testing.T) {
+    cfg := testConfig()
+    cfg.RecordDir = t.TempDir()
+    texts := []string{"one", "two", "three", "four"}
+    path := recordChats(t, cfg.RecordDir, texts...)
+    ts := newTestServer(t, cfg)
+
+    c, _, err := ts.dialer(t, websocket.DefaultDialer, "/replay", "speed=16&file="+filepath.Base(path), nil)
+    if err != nil {
+        t.Fatalf("dial: %v", err)
+    }
+
+    for _, want := range texts {
+        if got := decode[Chat](t, c.expect(TypeChat)); got.Text != want {
+            t.Fatalf("replayed %q, want %q", got.Text, want)
+        }
+    }
+    if err := c.expectClosed(); !websocket.IsCloseError(err, websocket.CloseNormalClosure) {
+        t.Fatalf("replay ended with %v, want a normal close", err)
+    }
+}
+
+func TestReplayPath(t *testing.T) {
+    for _, tc := range []struct {
+        dir, name string
+        ok        bool
+    }{
+        {"rec", "default-20240101T000000Z.ndjson", true},
+        {"rec", "../secrets.ndjson", false},
+        {"rec", "sub/match.ndjson", false},
+        {"rec", ".hidden.ndjson", false},
+        {"rec", "match.json", false},
+        {"", "match.ndjson", false},
+        {"rec", "", false},
+    } {
+        if _, ok := replayPath(tc.dir, tc.name); ok != tc.ok {
+            t.Errorf("replayPath(%q, %q) ok = %v, want %v", tc.dir, tc.name, ok, tc.ok)
+        }
+    }
+}
@@ -0,0 +1,1063 @@
+package main
+
+import (
+    "errors"
+    "fmt"
+    "math"
+    "math/rand"
+    "os"
+    "regexp"
+    "runtime/debug"
+    "sync"
+    "sync/atomic"
+    "time"
+
+    "github.com/ThePrimeagen/twitch-ext-pong/server/game"
+    "golang.org/x/exp/slog"
+)
+
+// Channel used when a client doesn't ask for one
+const DefaultChannel = "default"
+
+// How often a room's state is written to the store when it changed
+const StateSaveInterval = 5 * time.Second
+
+// Channel ids are Twitch ids in practice, keep anything else out of logs
+// and room keys
+var channelPattern = regexp.MustCompile(`^[A-Za-z0-9_-]{1,64}$`)
+
+// Room is an isolated game for a single channel
+type Room struct {
+    // Mutex to protect connections and game state
+    sync.RWMutex
+    channel string
+    server  *Server
+    // Server config with the channel's ruleset applied, fixed for the
+    // room's lifetime
+    cfg Config
+    // Connections in this room, for team bookkeeping. Broadcasts go
+    // through the hub.
+    connections map[*Client]bool
+    hub         *Hub
+    // Per team spectators waiting for a paddle, first in line first
+    waiting map[string][]*Client
+    // Per side paddle Y input received this tick, used by the crowd
+    // control modes
+    inputs map[string][]float64
+    // Per side velocity hint from the last paddle update, last write mode
+    // only
+    hints map[string]velocityHint
+    gameState   game.State
+    // Set when connections come or go, cleared once the count is sent
+    playerCountDirty atomic.Bool
+    // Set when someone takes or gives up a paddle, cleared once the
+    // players message is sent
+    playersDirty atomic.Bool
+    // Set when the game state changes, cleared once it is saved
+    stateDirty atomic.Bool
+    // Records every broadcast when recording is on
+    recorder *Recorder
+    // Random source for serves, seeded from the config so matches can be
+    // reproduced. Protected by the mutex.
+    rng *rand.Rand
+    // Match statistics for /stats, protected by the mutex
+    stats Stats
+    // Last finished matches for /history, protected by the mutex
+    history *History
+    // Per side connection that last moved the paddle, protected by the
+    // mutex
+    lastController map[string]*Client
+    // Per side AI for paddles without players, protected by the mutex
+    ai map[string]*aiPaddle
+    // When the last connection left, protected by the mutex
+    emptySince time.Time
+    // Set once the room was removed for being idle, a client that looked
+    // it up just before then has to look again. Protected by the mutex.
+    closed bool
+    // One viewer rallying against a wall on the right, nothing is saved
+    practice bool
+    // Closed when the game loop exits, stops the hub with it
+    done chan struct{}
+    // Last state frames sent to players and to throttled spectators, for
+    // only on change broadcasting. Only touched by the game loop.
+    playerFilter    stateFilter
+    spectatorFilter stateFilter
+    // Called under the lock at the start of every tick, tests use it to
+    // inject panics. Always nil outside tests.
+    tickHook func()
+    // Last frame numbers given out to players and throttled spectators,
+    // reset with the match
+    playerFrames    atomic.Uint64
+    spectatorFrames atomic.Uint64
+}
+
+// NewRoom creates the room for channel, picking up its last saved state
+func NewRoom(server *Server, channel string) *Room {
+    cfg := server.rulesets.Config(channel, server.cfg)
+    r := newRoom(server, channel, cfg)
+
+    state, err := server.store.Load(channel)
+    switch {
+    case err == nil:
+        r.gameState = state
+        // State saved before multiball has no balls
+        if len(r.gameState.Balls) == 0 {
+            r.gameState.Balls = []game.Ball{game.NewBall(cfg.Canvas, cfg.BallSpeed, r.rng)}
+        }
+        // State saved before paddles could be resized
+        if r.gameState.LeftPaddle.Height == 0 {
+            r.gameState.LeftPaddle.Height = cfg.PaddleHeight
+        }
+        if r.gameState.RightPaddle.Height == 0 {
+            r.gameState.RightPaddle.Height = cfg.PaddleHeight
+        }
+        // Nobody is steering yet, keep the paddles where they were
+        r.gameState.LeftTarget = state.LeftPaddle.Y
+        r.gameState.RightTarget = state.RightPaddle.Y
+        r.gameState.LeftPaddle.Velocity = 0
+        r.gameState.RightPaddle.Velocity = 0
+        slog.Info("Restored room state",
+            "channel", channel,
+            "left_score", state.LeftScore,
+            "right_score", state.RightScore,
+            "timestamp", time.Now().Format(time.RFC3339))
+    case !errors.Is(err, ErrStateNotFound):
+        slog.Error("Failed to load room state",
+            "error", err,
+            "channel", channel,
+            "timestamp", time.Now().Format(time.RFC3339))
+    }
+
+    if dir := cfg.RecordDir; dir != "" {
+        recorder, err := NewRecorder(dir, channel, time.Now())
+        if err != nil {
+            slog.Error("Failed to start recording",
+                "error", err,
+                "channel", channel,
+                "timestamp", time.Now().Format(time.RFC3339))
+        } else {
+            r.recorder = recorder
+        }
+    }
+    return r
+}
+
+// Throw away a room that lost the race to be created for its channel to
+// winner. It never ran, only its recording has to go.
+func (r *Room) discard(winner *Room) {
+    if r.recorder == nil {
+        return
+    }
+    r.recorder.Close()
+    // Rooms created in the same second record to the same file
+    if winner.recorder != nil && winner.recorder.path == r.recorder.path {
+        return
+    }
+    if err := os.Remove(r.recorder.path); err != nil {
+        slog.Warn("Failed to remove unused recording",
+            "error", err,
+            "path", r.recorder.path,
+            "channel", r.channel,
+            "timestamp", time.Now().Format(time.RFC3339))
+    }
+}
+
+// Room with cfg and a fresh match, nothing loaded or recorded yet
+func newRoom(server *Server, channel string, cfg Config) *Room {
+    rng := NewRoomRand(cfg.Seed, channel)
+    done := make(chan struct{})
+    r := &Room{
+        channel:        channel,
+        server:         server,
+        cfg:            cfg,
+        connections:    make(map[*Client]bool),
+        hub:            NewHub(done, &server.slowConsumers),
+        waiting:        make(map[string][]*Client),
+        inputs:         make(map[string][]float64),
+        hints:          make(map[string]velocityHint),
+        gameState:      newGameState(cfg, rng),
+        rng:            rng,
+        stats:          newStats(),
+        history:        NewHistory(cfg.HistorySize),
+        ai:             map[string]*aiPaddle{"left": {}, "right": {}},
+        lastController: make(map[string]*Client),
+        emptySince:     time.Now(),
+        done:           done,
+    }
+
+    r.resetPaddles()
+    return r
+}
+
+// Copy of the game state, taken under the read lock. The deferred unlock
+// keeps a panic from leaving the room locked.
+func (r *Room) snapshot() game.State {
+    r.RLock()
+    defer r.RUnlock()
+    return r.gameState.Clone()
+}
+
+// players under the read lock, with a deferred unlock like snapshot
+func (r *Room) lockedPlayers() Players {
+    r.RLock()
+    defer r.RUnlock()
+    return r.players()
+}
+
+// paddleSize under the read lock, for checking input before taking the
+// lock to apply it
+func (r *Room) lockedPaddleSize(side string) float64 {
+    r.RLock()
+    defer r.RUnlock()
+    return r.paddleSize(side)
+}
+
+// Write the state to the store if it changed since the last save
+func (r *Room) saveState() {
+    if r.practice || !r.stateDirty.Swap(false) {
+        return
+    }
+    state := r.snapshot()
+
+    if err := r.server.store.Save(r.channel, state); err != nil {
+        slog.Error("Failed to save room state",
+            "error", err,
+            "channel", r.channel,
+            "timestamp", time.Now().Format(time.RFC3339))
+    }
+}
+
+// State at the start of a match, paddles are placed by resetPaddles
+func newGameState(cfg Config, rng *rand.Rand) game.State {
+    return game.State{
+        Balls: []game.Ball{firstBall(cfg, rng)},
+    }
+}
+
+// The ball a match opens with, a fixed launch when configured for
+// predictable demos and a random serve otherwise
+func firstBall(cfg Config, rng *rand.Rand) game.Ball {
+    if cfg.InitialBallSpeed > 0 {
+        return game.LaunchBall(cfg.Canvas, cfg.InitialBallSpeed, cfg.InitialBallAngle)
+    }
+    return game.NewBall(cfg.Canvas, cfg.BallSpeed, rng)
+}
+
+// Center both paddles at the configured size on the configured canvas,
+// nobody steering them anywhere else yet. Caller must hold the lock.
+func (r *Room) resetPaddles() {
+    c, height := r.cfg.Canvas, r.cfg.PaddleHeight
+    r.gameState.LeftPaddle = game.CenteredPaddle(c, "left", height)
+    r.gameState.RightPaddle = game.CenteredPaddle(c, "right", height)
+    r.gameState.LeftTarget = r.gameState.LeftPaddle.Y
+    r.gameState.RightTarget = r.gameState.RightPaddle.Y
+    clear(r.hints)
+}
+
+// Put paddles, balls, score and stats back to the start of a match. Caller
+// must hold the lock.
+func (r *Room) reset() {
+    r.gameState = newGameState(r.cfg, r.rng)
+    r.resetPaddles()
+    r.gameState.Countdown = r.cfg.ServeCountdown.Seconds()
+    r.stats = newStats()
+    r.playerFrames.Store(0)
+    r.spectatorFrames.Store(0)
+    r.stateDirty.Store(true)
+}
+
+// Build the initial_state message for the current state, addressed to
+// client or the whole room when nil. Caller must hold at least the read
+// lock.
+func (r *Room) initialStateMessage(client *Client) (Message, error) {
+    state := InitialState{
+        State:   r.gameState,
+        Canvas:  r.cfg.Canvas,
+        Players: r.players(),
+    }
+    if client != nil {
+        state.ConnectionID = client.id
+        state.ReconnectToken = client.reconnectToken
+    }
+    return NewMessage(TypeInitialState, state)
+}
+
+// Config and initial_state for client, everything it needs to draw the
+// game from scratch. Caller must hold at least the read lock.
+func (r *Room) fullState(client *Client) []Message {
+    config, err := NewMessage(TypeConfig, r.cfg.clientConfig())
+    if err != nil {
+        slog.Error("Failed to build config",
+            "error", err,
+            "channel", r.channel,
+            "timestamp", time.Now().Format(time.RFC3339))
+        return nil
+    }
+    initial, err := r.initialStateMessage(client)
+    if err != nil {
+        slog.Error("Failed to build initial state",
+            "error", err,
+            "channel", r.channel,
+            "timestamp", time.Now().Format(time.RFC3339))
+        return nil
+    }
+    return []Message{config, initial}
+}
+
+// Queue the full state for client. Caller must hold at least the read
+// lock.
+func (r *Room) queueFullState(client *Client) {
+    for _, msg := range r.fullState(client) {
+        client.Queue(msg)
+    }
+}
+
+// Add a client to the room. The hub queues it the initial state, taken
+// under the same lock, right before the first frame it sends it. Returns
+// false when the room was already removed for being idle.
+func (r *Room) join(client *Client) bool {
+    r.Lock()
+    if r.closed {
+        r.Unlock()
+        return false
+    }
+    client.room = r
+    r.connections[client] = true
+    r.server.countRole(client.role, 1)
+    // Still under the lock so no frame built after the initial state is
+    // missed
+    r.hub.Register(client, client.role, r.fullState(client)...)
+    r.Unlock()
+    r.playerCountDirty.Store(true)
+    return true
+}
+
+// Remove a client from the room, handing its paddle to the next waiter
+func (r *Room) leave(client *Client) {
+    r.Lock()
+    r.hub.Unregister(client)
+    team := client.team
+    promoted := r.vacate(client)
+    snapped, abandoned := r.abandonPaddle(client, team)
+    delete(r.connections, client)
+    r.server.countRole(client.role, -1)
+    if len(r.connections) == 0 {
+        r.emptySince = time.Now()
+    }
+    moved := r.rebalance()
+    r.Unlock()
+    r.playerCountDirty.Store(true)
+    r.notifyPromoted(promoted)
+    for _, c := range moved {
+        r.notifyPromoted(c)
+    }
+    // Eased paddles show up in the regular frames as they move, a snap
+    // has to be sent
+    if abandoned {
+        msg, err := NewMessage(TypePaddleUpdate, snapped)
+        if err != nil {
+            slog.Error("Failed to build paddle update",
+                "error", err,
+                "channel", r.channel,
+                "timestamp", time.Now().Format(time.RFC3339))
+            return
+        }
+        r.broadcast(msg)
+    }
+}
+
+// Send team's paddle back to the center when client was the last to move
+// it and nobody took over after it left. Returns the paddle and true when
+// it snapped there. Caller must hold the lock.
+func (r *Room) abandonPaddle(client *Client, team string) (game.PaddlePosition, bool) {
+    if team == "" || r.lastController[team] != client {
+        return game.PaddlePosition{}, false
+    }
+    delete(r.lastController, team)
+    mode := r.cfg.AbandonMode
+    if mode == AbandonStay || r.controllers(team) > 0 {
+        return game.PaddlePosition{}, false
+    }
+
+    paddle, target := &r.gameState.LeftPaddle, &r.gameState.LeftTarget
+    if team == "right" {
+        paddle, target = &r.gameState.RightPaddle, &r.gameState.RightTarget
+    }
+    center := (r.cfg.Canvas.Height - paddle.Height) / 2
+    // Input the player sent just before leaving would pull it back
+    delete(r.inputs, team)
+    delete(r.hints, team)
+    paddle.Velocity = 0
+    *target = center
+    slog.Info("Recentering abandoned paddle",
+        "channel", r.channel,
+        "side", team,
+        "mode", mode,
+        "conn_id", client.id,
+        "timestamp", time.Now().Format(time.RFC3339))
+    if mode != AbandonSnap {
+        return game.PaddlePosition{}, false
+    }
+    paddle.Y = center
+    paddle.VY = 0
+    r.stateDirty.Store(true)
+    return *paddle, true
+}
+
+// Send a message to every client in the room
+func (r *Room) broadcast(msg Message) {
+    r.broadcastTo(msg, "")
+}
+
+// Send a message to every client in the room with role, everyone when role
+// is empty. Spectator only frames repeat what players already got, so
+// they aren't recorded.
+func (r *Room) broadcastTo(msg Message, role ClientRole) {
+    r.server.broadcasts.Add(1)
+    if r.recorder != nil && role != RoleSpectator {
+        r.recorder.Record(msg)
+    }
+    r.hub.Broadcast(msg, role)
+}
+
+// Whether spectators get state on their own slower ticker instead of
+// every tick. Immediate mode always sends at the full rate.
+func (r *Room) spectatorsThrottled() bool {
+    cfg := r.cfg
+    return !cfg.ImmediateBroadcast && cfg.SpectatorRate < cfg.TickRate
+}
+
+// Send spectators the current state, called on the spectator ticker
+func (r *Room) sendSpectatorState() {
+    state := r.snapshot()
+    if state.Paused && !r.cfg.InputWhilePaused {
+        return
+    }
+
+    cfg := r.cfg
+    if cfg.OnlyOnChange && r.spectatorFilter.skip(state, time.Now(), cfg.Heartbeat) {
+        return
+    }
+    msg, err := NewMessage(TypeStateUpdate, state)
+    if err != nil {
+        slog.Error("Failed to build frame",
+            "error", err,
+            "channel", r.channel,
+            "timestamp", time.Now().Format(time.RFC3339))
+        return
+    }
+    msg.Frame = r.spectatorFrames.Add(1)
+    r.broadcastTo(msg, RoleSpectator)
+}
+
+// run is the room's game loop, it exits when the server stops or the room
+// is removed for being idle
+func (r *Room) run() {
+    defer r.server.loopWG.Done()
+    defer close(r.done)
+
+    tickRate := r.cfg.TickRate
+    ticker := time.NewTicker(time.Second / time.Duration(tickRate))
+    defer ticker.Stop()
+
+    countTicker := time.NewTicker(PlayerCountInterval)
+    defer countTicker.Stop()
+
+    saveTicker := time.NewTicker(StateSaveInterval)
+    defer saveTicker.Stop()
+
+    // Nil channel when spectators keep up with players, it never fires
+    var spectatorTick <-chan time.Time
+    if r.spectatorsThrottled() {
+        spectatorTicker := time.NewTicker(time.Second / time.Duration(r.cfg.SpectatorRate))
+        defer spectatorTicker.Stop()
+        spectatorTick = spectatorTicker.C
+    }
+
+    slog.Info("Game loop started",
+        "channel", r.channel,
+        "tick_rate", tickRate,
+        "timestamp", time.Now().Format(time.RFC3339))
+
+    last := time.Now()
+    for {
+        // A panic anywhere in an iteration resets the room instead of
+        // taking the server down
+        var stop bool
+        r.recoverPanic(func() {
+            select {
+            case <-r.server.done:
+                stop = true
+                r.shutdown()
+            case now := <-ticker.C:
+                // Scale by the real time passed so speed doesn't depend on
+                // the tick rate or ticker jitter
+                dt := min(now.Sub(last), MaxStep)
+                last = now
+                r.tick(dt.Seconds())
+            case now := <-countTicker.C:
+                r.demoteAFK(now)
+                r.sendPlayerCount()
+                r.sendPlayers()
+                if r.removeIfIdle(now) {
+                    slog.Info("Room removed after being idle",
+                        "channel", r.channel,
+                        "idle_timeout", r.cfg.RoomIdleTimeout.String(),
+                        "timestamp", time.Now().Format(time.RFC3339))
+                    stop = true
+                    r.shutdown()
+                }
+            case <-spectatorTick:
+                r.sendSpectatorState()
+            case <-saveTicker.C:
+                r.saveState()
+            }
+        })
+        if stop {
+            return
+        }
+    }
+}
+
+// Run fn, turning a panic into a reset of this room so one bad room
+// doesn't take the server down with it. The game loop carries on with the
+// next iteration. Anything fn locks has to be unlocked by a defer, or the
+// reset would wait on it forever.
+func (r *Room) recoverPanic(fn func()) {
+    defer func() {
+        v := recover()
+        if v == nil {
+            return
+        }
+        r.server.gameLoopPanics.Add(1)
+        slog.Error("Game loop panicked, resetting room",
+            "panic", fmt.Sprint(v),
+            "stack", string(debug.Stack()),
+            "channel", r.channel,
+            "timestamp", time.Now().Format(time.RFC3339))
+
+        r.Lock()
+        r.reset()
+        msg, err := r.initialStateMessage(nil)
+        r.Unlock()
+        if err != nil {
+            slog.Error("Failed to build initial state",
+                "error", err,
+                "channel", r.channel,
+                "timestamp", time.Now().Format(time.RFC3339))
+            return
+        }
+        r.broadcast(msg)
+    }()
+    fn()
+}
+
+// Persist the final state and stop recording, called by the game loop on
+// its way out
+func (r *Room) shutdown() {
+    r.saveState()
+    if r.recorder != nil {
+        r.recorder.Close()
+    }
+    slog.Info("Game loop stopped",
+        "channel", r.channel,
+        "timestamp", time.Now().Format(time.RFC3339))
+}
+
+// Take the room out of the server once it has had no connections for the
+// idle timeout. Reports whether it was removed, the caller then stops the
+// game loop.
+func (r *Room) removeIfIdle(now time.Time) bool {
+    timeout := r.cfg.RoomIdleTimeout
+    if timeout == 0 {
+        return false
+    }
+    // Server lock first, same order as everywhere else
+    r.server.Lock()
+    defer r.server.Unlock()
+    r.Lock()
+    defer r.Unlock()
+
+    if len(r.connections) > 0 || now.Sub(r.emptySince) < timeout {
+        return false
+    }
+    r.closed = true
+    delete(r.server.rooms, r.channel)
+    return true
+}
+
+// Broadcast who controls each paddle if that changed since the last send.
+// Called from the game loop like sendPlayerCount.
+func (r *Room) sendPlayers() {
+    if !r.playersDirty.Swap(false) {
+        return
+    }
+    players := r.lockedPlayers()
+
+    msg, err := NewMessage(TypePlayers, players)
+    if err != nil {
+        slog.Error("Failed to build players",
+            "error", err,
+            "channel", r.channel,
+            "timestamp", time.Now().Format(time.RFC3339))
+        return
+    }
+    r.broadcast(msg)
+}
+
+// Broadcast the room's connection count if it changed since the last
+// send. Called from the game loop so bursts of connects and disconnects
+// collapse into one message.
+func (r *Room) sendPlayerCount() {
+    if !r.playerCountDirty.Swap(false) {
+        return
+    }
+    r.RLock()
+    count := len(r.connections)
+    r.RUnlock()
+
+    msg, err := NewMessage(TypePlayerCount, PlayerCount{Count: int64(count)})
+    if err != nil {
+        slog.Error("Failed to build player count",
+            "error", err,
+            "channel", r.channel,
+            "timestamp", time.Now().Format(time.RFC3339))
+        return
+    }
+    r.broadcast(msg)
+}
+
+// Step the simulation dt seconds under the lock. Returns a copy of the new
+// state, the paddles that moved and the messages scoring produced, or
+// false when the room is frozen. The deferred unlock keeps a panic from
+// leaving the room locked.
+func (r *Room) advance(dt float64) (state game.State, moved []game.PaddlePosition, events []Message, ok bool) {
+    r.Lock()
+    defer r.Unlock()
+    if r.tickHook != nil {
+        r.tickHook()
+    }
+
+    // Nothing can change while paused unless paddles still move
+    paused := r.gameState.Paused
+    if paused && !r.cfg.InputWhilePaused {
+        return state, nil, nil, false
+    }
+    r.applyInputs()
+    r.extrapolate(dt, time.Now())
+    r.steerAI(dt)
+    moved = r.stepPaddles(dt)
+    // A room paused with nobody moving has nothing new to save
+    changed := len(moved) > 0
+    if !paused {
+        if r.gameState.Countdown > 0 {
+            events = r.stepCountdown(dt)
+        } else {
+            events = r.stepBalls(dt)
+        }
+        changed = true
+    }
+    if changed {
+        r.stateDirty.Store(true)
+    }
+    return r.gameState.Clone(), moved, events, true
+}
+
+// Advance the simulation dt seconds and send the result to everyone. By
+// default that is a single state_update per tick, in immediate mode each
+// moving paddle and ball goes out as its own message.
+func (r *Room) tick(dt float64) {
+    cfg := r.cfg
+    state, moved, events, ok := r.advance(dt)
+    if !ok {
+        return
+    }
+    paused := state.Paused
+
+    var frames []Message
+    if cfg.ImmediateBroadcast {
+        for _, pos := range moved {
+            msg, err := NewMessage(TypePaddleUpdate, pos)
+            if err != nil {
+                slog.Error("Failed to build frame",
+                    "error", err,
+                    "channel", r.channel,
+                    "timestamp", time.Now().Format(time.RFC3339))
+                return
+            }
+            frames = append(frames, msg)
+        }
+        // The balls are frozen, don't resend them
+        if !paused {
+            for _, ball := range state.Balls {
+                msg, err := NewMessage(TypeBallUpdate, ball)
+                if err != nil {
+                    slog.Error("Failed to build frame",
+                        "error", err,
+                        "channel", r.channel,
+                        "timestamp", time.Now().Format(time.RFC3339))
+                    return
+                }
+                frames = append(frames, msg)
+            }
+        }
+    } else if !cfg.OnlyOnChange || !r.playerFilter.skip(state, time.Now(), cfg.Heartbeat) {
+        msg, err := NewMessage(TypeStateUpdate, state)
+        if err != nil {
+            slog.Error("Failed to build frame",
+                "error", err,
+                "channel", r.channel,
+                "timestamp", time.Now().Format(time.RFC3339))
+            return
+        }
+        frames = append(frames, msg)
+    }
+    // Spectators get theirs from sendSpectatorState when throttled
+    to := ClientRole("")
+    if r.spectatorsThrottled() {
+        to = RolePlayer
+    }
+    for _, msg := range frames {
+        msg.Frame = r.playerFrames.Add(1)
+        r.broadcastTo(msg, to)
+    }
+
+    for _, msg := range events {
+        r.broadcast(msg)
+    }
+}
+
+// Slide each paddle toward where its players want it, no faster than the
+// max paddle speed, so input doesn't make it teleport. Returns the paddles
+// that moved. Caller must hold the lock.
+func (r *Room) stepPaddles(dt float64) []game.PaddlePosition {
+    maxDelta := float64(r.cfg.MaxPaddleSpeed) * dt
+
+    var moved []game.PaddlePosition
+    for _, p := range []struct {
+        paddle *game.PaddlePosition
+        target float64
+    }{
+        {&r.gameState.LeftPaddle, r.gameState.LeftTarget},
+        {&r.gameState.RightPaddle, r.gameState.RightTarget},
+    } {
+        y := game.Approach(p.paddle.Y, p.target, maxDelta)
+        // Spin comes from how fast the paddle moves
+        if dt > 0 {
+            p.paddle.VY = (y - p.paddle.Y) / dt
+        }
+        if y != p.paddle.Y {
+            p.paddle.Y = y
+            moved = append(moved, *p.paddle)
+        }
+    }
+    return moved
+}
+
+// Move every ball dt seconds. A ball that gets past a paddle scores and
+// leaves play, when the last one goes a new one is served toward whoever
+// scored. Returns the messages to broadcast once the lock is released.
+// Caller must hold the lock.
+func (r *Room) stepBalls(dt float64) []Message {
+    cfg := r.cfg
+    var events []Message
+
+    physics := game.PhysicsConfig{
+        Canvas:           cfg.Canvas,
+        Left:             r.gameState.LeftPaddle,
+        Right:            r.gameState.RightPaddle,
+        WallDamping:      cfg.WallDamping,
+        Spin:             cfg.PaddleSpin,
+        SuddenDeathHits:  cfg.SuddenDeathHits,
+        SuddenDeathBoost: cfg.SuddenDeathBoost,
+        Tolerance:        cfg.CollisionTolerance,
+        RightWall:        r.practice,
+    }
+    balls := make([]game.Ball, 0, len(r.gameState.Balls))
+    // Side that scored last, decides where the next serve goes
+    var scorer string
+    for _, ball := range r.gameState.Balls {
+        ball = game.StepBall(ball, dt, physics)
+        side := ball.Scorer(cfg.Canvas)
+        if side == "" {
+            balls = append(balls, ball)
+            continue
+        }
+        scorer = side
+        r.stats.point(side, ball.Hits)
+        msgs, over := r.score(side)
+        events = append(events, msgs...)
+        // A finished match starts over with a single ball
+        if over {
+            r.stats.matchStart = time.Now()
+            balls = balls[:0]
+            break
+        }
+    }
+    r.gameState.Balls = balls
+    if len(balls) == 0 {
+        side := game.RandomSide(r.rng)
+        if scorer != "" {
+            side = cfg.ServeTarget.Side(scorer)
+        }
+        r.gameState.Balls = []game.Ball{game.ServeBall(cfg.Canvas, side, cfg.BallSpeed, r.rng)}
+        events = append(events, r.startCountdown()...)
+    }
+    return events
+}
+
+// Hold the ball at the center for the configured countdown. Returns the
+// first countdown message, nothing when the countdown is off. Caller must
+// hold the lock.
+func (r *Room) startCountdown() []Message {
+    r.gameState.Countdown = r.cfg.ServeCountdown.Seconds()
+    if r.gameState.Countdown == 0 {
+        return nil
+    }
+    return r.countdownMessage()
+}
+
+// Run the countdown dt seconds down, announcing every whole second.
+// Caller must hold the lock.
+func (r *Room) stepCountdown(dt float64) []Message {
+    before := math.Ceil(r.gameState.Countdown)
+    r.gameState.Countdown = max(0, r.gameState.Countdown-dt)
+    if math.Ceil(r.gameState.Countdown) == before {
+        return nil
+    }
+    return r.countdownMessage()
+}
+
+// Caller must hold the lock.
+func (r *Room) countdownMessage() []Message {
+    msg, err := NewMessage(TypeCountdown, Countdown{Seconds: int(math.Ceil(r.gameState.Countdown))})
+    if err != nil {
+        slog.Error("Failed to build countdown",
+            "error", err,
+            "channel", r.channel,
+            "timestamp", time.Now().Format(time.RFC3339))
+        return nil
+    }
+    return []Message{msg}
+}
+
+// Put another ball in play from the center in a random direction. Returns
+// false when the room is already at the limit. Caller must hold the lock.
+func (r *Room) spawnBall() (game.Ball, bool) {
+    cfg := r.cfg
+    if len(r.gameState.Balls) >= cfg.MaxBalls {
+        return game.Ball{}, false
+    }
+
+    id := 0
+    for _, ball := range r.gameState.Balls {
+        id = max(id, ball.ID+1)
+    }
+    ball := game.NewBall(cfg.Canvas, cfg.BallSpeed, r.rng)
+    ball.ID = id
+
+    r.gameState.Balls = append(r.gameState.Balls, ball)
+    r.stateDirty.Store(true)
+    return ball, true
+}
+
+// Freeze or unfreeze the balls. Returns false if the room already was in
+// that state.
+func (r *Room) setPaused(paused bool) bool {
+    r.Lock()
+    defer r.Unlock()
+
+    if r.gameState.Paused == paused {
+        return false
+    }
+    r.gameState.Paused = paused
+    r.stateDirty.Store(true)
+    // Drop input collected before the pause
+    clear(r.inputs)
+    return true
+}
+
+// Keep the whole of pos's paddle on the canvas, for clamp bounds mode.
+// Caller must hold the lock.
+func (r *Room) clampToCanvas(pos game.PaddlePosition) game.PaddlePosition {
+    pos.Y = game.ClampY(pos.Y, r.cfg.Canvas.Height, r.paddleSize(pos.Side))
+    return pos
+}
+
+// Height of side's paddle. Caller must hold the lock.
+func (r *Room) paddleSize(side string) float64 {
+    if side == "right" {
+        return r.gameState.RightPaddle.Size()
+    }
+    return r.gameState.LeftPaddle.Size()
+}
+
+// Limit how far one update may move a paddle from where it is, so a
+// client jumping Y around can't teleport it past the ball. Reports
+// whether pos was clamped. Caller must hold the lock.
+func (r *Room) clampPaddleDelta(pos game.PaddlePosition) (game.PaddlePosition, bool) {
+    limit := float64(r.cfg.MaxPaddleDelta)
+    if limit == 0 {
+        return pos, false
+    }
+    current := r.gameState.LeftPaddle.Y
+    if pos.Side == "right" {
+        current = r.gameState.RightPaddle.Y
+    }
+    y := game.Approach(current, pos.Y, limit)
+    clamped := y != pos.Y
+    pos.Y = y
+    return pos, clamped
+}
+
+// Point a paddle at where its player wants it. In last write mode that
+// happens right away, the crowd modes collect input until the next tick.
+// Either way the paddle itself only gets there as the game loop moves it.
+// Caller must hold the lock.
+func (r *Room) movePaddle(pos game.PaddlePosition) {
+    if r.cfg.ControlMode != ControlModeLastWrite {
+        r.inputs[pos.Side] = append(r.inputs[pos.Side], pos.Y)
+        return
+    }
+    // Hints are clamped like the paddle itself, and only hold until the
+    // next update is due
+    velocity := float64(r.cfg.MaxPaddleSpeed)
+    velocity = max(-velocity, min(velocity, pos.Velocity))
+    if velocity != 0 {
+        r.hints[pos.Side] = velocityHint{velocity: velocity, until: time.Now().Add(VelocityHintTTL)}
+    } else {
+        delete(r.hints, pos.Side)
+    }
+    switch pos.Side {
+    case "left":
+        r.gameState.LeftTarget = pos.Y
+        r.gameState.LeftPaddle.Seq = pos.Seq
+        r.gameState.LeftPaddle.Velocity = velocity
+    case "right":
+        r.gameState.RightTarget = pos.Y
+        r.gameState.RightPaddle.Seq = pos.Seq
+        r.gameState.RightPaddle.Velocity = velocity
+    }
+}
+
+// How long a velocity hint keeps a paddle moving without a new update,
+// a few updates' worth for a client sending 30 a second
+const VelocityHintTTL = 100 * time.Millisecond
+
+// velocityHint is where a client said its paddle is heading
+type velocityHint struct {
+    velocity float64
+    until    time.Time
+}
+
+// Move paddle targets along their velocity hints, staying on the canvas.
+// Expired hints stop the paddle where it got to. Caller must hold the lock.
+func (r *Room) extrapolate(dt float64, now time.Time) {
+    for side, hint := range r.hints {
+        paddle, target := &r.gameState.LeftPaddle, &r.gameState.LeftTarget
+        if side == "right" {
+            paddle, target = &r.gameState.RightPaddle, &r.gameState.RightTarget
+        }
+        if now.After(hint.until) {
+            delete(r.hints, side)
+            paddle.Velocity = 0
+            continue
+        }
+        *target = game.ClampY(*target+hint.velocity*dt, r.cfg.Canvas.Height, paddle.Size())
+    }
+}
+
+// Resize side's paddle, clamped to the allowed range and the canvas.
+// Returns the size actually applied. Caller must hold the lock.
+func (r *Room) setPaddleSize(size PaddleSize) PaddleSize {
+    size.Height = max(game.MinPaddleHeight, min(game.MaxPaddleHeight, r.cfg.Canvas.Height, size.Height))
+    switch size.Side {
+    case "left":
+        r.gameState.LeftPaddle.Height = size.Height
+    case "right":
+        r.gameState.RightPaddle.Height = size.Height
+    }
+    r.stateDirty.Store(true)
+    return size
+}
+
+// Combine the input collected since the last tick into paddle targets.
+// Caller must hold the lock.
+func (r *Room) applyInputs() {
+    mode := r.cfg.ControlMode
+    if inputs := r.inputs["left"]; len(inputs) > 0 {
+        r.gameState.LeftTarget = combineInputs(mode, inputs)
+    }
+    if inputs := r.inputs["right"]; len(inputs) > 0 {
+        r.gameState.RightTarget = combineInputs(mode, inputs)
+    }
+    clear(r.inputs)
+}
+
+// Award a point to side. Returns the messages to broadcast once the lock
+// is released and whether that ended the match. Caller must hold the lock.
+func (r *Room) score(side string) ([]Message, bool) {
+    over := r.gameState.Score(side, r.cfg.WinScore)
+
+    slog.Info("Point scored",
+        "channel", r.channel,
+        "side", side,
+        "left_score", r.gameState.LeftScore,
+        "right_score", r.gameState.RightScore,
+        "timestamp", time.Now().Format(time.RFC3339))
+    r.server.events.Scored(r.channel, side, r.gameState.LeftScore, r.gameState.RightScore)
+
+    var msgs []Message
+    msg, err := NewMessage(TypeScoreUpdate, Score{
+        Left:  r.gameState.LeftScore,
+        Right: r.gameState.RightScore,
+    })
+    if err != nil {
+        slog.Error("Failed to build score update",
+            "error", err,
+            "channel", r.channel,
+            "timestamp", time.Now().Format(time.RFC3339))
+    } else {
+        msgs = append(msgs, msg)
+    }
+
+    if !over {
+        return msgs, false
+    }
+
+    slog.Info("Game over",
+        "channel", r.channel,
+        "winner", side,
+        "left_score", r.gameState.LeftScore,
+        "right_score", r.gameState.RightScore,
+        "timestamp", time.Now().Format(time.RFC3339))
+    r.server.events.GameOver(r.channel, side, r.gameState.LeftScore, r.gameState.RightScore)
+    now := time.Now()
+    r.history.Add(MatchResult{
+        Winner:          side,
+        Left:            r.gameState.LeftScore,
+        Right:           r.gameState.RightScore,
+        StartedAt:       r.stats.matchStart,
+        EndedAt:         now,
+        DurationSeconds: int64(now.Sub(r.stats.matchStart).Seconds()),
+    })
+
+    msg, err = NewMessage(TypeGameOver, GameOver{
+        Winner: side,
+        Left:   r.gameState.LeftScore,
+        Right:  r.gameState.RightScore,
+    })
+    if err != nil {
+        slog.Error("Failed to build game over",
+            "error", err,
+            "channel", r.channel,
+            "timestamp", time.Now().Format(time.RFC3339))
+    } else {
+        msgs = append(msgs, msg)
+    }
+
+    // Start a fresh match
+    r.gameState.NewMatch()
+
+    return msgs, true
+}
@@ -0,0 +1,488 @@
+package main
+
+import (
+    "encoding/json"
+    "math"
+    "runtime"
+    "sync"
+    "testing"
+    "time"
+
+    "github.com/ThePrimeagen/twitch-ext-pong/server/game"
+)
+
+// A room without a game loop, for poking at room methods directly. Its
+// hub runs so joins and broadcasts go through.
+func newTestRoom(t testing.TB, cfg Config) *Room {
+    t.Helper()
+    s := NewServer(cfg, NewMemoryStore())
+    r := NewRoom(s, "test")
+    go r.hub.run()
+    t.Cleanup(func() {
+        close(r.done)
+    })
+    return r
+}
+
+// A client with no connection behind it joined to r. Whatever the room
+// sends it piles up in its send buffer.
+func joinTestClient(t testing.TB, r *Room, role ClientRole) *Client {
+    t.Helper()
+    client := NewClient(nil, TwitchClaims{}, role, r.cfg.PaddleRate)
+    if !r.join(client) {
+        t.Fatalf("join: room closed")
+    }
+    return client
+}
+
+// Drains client's send buffer until a message of type typ turns up
+func queued(client *Client, typ MessageType) (Message, bool) {
+    for {
+        select {
+        case msg := <-client.send:
+            if msg.Type == typ {
+                return msg, true
+            }
+        default:
+            return Message{}, false
+        }
+    }
+}
+
+func TestRecoverPanicResetsRoom(t *testing.T) {
+    r := newTestRoom(t, DefaultConfig())
+    r.gameState.LeftScore = 5
+    r.gameState.RightScore = 3
+    r.tickHook = func() {
+        panic("injected")
+    }
+
+    r.recoverPanic(func() {
+        r.tick(0.01)
+    })
+
+    if got := r.server.gameLoopPanics.Load(); got != 1 {
+        t.Fatalf("gameLoopPanics = %d, want 1", got)
+    }
+    // The panic happened under the lock, it has to be free again
+    if !r.TryLock() {
+        t.Fatal("room still locked after recovering")
+    }
+    defer r.Unlock()
+    if r.gameState.LeftScore != 0 || r.gameState.RightScore != 0 {
+        t.Fatalf("score %d:%d after reset, want 0:0", r.gameState.LeftScore, r.gameState.RightScore)
+    }
+}
+
+func TestGameLoopContinuesAfterPanic(t *testing.T) {
+    ts := newTestServer(t, testConfig())
+    c := ts.dial(t, "channel=panicky")
+    c.expect(TypeInitialState)
+    room := ts.room(t, "panicky")
+
+    var once sync.Once
+    room.Lock()
+    room.gameState.LeftScore = 4
+    room.tickHook = func() {
+        once.Do(func() {
+            panic("injected")
+        })
+    }
+    room.Unlock()
+
+    // Everyone gets the reset state, then the loop keeps ticking
+    initial := decode[InitialState](t, c.expect(TypeInitialState))
+    if initial.LeftScore != 0 {
+        t.Fatalf("left score %d after the panic, want the reset 0", initial.LeftScore)
+    }
+    for i := 0; i < 3; i++ {
+        c.expect(TypeStateUpdate)
+    }
+    if got := ts.gameLoopPanics.Load(); got != 1 {
+        t.Fatalf("gameLoopPanics = %d, want 1", got)
+    }
+}
+
+func TestBallPastRightEdgeScoresLeft(t *testing.T) {
+    r := newTestRoom(t, DefaultConfig())
+    client := joinTestClient(t, r, RoleSpectator)
+    r.gameState.Countdown = 0
+    // Well clear of the right paddle
+    r.gameState.Balls = []game.Ball{{X: r.cfg.Canvas.Width + game.BallRadius, Y: game.BallRadius, VX: 300}}
+
+    r.tick(1.0 / 60)
+
+    if r.gameState.LeftScore != 1 || r.gameState.RightScore != 0 {
+        t.Fatalf("score = %d-%d, want 1-0", r.gameState.LeftScore, r.gameState.RightScore)
+    }
+    var msg Message
+    eventually(t, func() bool {
+        var ok bool
+        msg, ok = queued(client, TypeScoreUpdate)
+        return ok
+    })
+    var score Score
+    if err := json.Unmarshal(msg.Payload, &score); err != nil || score != (Score{Left: 1}) {
+        t.Fatalf("score_update = %s, want 1-0", msg.Payload)
+    }
+}
+
+func TestServeAfterPoint(t *testing.T) {
+    for _, tc := range []struct {
+        name string
+        // Where the ball leaves the canvas
+        x, vx  float64
+        target game.ServeTarget
+        // Sign of the serve's VX
+        want float64
+    }{
+        {"past left, toward loser", -50, -300, game.ServeLoser, -1},
+        {"past right, toward loser", 850, 300, game.ServeLoser, 1},
+        {"past left, toward winner", -50, -300, game.ServeWinner, 1},
+    } {
+        cfg := DefaultConfig()
+        cfg.ServeTarget = tc.target
+        r := newTestRoom(t, cfg)
+        r.gameState.Countdown = 0
+        r.gameState.Balls = []game.Ball{{X: tc.x, Y: 300, VX: tc.vx}}
+
+        state, _, _, _ := r.advance(1.0 / 60)
+        if len(state.Balls) != 1 {
+            t.Fatalf("%s: %d balls after the point, want a new serve", tc.name, len(state.Balls))
+        }
+        if vx := state.Balls[0].VX; math.Copysign(1, vx) != tc.want {
+            t.Errorf("%s: serve vx = %v, want sign %v", tc.name, vx, tc.want)
+        }
+    }
+}
+
+// Empties client's send buffer as fast as it fills until r stops, like a
+// write loop on a fast connection
+func drain(r *Room, client *Client) {
+    for {
+        select {
+        case <-client.send:
+        case <-r.done:
+            return
+        }
+    }
+}
+
+// Compare a frame per tick against a message per moving paddle and ball
+func BenchmarkTick(b *testing.B) {
+    for _, immediate := range []bool{false, true} {
+        name := "frame"
+        if immediate {
+            name = "immediate"
+        }
+        b.Run(name, func(b *testing.B) {
+            cfg := DefaultConfig()
+            cfg.ImmediateBroadcast = immediate
+            r := newTestRoom(b, cfg)
+            r.gameState.Countdown = 0
+            r.gameState.LeftTarget = 0
+            r.gameState.RightTarget = cfg.Canvas.Height
+            for i := 0; i < 100; i++ {
+                go drain(r, joinTestClient(b, r, RoleSpectator))
+            }
+            b.ReportAllocs()
+            b.ResetTimer()
+            for i := 0; i < b.N; i++ {
+                r.tick(1.0 / float64(cfg.TickRate))
+                // Count the fan out too, and don't outrun the drains
+                for len(r.hub.broadcast) > 0 {
+                    runtime.Gosched()
+                }
+            }
+        })
+    }
+}
+
+// How far the loop moves a ball heading right at 300 px/s in d of wall
+// clock time, and how long that really took
+func ballTravel(t *testing.T, tickRate int, d time.Duration) (float64, time.Duration) {
+    t.Helper()
+    cfg := testConfig()
+    cfg.TickRate = tickRate
+    ts := newTestServer(t, cfg)
+    room := ts.Server.room("rate")
+
+    room.Lock()
+    room.gameState.Countdown = 0
+    room.gameState.Balls = []game.Ball{{X: 100, Y: 300, VX: 300}}
+    start := time.Now()
+    room.Unlock()
+    time.Sleep(d)
+    room.Lock()
+    defer room.Unlock()
+    return room.gameState.Balls[0].X - 100, time.Since(start)
+}
+
+func TestTickRateScalesByElapsedTime(t *testing.T) {
+    const d = 300 * time.Millisecond
+    slow, slowElapsed := ballTravel(t, 20, d)
+    fast, fastElapsed := ballTravel(t, 120, d)
+
+    // The ball can only lag by the tick it hasn't had yet
+    for _, tc := range []struct {
+        rate    int
+        travel  float64
+        elapsed time.Duration
+    }{
+        {20, slow, slowElapsed},
+        {120, fast, fastElapsed},
+    } {
+        hi := 300 * tc.elapsed.Seconds()
+        lo := hi - 300*2/float64(tc.rate)
+        if tc.travel < lo || tc.travel > hi {
+            t.Errorf("at %d Hz the ball moved %v px in %s, want %v to %v", tc.rate, tc.travel, tc.elapsed, lo, hi)
+        }
+    }
+    if diff := math.Abs(slow - fast); diff > 0.2*fast {
+        t.Errorf("moved %v px at 20 Hz and %v px at 120 Hz, want them close", slow, fast)
+    }
+}
+
+func TestTwoBallsStepIndependently(t *testing.T) {
+    r := newTestRoom(t, DefaultConfig())
+    r.gameState.Countdown = 0
+    if _, ok := r.spawnBall(); !ok {
+        t.Fatalf("spawnBall refused a second ball")
+    }
+    if ids := []int{r.gameState.Balls[0].ID, r.gameState.Balls[1].ID}; ids[0] == ids[1] {
+        t.Fatalf("both balls have id %d", ids[0])
+    }
+
+    left := r.gameState.LeftPaddle
+    // One about to hit the left paddle, one about to leave on the right
+    r.gameState.Balls[0].X, r.gameState.Balls[0].Y = 52, left.Y+left.Size()/2
+    r.gameState.Balls[0].VX, r.gameState.Balls[0].VY = -300, 0
+    r.gameState.Balls[1].X, r.gameState.Balls[1].Y = r.cfg.Canvas.Width+game.BallRadius, game.BallRadius
+    r.gameState.Balls[1].VX = 300
+    bouncing := r.gameState.Balls[0].ID
+
+    r.tick(1.0 / 60)
+
+    if r.gameState.LeftScore != 1 {
+        t.Fatalf("left score = %d, want 1", r.gameState.LeftScore)
+    }
+    if len(r.gameState.Balls) != 1 {
+        t.Fatalf("%d balls in play, want the one that bounced", len(r.gameState.Balls))
+    }
+    if ball := r.gameState.Balls[0]; ball.ID != bouncing || ball.VX <= 0 {
+        t.Fatalf("remaining ball %+v, want ball %d heading right", ball, bouncing)
+    }
+}
+
+func TestSpawnBallCapped(t *testing.T) {
+    r := newTestRoom(t, DefaultConfig())
+    for len(r.gameState.Balls) < r.cfg.MaxBalls {
+        if _, ok := r.spawnBall(); !ok {
+            t.Fatalf("spawnBall refused ball %d of %d", len(r.gameState.Balls)+1, r.cfg.MaxBalls)
+        }
+    }
+    if _, ok := r.spawnBall(); ok {
+        t.Fatalf("spawnBall went past MaxBalls %d", r.cfg.MaxBalls)
+    }
+}
+
+func TestPaddleEasesToFarTarget(t *testing.T) {
+    r := newTestRoom(t, DefaultConfig())
+    r.gameState.LeftTarget = 0
+    dt := 1.0 / float64(r.cfg.TickRate)
+
+    ticks := 0
+    prev := r.gameState.LeftPaddle.Y
+    for r.gameState.LeftPaddle.Y != 0 {
+        r.advance(dt)
+        ticks++
+        y := r.gameState.LeftPaddle.Y
+        if y > prev {
+            t.Fatalf("tick %d: paddle moved away from the target to %v", ticks, y)
+        }
+        if step := prev - y; step > float64(r.cfg.MaxPaddleSpeed)*dt+1e-9 {
+            t.Fatalf("tick %d: paddle moved %v px, faster than %d px/s", ticks, step, r.cfg.MaxPaddleSpeed)
+        }
+        prev = y
+        if ticks > r.cfg.TickRate {
+            t.Fatalf("paddle still at %v after a second", y)
+        }
+    }
+    if ticks < 2 {
+        t.Fatalf("paddle got to the target in %d tick, want several", ticks)
+    }
+}
+
+func TestBallHeldDuringCountdown(t *testing.T) {
+    cfg := DefaultConfig()
+    cfg.ServeCountdown = time.Second
+    r := newTestRoom(t, cfg)
+
+    // A point makes the next serve wait for the countdown
+    r.gameState.Balls = []game.Ball{{X: float64(cfg.Canvas.Width) + 50, Y: 300, VX: 300}}
+    state, _, events, _ := r.advance(0.01)
+    if len(events) == 0 || events[len(events)-1].Type != TypeCountdown {
+        t.Fatalf("serve after a point sent %v, want a countdown", events)
+    }
+    start := state.Balls[0]
+
+    var counts []int
+    for i := 0; i < 4; i++ {
+        state, _, events, _ = r.advance(0.25)
+        if state.Balls[0].X != start.X || state.Balls[0].Y != start.Y {
+            t.Fatalf("ball moved to %v,%v after %v s of a 1 s countdown", state.Balls[0].X, state.Balls[0].Y, 0.25*float64(i+1))
+        }
+        for _, msg := range events {
+            if msg.Type == TypeCountdown {
+                var c Countdown
+                if err := json.Unmarshal(msg.Payload, &c); err != nil {
+                    t.Fatalf("countdown %s: %v", msg.Payload, err)
+                }
+                counts = append(counts, c.Seconds)
+            }
+        }
+    }
+    if len(counts) != 1 || counts[0] != 0 {
+        t.Fatalf("countdown messages = %v, want [0] once it runs out", counts)
+    }
+
+    state, _, _, _ = r.advance(0.25)
+    if state.Countdown != 0 || state.Balls[0].X == start.X {
+        t.Fatalf("ball still at %v with %v s left after the countdown", state.Balls[0].X, state.Countdown)
+    }
+}
+
+func TestIdleRoomRemoved(t *testing.T) {
+    cfg := testConfig()
+    cfg.RoomIdleTimeout = 100 * time.Millisecond
+    ts := newTestServer(t, cfg)
+    c := ts.dial(t, "channel=idle")
+    c.expect(TypeInitialState)
+    room := ts.room(t, "idle")
+
+    c.conn.Close()
+    eventually(t, func() bool {
+        room.RLock()
+        defer room.RUnlock()
+        return len(room.connections) == 0
+    })
+    // The game loop and the hub are all that's left of the room
+    before := runtime.NumGoroutine()
+
+    eventually(t, func() bool {
+        ts.RLock()
+        defer ts.RUnlock()
+        return ts.rooms["idle"] == nil
+    })
+    select {
+    case <-room.done:
+    case <-time.After(testTimeout):
+        t.Fatalf("game loop still running after the room was removed")
+    }
+    eventually(t, func() bool {
+        return runtime.NumGoroutine() <= before-2
+    })
+}
+
+func TestPaddlesStartCenteredOnCustomCanvas(t *testing.T) {
+    cfg := DefaultConfig()
+    cfg.Canvas = game.Canvas{Width: 800, Height: 400}
+    cfg.PaddleHeight = 80
+    r := newTestRoom(t, cfg)
+
+    check := func(when string) {
+        t.Helper()
+        for _, p := range []game.PaddlePosition{r.gameState.LeftPaddle, r.gameState.RightPaddle} {
+            if p.Y != 160 || p.Height != 80 {
+                t.Fatalf("%s: %s paddle at %v, %v high, want 160 and 80", when, p.Side, p.Y, p.Height)
+            }
+        }
+        if r.gameState.LeftTarget != 160 || r.gameState.RightTarget != 160 {
+            t.Fatalf("%s: targets %v and %v, want 160", when, r.gameState.LeftTarget, r.gameState.RightTarget)
+        }
+    }
+    check("start")
+
+    r.gameState.LeftPaddle.Y = 0
+    r.gameState.RightTarget = 320
+    r.reset()
+    check("reset")
+}
+
+// State updates c gets over d
+func countStates(c *testClient, d time.Duration) int {
+    n := 0
+    deadline := time.Now().Add(d)
+    for {
+        msg, err := c.tryReceive(time.Until(deadline))
+        if err != nil {
+            return n
+        }
+        if msg.Type == TypeStateUpdate {
+            n++
+        }
+    }
+}
+
+func TestSpectatorsGetFewerStates(t *testing.T) {
+    cfg := testConfig()
+    cfg.TickRate = 60
+    cfg.SpectatorRate = 10
+    ts := newTestServer(t, cfg)
+    player := dialPlayer(t, ts, "rates", "left")
+    spectator := ts.dial(t, "channel=rates&role=spectator")
+    spectator.expect(TypeInitialState)
+
+    const d = 500 * time.Millisecond
+    var spectated int
+    done := make(chan struct{})
+    go func() {
+        defer close(done)
+        spectated = countStates(spectator, d)
+    }()
+    played := countStates(player, d)
+    <-done
+
+    // A tick or two more for ticker jitter
+    most := int(float64(cfg.SpectatorRate)*d.Seconds()) + 2
+    if spectated >= played || spectated > most {
+        t.Fatalf("player got %d states and spectator %d in %s, want the spectator near %d a second", played, spectated, d, cfg.SpectatorRate)
+    }
+}
+
+// frames reads n state_updates from c and returns their frame numbers
+func frames(c *testClient, n int) []uint64 {
+    c.t.Helper()
+    var seen []uint64
+    for len(seen) < n {
+        seen = append(seen, c.expect(TypeStateUpdate).Frame)
+    }
+    return seen
+}
+
+func TestFramesHaveNoGaps(t *testing.T) {
+    cfg := testConfig()
+    cfg.TickRate = 60
+    cfg.SpectatorRate = 10
+    ts := newTestServer(t, cfg)
+    player := dialPlayer(t, ts, "frames", "left")
+    spectator := ts.dial(t, "channel=frames&role=spectator")
+    spectator.expect(TypeInitialState)
+
+    // Spectators count on their own ticker, so each stream is checked
+    // against itself
+    for _, tc := range []struct {
+        name   string
+        client *testClient
+    }{
+        {"player", player},
+        {"spectator", spectator},
+    } {
+        seen := frames(tc.client, 5)
+        for i := 1; i < len(seen); i++ {
+            if seen[i] != seen[i-1]+1 {
+                t.Fatalf("%s frames = %v, want each one more than the last", tc.name, seen)
+            }
+        }
+    }
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+    "encoding/json"
+    "errors"
+    "fmt"
+    "io/fs"
+    "os"
+    "sync"
+    "time"
+
+    "github.com/ThePrimeagen/twitch-ext-pong/server/game"
+    "golang.org/x/exp/slog"
+)
+
+// Ruleset overrides the global rules for one channel, anything left out
+// keeps the global setting
+type Ruleset struct {
+    WinScore     *int         `json:"winScore,omitempty"`
+    BallSpeed    *float64     `json:"ballSpeed,omitempty"`
+    PaddleHeight *float64     `json:"paddleHeight,omitempty"`
+    ControlMode  *ControlMode `json:"controlMode,omitempty"`
+}
+
+// Validate applies the same limits as the matching env vars
+func (r Ruleset) Validate() error {
+    if r.WinScore != nil && *r.WinScore <= 0 {
+        return fmt.Errorf("invalid winScore %d: must be positive", *r.WinScore)
+    }
+    if r.BallSpeed != nil && (*r.BallSpeed < game.MinBallSpeed || *r.BallSpeed > game.MaxBallSpeed) {
+        return fmt.Errorf("invalid ballSpeed %v: must be between %v and %v", *r.BallSpeed, game.MinBallSpeed, game.MaxBallSpeed)
+    }
+    if r.PaddleHeight != nil && (*r.PaddleHeight < game.MinPaddleHeight || *r.PaddleHeight > game.MaxPaddleHeight) {
+        return fmt.Errorf("invalid paddleHeight %v: must be between %v and %v", *r.PaddleHeight, game.MinPaddleHeight, game.MaxPaddleHeight)
+    }
+    if r.ControlMode != nil {
+        // The env var treats empty as the default, here it's a mistake
+        if *r.ControlMode == "" {
+            return errors.New("invalid controlMode: must not be empty")
+        }
+        if _, err := parseControlMode(string(*r.ControlMode)); err != nil {
+            return err
+        }
+    }
+    return nil
+}
+
+// Apply returns cfg with the overrides in place
+func (r Ruleset) Apply(cfg Config) Config {
+    if r.WinScore != nil {
+        cfg.WinScore = *r.WinScore
+    }
+    if r.BallSpeed != nil {
+        cfg.BallSpeed = *r.BallSpeed
+    }
+    if r.PaddleHeight != nil {
+        cfg.PaddleHeight = *r.PaddleHeight
+    }
+    if r.ControlMode != nil {
+        cfg.ControlMode = *r.ControlMode
+    }
+    return cfg
+}
+
+// Rulesets holds the per channel rules from the rooms file, a JSON object
+// of channel id to Ruleset. Rooms pick theirs up when they are created, so
+// a reload only affects rooms created afterwards.
+type Rulesets struct {
+    path string
+
+    mu    sync.RWMutex
+    rules map[string]Ruleset
+}
+
+func NewRulesets(path string) *Rulesets {
+    return &Rulesets{path: path, rules: make(map[string]Ruleset)}
+}
+
+// Load reads the rooms file, replacing whatever was loaded before. A
+// missing file means no overrides. Invalid overrides are logged and left
+// out, a file that isn't valid JSON keeps the previous rules.
+func (r *Rulesets) Load() error {
+    if r.path == "" {
+        return nil
+    }
+    data, err := os.ReadFile(r.path)
+    if errors.Is(err, fs.ErrNotExist) {
+        data = []byte("{}")
+    } else if err != nil {
+        return err
+    }
+
+    var raw map[string]Ruleset
+    if err := json.Unmarshal(data, &raw); err != nil {
+        return fmt.Errorf("%s: %w", r.path, err)
+    }
+    rules := make(map[string]Ruleset, len(raw))
+    for channel, ruleset := range raw {
+        if !channelPattern.MatchString(channel) {
+            slog.Warn("Rejected ruleset",
+                "channel", channel,
+                "error", "invalid channel id",
+                "timestamp", time.Now().Format(time.RFC3339))
+            continue
+        }
+        if err := ruleset.Validate(); err != nil {
+            slog.Warn("Rejected ruleset",
+                "channel", channel,
+                "error", err,
+                "timestamp", time.Now().Format(time.RFC3339))
+            continue
+        }
+        rules[channel] = ruleset
+    }
+
+    r.mu.Lock()
+    r.rules = rules
+    r.mu.Unlock()
+    slog.Info("Loaded rulesets",
+        "path", r.path,
+        "channels", len(rules),
+        "timestamp", time.Now().Format(time.RFC3339))
+    return nil
+}
+
+// Config returns cfg with channel's overrides applied
+func (r *Rulesets) Config(channel string, cfg Config) Config {
+    r.mu.RLock()
+    ruleset, ok := r.rules[channel]
+    r.mu.RUnlock()
+    if !ok {
+        return cfg
+    }
+    return ruleset.Apply(cfg)
+}
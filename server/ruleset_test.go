@@ -0,0 +1,72 @@
+package main
+
+import (
+    "os"
+    "path/filepath"
+    "testing"
+
+    "github.com/ThePrimeagen/twitch-ext-pong/server/game"
+)
+
+func TestRoomUsesRulesetOverride(t *testing.T) {
+    path := filepath.Join(t.TempDir(), "rooms.json")
+    rooms := `{
+        "custom": {"winScore": 3, "paddleHeight": 140, "controlMode": "average"},
+        "broken": {"winScore": -1}
+    }`
+    if err := os.WriteFile(path, []byte(rooms), 0o644); err != nil {
+        t.Fatalf("write rooms file: %v", err)
+    }
+    cfg := testConfig()
+    cfg.RoomsFile = path
+    ts := newTestServer(t, cfg)
+    if err := ts.rulesets.Load(); err != nil {
+        t.Fatalf("Load: %v", err)
+    }
+
+    for _, tc := range []struct {
+        channel      string
+        winScore     int
+        paddleHeight float64
+        controlMode  ControlMode
+    }{
+        {"custom", 3, 140, ControlModeAverage},
+        // Invalid overrides are dropped, not half applied
+        {"broken", cfg.WinScore, cfg.PaddleHeight, cfg.ControlMode},
+        {"plain", cfg.WinScore, cfg.PaddleHeight, cfg.ControlMode},
+    } {
+        c := ts.dial(t, "channel="+tc.channel)
+        got := decode[ClientConfig](t, c.expect(TypeConfig))
+        if got.WinScore != tc.winScore || got.PaddleHeight != tc.paddleHeight {
+            t.Errorf("%s: config says win at %d with %v paddles, want %d and %v", tc.channel, got.WinScore, got.PaddleHeight, tc.winScore, tc.paddleHeight)
+        }
+        state := decode[InitialState](t, c.expect(TypeInitialState))
+        if state.LeftPaddle.Height != tc.paddleHeight {
+            t.Errorf("%s: left paddle %v high, want %v", tc.channel, state.LeftPaddle.Height, tc.paddleHeight)
+        }
+        if mode := ts.room(t, tc.channel).cfg.ControlMode; mode != tc.controlMode {
+            t.Errorf("%s: control mode %q, want %q", tc.channel, mode, tc.controlMode)
+        }
+    }
+}
+
+func TestPaddleUpdateUsesRoomConfig(t *testing.T) {
+    cfg := testConfig()
+    cfg.BoundsMode = BoundsReject
+    ts := newTestServer(t, cfg)
+    // A room whose rules differ from the server's, like a ruleset would
+    // make it
+    roomCfg := cfg
+    roomCfg.BoundsMode = BoundsClamp
+    roomCfg.MaxPaddleDelta = int(cfg.Canvas.Height)
+    ts.Lock()
+    ts.startRoomLocked(newRoom(ts.Server, "custom", roomCfg))
+    ts.Unlock()
+    c := dialPlayer(t, ts, "custom", "left")
+
+    c.send(TypePaddleUpdate, game.PaddlePosition{Side: "left", Y: cfg.Canvas.Height + 50})
+    bottom := cfg.Canvas.Height - cfg.PaddleHeight
+    c.expectState(func(s game.State) bool {
+        return s.LeftTarget == bottom
+    })
+}
@@ -12,4 +12,4 @@ docker build -t twitch-pong-server .
 
 # Run container
 echo "🦍 RUNNING STRONK SERVER ON PORT 42069 🦍"
-docker run --name twitch-pong-server -p 42069:42069 twitch-pong-server
+docker run --name twitch-pong-server -p 42069:42069 -e ALLOWED_ORIGINS=localhost twitch-pong-server
@@ -0,0 +1,179 @@
+package main
+
+import (
+    "compress/gzip"
+    "crypto/sha256"
+    "encoding/hex"
+    "fmt"
+    "io"
+    "net/http"
+    "path"
+    "strings"
+    "sync"
+    "time"
+)
+
+// Content types worth compressing, images and fonts already are
+var compressibleTypes = []string{
+    "text/",
+    "application/javascript",
+    "application/json",
+    "application/xml",
+    "image/svg+xml",
+}
+
+func compressible(contentType string) bool {
+    for _, prefix := range compressibleTypes {
+        if strings.HasPrefix(contentType, prefix) {
+            return true
+        }
+    }
+    return false
+}
+
+// gzipResponseWriter compresses the body once the headers show it is
+// worth it. The decision waits for WriteHeader since the file server only
+// sets the content type right before writing.
+type gzipResponseWriter struct {
+    http.ResponseWriter
+    gz      *gzip.Writer
+    decided bool
+}
+
+func (w *gzipResponseWriter) WriteHeader(code int) {
+    if !w.decided {
+        w.decided = true
+        h := w.Header()
+        if code == http.StatusOK && h.Get("Content-Encoding") == "" && compressible(h.Get("Content-Type")) {
+            h.Del("Content-Length")
+            h.Set("Content-Encoding", "gzip")
+            // The compressed bytes differ, so the tag can only promise the
+            // same content. If-None-Match compares weakly, revalidation
+            // still gets a 304.
+            if etag := h.Get("ETag"); etag != "" && !strings.HasPrefix(etag, "W/") {
+                h.Set("ETag", "W/"+etag)
+            }
+            w.gz = gzip.NewWriter(w.ResponseWriter)
+        }
+    }
+    w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *gzipResponseWriter) Write(b []byte) (int, error) {
+    if !w.decided {
+        w.WriteHeader(http.StatusOK)
+    }
+    if w.gz != nil {
+        return w.gz.Write(b)
+    }
+    return w.ResponseWriter.Write(b)
+}
+
+// Flush out whatever the gzip writer still buffers
+func (w *gzipResponseWriter) Close() error {
+    if w.gz == nil {
+        return nil
+    }
+    return w.gz.Close()
+}
+
+// gzipMiddleware compresses text responses for clients that accept gzip.
+// Range requests are passed through untouched, byte offsets into a
+// compressed body mean nothing to the client.
+func gzipMiddleware(next http.Handler) http.Handler {
+    return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        w.Header().Add("Vary", "Accept-Encoding")
+        if !strings.Contains(r.Header.Get("Accept-Encoding"), "gzip") || r.Header.Get("Range") != "" {
+            next.ServeHTTP(w, r)
+            return
+        }
+        gw := &gzipResponseWriter{ResponseWriter: w}
+        defer gw.Close()
+        next.ServeHTTP(gw, r)
+    })
+}
+
+// etagEntry is a file's content hash, valid while the file looks unchanged
+type etagEntry struct {
+    modTime time.Time
+    size    int64
+    etag    string
+}
+
+// staticCache tags static files with a hash of their content so browsers
+// can revalidate instead of downloading again. Hashes are kept until the
+// file's size or modification time changes.
+type staticCache struct {
+    dir    http.Dir
+    maxAge time.Duration
+
+    mu    sync.Mutex
+    etags map[string]etagEntry
+}
+
+func newStaticCache(dir string, maxAge time.Duration) *staticCache {
+    return &staticCache{
+        dir:    http.Dir(dir),
+        maxAge: maxAge,
+        etags:  make(map[string]etagEntry),
+    }
+}
+
+// etag returns the strong tag for the file the request resolves to, the
+// same way the file server resolves it, or "" when there is none
+func (c *staticCache) etag(name string) string {
+    name = path.Clean("/" + name)
+    f, err := c.dir.Open(name)
+    if err != nil {
+        return ""
+    }
+    defer f.Close()
+    info, err := f.Stat()
+    if err != nil {
+        return ""
+    }
+    if info.IsDir() {
+        name = path.Join(name, "index.html")
+        if f, err = c.dir.Open(name); err != nil {
+            return ""
+        }
+        defer f.Close()
+        if info, err = f.Stat(); err != nil || info.IsDir() {
+            return ""
+        }
+    }
+
+    c.mu.Lock()
+    entry, ok := c.etags[name]
+    c.mu.Unlock()
+    if ok && entry.size == info.Size() && entry.modTime.Equal(info.ModTime()) {
+        return entry.etag
+    }
+
+    hash := sha256.New()
+    if _, err := io.Copy(hash, f); err != nil {
+        return ""
+    }
+    etag := `"` + hex.EncodeToString(hash.Sum(nil)[:16]) + `"`
+
+    c.mu.Lock()
+    c.etags[name] = etagEntry{modTime: info.ModTime(), size: info.Size(), etag: etag}
+    c.mu.Unlock()
+    return etag
+}
+
+// middleware sets ETag and Cache-Control before handing the request to the
+// file server, which answers a matching If-None-Match with a 304 by itself
+func (c *staticCache) middleware(next http.Handler) http.Handler {
+    cacheControl := "no-cache"
+    if c.maxAge > 0 {
+        cacheControl = fmt.Sprintf("public, max-age=%d", int(c.maxAge.Seconds()))
+    }
+    return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        if etag := c.etag(r.URL.Path); etag != "" {
+            w.Header().Set("ETag", etag)
+            w.Header().Set("Cache-Control", cacheControl)
+        }
+        next.ServeHTTP(w, r)
+    })
+}
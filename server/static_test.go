@@ -0,0 +1,126 @@
+package main
+
+import (
+    "compress/gzip"
+    "io"
+    "net/http"
+    "os"
+    "path/filepath"
+    "slices"
+    "strings"
+    "testing"
+)
+
+// Script served from the static dir, long enough that gzip pays off
+var testScript = strings.Repeat("console.log('pong');\n", 100)
+
+// A server with a static dir holding a script and an image
+func newStaticServer(t *testing.T, gzip bool) *testServer {
+    t.Helper()
+    dir := t.TempDir()
+    for name, content := range map[string]string{
+        "app.js":   testScript,
+        "logo.png": "\x89PNG\r\n\x1a\n",
+    } {
+        if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0o644); err != nil {
+            t.Fatalf("write %s: %v", name, err)
+        }
+    }
+    cfg := testConfig()
+    cfg.StaticDir = dir
+    cfg.StaticGzip = gzip
+    return newTestServer(t, cfg)
+}
+
+// fetch GETs path with header, without the transport asking for or
+// undoing gzip on its own
+func (ts *testServer) fetch(t *testing.T, path string, header http.Header) *http.Response {
+    t.Helper()
+    req, err := http.NewRequest(http.MethodGet, ts.http.URL+path, nil)
+    if err != nil {
+        t.Fatalf("request %s: %v", path, err)
+    }
+    for k, v := range header {
+        req.Header[k] = v
+    }
+    client := &http.Client{Transport: &http.Transport{DisableCompression: true}}
+    resp, err := client.Do(req)
+    if err != nil {
+        t.Fatalf("GET %s: %v", path, err)
+    }
+    t.Cleanup(func() {
+        resp.Body.Close()
+    })
+    return resp
+}
+
+func TestStaticGzip(t *testing.T) {
+    ts := newStaticServer(t, true)
+    acceptGzip := http.Header{"Accept-Encoding": {"gzip"}}
+
+    for _, tc := range []struct {
+        name     string
+        path     string
+        header   http.Header
+        encoding string
+    }{
+        {"script, gzip accepted", "/app.js", acceptGzip, "gzip"},
+        {"script, no gzip", "/app.js", nil, ""},
+        {"image, gzip accepted", "/logo.png", acceptGzip, ""},
+    } {
+        resp := ts.fetch(t, tc.path, tc.header)
+        if resp.StatusCode != http.StatusOK {
+            t.Fatalf("%s: status %d", tc.name, resp.StatusCode)
+        }
+        if got := resp.Header.Get("Content-Encoding"); got != tc.encoding {
+            t.Errorf("%s: Content-Encoding %q, want %q", tc.name, got, tc.encoding)
+        }
+        if vary := resp.Header.Values("Vary"); !slices.Contains(vary, "Accept-Encoding") {
+            t.Errorf("%s: Vary = %q, want Accept-Encoding", tc.name, vary)
+        }
+        if tc.path != "/app.js" {
+            continue
+        }
+        var body io.Reader = resp.Body
+        if tc.encoding == "gzip" {
+            gz, err := gzip.NewReader(resp.Body)
+            if err != nil {
+                t.Fatalf("%s: %v", tc.name, err)
+            }
+            body = gz
+        }
+        if data, err := io.ReadAll(body); err != nil || string(data) != testScript {
+            t.Errorf("%s: body doesn't match the file (%v)", tc.name, err)
+        }
+    }
+}
+
+func TestStaticGzipOff(t *testing.T) {
+    ts := newStaticServer(t, false)
+    resp := ts.fetch(t, "/app.js", http.Header{"Accept-Encoding": {"gzip"}})
+    if got := resp.Header.Get("Content-Encoding"); got != "" {
+        t.Fatalf("Content-Encoding %q with STATIC_GZIP off", got)
+    }
+}
+
+func TestStaticETagRevalidates(t *testing.T) {
+    for _, gzip := range []bool{false, true} {
+        ts := newStaticServer(t, gzip)
+        header := http.Header{"Accept-Encoding": {"gzip"}}
+
+        first := ts.fetch(t, "/app.js", header)
+        etag := first.Header.Get("ETag")
+        if first.StatusCode != http.StatusOK || etag == "" {
+            t.Fatalf("gzip %v: status %d with ETag %q, want 200 and a tag", gzip, first.StatusCode, etag)
+        }
+
+        header.Set("If-None-Match", etag)
+        if resp := ts.fetch(t, "/app.js", header); resp.StatusCode != http.StatusNotModified {
+            t.Fatalf("gzip %v: status %d for ETag %s, want %d", gzip, resp.StatusCode, etag, http.StatusNotModified)
+        }
+        header.Set("If-None-Match", `"stale"`)
+        if resp := ts.fetch(t, "/app.js", header); resp.StatusCode != http.StatusOK {
+            t.Fatalf("gzip %v: status %d for a stale ETag, want %d", gzip, resp.StatusCode, http.StatusOK)
+        }
+    }
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+    "encoding/json"
+    "net/http"
+    "time"
+)
+
+// Stats are a room's running match statistics since its last reset
+type Stats struct {
+    // Points played
+    Rallies int `json:"rallies"`
+    // Most paddle hits in a single point
+    LongestRally int `json:"longest_rally"`
+    LeftGoals    int `json:"left_goals"`
+    RightGoals   int `json:"right_goals"`
+    // Start of the current match
+    matchStart time.Time
+}
+
+// StatsResponse is the body returned by /stats
+type StatsResponse struct {
+    Channel string `json:"channel"`
+    Stats
+    MatchSeconds int64 `json:"match_seconds"`
+}
+
+func newStats() Stats {
+    return Stats{matchStart: time.Now()}
+}
+
+// Count a point won by side after hits paddle hits
+func (st *Stats) point(side string, hits int) {
+    st.Rallies++
+    st.LongestRally = max(st.LongestRally, hits)
+    if side == "left" {
+        st.LeftGoals++
+    } else {
+        st.RightGoals++
+    }
+}
+
+// handleStats serves GET /stats?channel=, 404 for rooms nobody has played in
+func (s *Server) handleStats(w http.ResponseWriter, r *http.Request) {
+    if r.Method != http.MethodGet {
+        http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+        return
+    }
+
+    channel := r.URL.Query().Get("channel")
+    if channel == "" {
+        channel = DefaultChannel
+    }
+    if !channelPattern.MatchString(channel) {
+        http.Error(w, "invalid channel", http.StatusBadRequest)
+        return
+    }
+
+    // Don't create rooms just to report nothing happened in them
+    s.RLock()
+    room, ok := s.rooms[channel]
+    s.RUnlock()
+    if !ok {
+        http.Error(w, "room not found", http.StatusNotFound)
+        return
+    }
+
+    room.RLock()
+    resp := StatsResponse{
+        Channel:      channel,
+        Stats:        room.stats,
+        MatchSeconds: int64(time.Since(room.stats.matchStart).Seconds()),
+    }
+    room.RUnlock()
+
+    w.Header().Set("Content-Type", "application/json")
+    json.NewEncoder(w).Encode(resp)
+}
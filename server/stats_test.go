@@ -0,0 +1,43 @@
+package main
+
+import (
+    "encoding/json"
+    "net/http"
+    "testing"
+
+    "github.com/ThePrimeagen/twitch-ext-pong/server/game"
+)
+
+func TestStatsAfterScores(t *testing.T) {
+    ts := newTestServer(t, testConfig())
+    room := ts.Server.room("stats")
+
+    // Left scores after a 3 hit rally, right after a 7 hit one
+    room.Lock()
+    for _, ball := range []game.Ball{
+        {X: ts.cfg.Canvas.Width + 2*game.BallRadius, Y: game.BallRadius, VX: 300, Hits: 3},
+        {X: -2 * game.BallRadius, Y: game.BallRadius, VX: -300, Hits: 7},
+    } {
+        room.gameState.Countdown = 0
+        room.gameState.Balls = []game.Ball{ball}
+        room.stepBalls(0)
+    }
+    room.Unlock()
+
+    resp := ts.get(t, "/stats?channel=stats")
+    if resp.StatusCode != http.StatusOK {
+        t.Fatalf("status = %d, want %d", resp.StatusCode, http.StatusOK)
+    }
+    var got StatsResponse
+    if err := json.NewDecoder(resp.Body).Decode(&got); err != nil {
+        t.Fatalf("decode: %v", err)
+    }
+    want := Stats{Rallies: 2, LongestRally: 7, LeftGoals: 1, RightGoals: 1}
+    if got.Channel != "stats" || got.Stats != want {
+        t.Fatalf("stats = %+v for %q, want %+v for stats", got.Stats, got.Channel, want)
+    }
+
+    if resp := ts.get(t, "/stats?channel=nobody"); resp.StatusCode != http.StatusNotFound {
+        t.Fatalf("unknown room status = %d, want %d", resp.StatusCode, http.StatusNotFound)
+    }
+}
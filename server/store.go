@@ -0,0 +1,94 @@
+package main
+
+import (
+    "encoding/json"
+    "errors"
+    "os"
+    "path/filepath"
+    "sync"
+
+    "github.com/ThePrimeagen/twitch-ext-pong/server/game"
+)
+
+// ErrStateNotFound is returned by StateStore.Load for rooms it has never seen
+var ErrStateNotFound = errors.New("state not found")
+
+// StateStore persists each room's game state so it survives restarts and
+// can later be shared between processes (e.g. a Redis implementation)
+type StateStore interface {
+    Load(room string) (game.State, error)
+    Save(room string, state game.State) error
+}
+
+// MemoryStore keeps state in process, it is the default
+type MemoryStore struct {
+    mu     sync.Mutex
+    states map[string]game.State
+}
+
+func NewMemoryStore() *MemoryStore {
+    return &MemoryStore{states: make(map[string]game.State)}
+}
+
+func (m *MemoryStore) Load(room string) (game.State, error) {
+    m.mu.Lock()
+    defer m.mu.Unlock()
+
+    state, ok := m.states[room]
+    if !ok {
+        return game.State{}, ErrStateNotFound
+    }
+    return state, nil
+}
+
+func (m *MemoryStore) Save(room string, state game.State) error {
+    m.mu.Lock()
+    defer m.mu.Unlock()
+
+    m.states[room] = state
+    return nil
+}
+
+// FileStore keeps one JSON file per room in a directory. Room names are
+// channel ids which are already restricted to safe characters.
+type FileStore struct {
+    dir string
+}
+
+func NewFileStore(dir string) (*FileStore, error) {
+    if err := os.MkdirAll(dir, 0o755); err != nil {
+        return nil, err
+    }
+    return &FileStore{dir: dir}, nil
+}
+
+func (f *FileStore) path(room string) string {
+    return filepath.Join(f.dir, room+".json")
+}
+
+func (f *FileStore) Load(room string) (game.State, error) {
+    var state game.State
+    data, err := os.ReadFile(f.path(room))
+    if errors.Is(err, os.ErrNotExist) {
+        return state, ErrStateNotFound
+    }
+    if err != nil {
+        return state, err
+    }
+    err = json.Unmarshal(data, &state)
+    return state, err
+}
+
+// Save writes to a temp file and renames it so a crash never leaves a
+// half written state behind
+func (f *FileStore) Save(room string, state game.State) error {
+    data, err := json.Marshal(state)
+    if err != nil {
+        return err
+    }
+    tmp := f.path(room) + ".tmp"
+    if err := os.WriteFile(tmp, data, 0o644); err != nil {
+        return err
+    }
+    return os.Rename(tmp, f.path(room))
+}
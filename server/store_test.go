@@ -0,0 +1,116 @@
+package main
+
+import (
+    "errors"
+    "sync/atomic"
+    "testing"
+
+    "github.com/ThePrimeagen/twitch-ext-pong/server/game"
+)
+
+func sampleState() game.State {
+    c := game.DefaultCanvas
+    return game.State{
+        LeftPaddle:  game.CenteredPaddle(c, "left", game.PaddleHeight),
+        RightPaddle: game.PaddlePosition{Side: "right", Y: 40, Height: 150},
+        LeftTarget:  250,
+        RightTarget: 40,
+        Balls:       []game.Ball{{ID: 1, X: 100, Y: 200, VX: -300, VY: 12.5}},
+        LeftScore:   4,
+        RightScore:  7,
+    }
+}
+
+func TestStoreRoundTrip(t *testing.T) {
+    files, err := NewFileStore(t.TempDir())
+    if err != nil {
+        t.Fatalf("NewFileStore: %v", err)
+    }
+    for name, store := range map[string]StateStore{
+        "memory": NewMemoryStore(),
+        "file":   files,
+    } {
+        t.Run(name, func(t *testing.T) {
+            if _, err := store.Load("room"); !errors.Is(err, ErrStateNotFound) {
+                t.Fatalf("Load before Save = %v, want ErrStateNotFound", err)
+            }
+            want := sampleState()
+            if err := store.Save("room", want); err != nil {
+                t.Fatalf("Save: %v", err)
+            }
+            got, err := store.Load("room")
+            if err != nil {
+                t.Fatalf("Load: %v", err)
+            }
+            if !got.Equal(want) {
+                t.Fatalf("Load = %+v, want %+v", got, want)
+            }
+            // Saving again replaces the old state
+            want.LeftScore++
+            if err := store.Save("room", want); err != nil {
+                t.Fatalf("Save: %v", err)
+            }
+            if got, _ := store.Load("room"); got.LeftScore != want.LeftScore {
+                t.Fatalf("left score = %d after second save, want %d", got.LeftScore, want.LeftScore)
+            }
+        })
+    }
+}
+
+func TestRoomRestoresSavedState(t *testing.T) {
+    store := NewMemoryStore()
+    saved := sampleState()
+    store.Save("restored", saved)
+
+    r := NewRoom(NewServer(DefaultConfig(), store), "restored")
+    if r.gameState.LeftScore != saved.LeftScore || r.gameState.RightScore != saved.RightScore {
+        t.Fatalf("score = %d-%d, want %d-%d", r.gameState.LeftScore, r.gameState.RightScore, saved.LeftScore, saved.RightScore)
+    }
+    if r.gameState.RightPaddle != saved.RightPaddle {
+        t.Fatalf("right paddle = %+v, want %+v", r.gameState.RightPaddle, saved.RightPaddle)
+    }
+}
+
+// A MemoryStore that counts its saves
+type countingStore struct {
+    *MemoryStore
+    saves atomic.Int64
+}
+
+func (s *countingStore) Save(room string, state game.State) error {
+    s.saves.Add(1)
+    return s.MemoryStore.Save(room, state)
+}
+
+func TestIdleRoomNotSaved(t *testing.T) {
+    cfg := testConfig()
+    cfg.InputWhilePaused = true
+    store := &countingStore{MemoryStore: NewMemoryStore()}
+    r := NewRoom(NewServer(cfg, store), "idle")
+    r.setPaused(true)
+    r.saveState()
+    if n := store.saves.Load(); n != 1 {
+        t.Fatalf("saves after pausing = %d, want 1", n)
+    }
+
+    // Paused with input allowed the loop keeps ticking, but nobody moves
+    for i := 0; i < 10; i++ {
+        if _, _, _, ok := r.advance(1.0 / 60); !ok {
+            t.Fatalf("advance: room frozen")
+        }
+        r.saveState()
+    }
+    if n := store.saves.Load(); n != 1 {
+        t.Fatalf("saves while idle = %d, want 1", n)
+    }
+
+    // A paddle moving is worth saving again
+    r.Lock()
+    r.gameState.LeftTarget = 0
+    r.Unlock()
+    r.advance(1.0 / 60)
+    r.saveState()
+    if n := store.saves.Load(); n != 2 {
+        t.Fatalf("saves after a paddle moved = %d, want 2", n)
+    }
+}
@@ -0,0 +1,302 @@
+package main
+
+import (
+    "sort"
+    "time"
+
+    "golang.org/x/exp/slog"
+)
+
+// Controlling players allowed per team unless configured otherwise
+const DefaultMaxPlayersPerTeam = 1
+
+// With auto balance on, a disconnect that leaves one team more than this
+// many players ahead moves a player over, unless configured otherwise
+const DefaultAutoBalanceThreshold = 1
+
+// Number of players controlling team's paddle. Caller must hold the lock.
+func (r *Room) controllers(team string) int {
+    n := 0
+    for client := range r.connections {
+        if client.role == RolePlayer && client.team == team {
+            n++
+        }
+    }
+    return n
+}
+
+// Who controls each paddle, longest playing first. Caller must hold the
+// lock.
+func (r *Room) players() Players {
+    var left, right []*Client
+    for client := range r.connections {
+        if client.role != RolePlayer {
+            continue
+        }
+        switch client.team {
+        case "left":
+            left = append(left, client)
+        case "right":
+            right = append(right, client)
+        }
+    }
+    return Players{Left: playerNames(left), Right: playerNames(right)}
+}
+
+// Display names of clients in the order they were put on their team
+func playerNames(clients []*Client) []string {
+    sort.Slice(clients, func(i, j int) bool {
+        return clients[i].assignedAt.Before(clients[j].assignedAt)
+    })
+    names := make([]string, 0, len(clients))
+    for _, client := range clients {
+        names = append(names, client.displayName())
+    }
+    return names
+}
+
+// 1 based position of client in its team's waiting list, 0 if it isn't
+// waiting. Caller must hold the lock.
+func (r *Room) queuePosition(client *Client) int {
+    for i, waiter := range r.waiting[client.team] {
+        if waiter == client {
+            return i + 1
+        }
+    }
+    return 0
+}
+
+// Change a client's role keeping the metrics in sync. Caller must hold the
+// lock.
+func (r *Room) setRoleLocked(client *Client, role ClientRole) {
+    if client.role == role {
+        return
+    }
+    r.server.countRole(client.role, -1)
+    r.server.countRole(role, 1)
+    client.role = role
+    // The AFK clock starts when the client gets a paddle
+    client.lastInput = time.Now()
+    r.hub.SetRole(client, role)
+    r.playersDirty.Store(true)
+}
+
+// Take client off its waiting list and away from its paddle. Returns the
+// waiter promoted into the freed slot, if any. Caller must hold the lock.
+func (r *Room) vacate(client *Client) *Client {
+    team := client.team
+    controlling := client.role == RolePlayer && team != ""
+
+    if pos := r.queuePosition(client); pos > 0 {
+        queue := r.waiting[team]
+        r.waiting[team] = append(queue[:pos-1:pos-1], queue[pos:]...)
+    }
+    client.team = ""
+    r.playersDirty.Store(true)
+
+    if !controlling {
+        return nil
+    }
+    return r.promote(team)
+}
+
+// Move the first waiter on team into a free slot. Returns the promoted
+// client, if any. Caller must hold the lock.
+func (r *Room) promote(team string) *Client {
+    queue := r.waiting[team]
+    if len(queue) == 0 || r.controllers(team) >= r.cfg.MaxPlayersPerTeam {
+        return nil
+    }
+    next := queue[0]
+    r.waiting[team] = queue[1:]
+    // The AFK timeout starts now, not when it started waiting
+    next.lastInput = time.Now()
+    r.setRoleLocked(next, RolePlayer)
+    return next
+}
+
+// assignTeam puts client in control of team's paddle if there is a free
+// slot, otherwise it spectates on team's waiting list. With auto balance on
+// the smaller team wins over the one asked for. Returns the team assigned,
+// the client's queue position (0 when it controls the paddle) and whoever
+// was promoted into the slot client left behind.
+func (r *Room) assignTeam(client *Client, team string) (assigned string, position int, promoted *Client) {
+    r.Lock()
+    defer r.Unlock()
+
+    if r.cfg.AutoBalance {
+        team = r.smallerTeam(client, team)
+    }
+    position, promoted = r.assignTeamLocked(client, team)
+    return team, position, promoted
+}
+
+// assignTeam without the locking or balancing. Caller must hold the lock.
+func (r *Room) assignTeamLocked(client *Client, team string) (position int, promoted *Client) {
+    // Already playing or waiting for this team
+    if client.team == team {
+        if client.role == RolePlayer {
+            return 0, nil
+        }
+        if pos := r.queuePosition(client); pos > 0 {
+            return pos, nil
+        }
+    }
+
+    promoted = r.vacate(client)
+    // Counted before client is on the team, it mustn't take its own slot
+    free := r.controllers(team) < r.cfg.MaxPlayersPerTeam
+    client.team = team
+    client.assignedAt = time.Now()
+    client.lastInput = client.assignedAt
+    r.playersDirty.Store(true)
+
+    if free {
+        r.setRoleLocked(client, RolePlayer)
+        return 0, promoted
+    }
+    r.setRoleLocked(client, RoleSpectator)
+    r.waiting[team] = append(r.waiting[team], client)
+    return len(r.waiting[team]), promoted
+}
+
+// setRole switches a client between playing and spectating. Spectating
+// gives up the client's team, playing keeps it waiting if it is queued.
+// Returns whoever was promoted into a freed slot, if any.
+func (r *Room) setRole(client *Client, role ClientRole) *Client {
+    r.Lock()
+    defer r.Unlock()
+
+    if role == RoleSpectator {
+        promoted := r.vacate(client)
+        r.setRoleLocked(client, RoleSpectator)
+        return promoted
+    }
+    if r.queuePosition(client) == 0 {
+        r.setRoleLocked(client, RolePlayer)
+    }
+    return nil
+}
+
+// Tell a promoted waiter it controls its team's paddle now
+func (r *Room) notifyPromoted(client *Client) {
+    if client == nil {
+        return
+    }
+    r.RLock()
+    team := client.team
+    r.RUnlock()
+    client.Send(TypeTeamAssign, TeamAssignment{Team: team})
+}
+
+// Team with fewer players not counting client, preferred on a tie. Caller
+// must hold the lock.
+func (r *Room) smallerTeam(client *Client, preferred string) string {
+    left, right := r.controllers("left"), r.controllers("right")
+    if client.role == RolePlayer {
+        switch client.team {
+        case "left":
+            left--
+        case "right":
+            right--
+        }
+    }
+    switch {
+    case left < right:
+        return "left"
+    case right < left:
+        return "right"
+    }
+    return preferred
+}
+
+// Move the most recently assigned player off the bigger team when the gap
+// grew past the threshold. Returns the clients whose team or role changed.
+// Caller must hold the lock.
+func (r *Room) rebalance() []*Client {
+    if !r.cfg.AutoBalance {
+        return nil
+    }
+    left, right := r.controllers("left"), r.controllers("right")
+    bigger, smaller := "left", "right"
+    if right > left {
+        bigger, smaller = "right", "left"
+    }
+    if abs(left-right) <= r.cfg.AutoBalanceThreshold {
+        return nil
+    }
+
+    var newest *Client
+    for client := range r.connections {
+        if client.role != RolePlayer || client.team != bigger {
+            continue
+        }
+        if newest == nil || client.assignedAt.After(newest.assignedAt) {
+            newest = client
+        }
+    }
+    if newest == nil {
+        return nil
+    }
+
+    changed := []*Client{newest}
+    _, promoted := r.assignTeamLocked(newest, smaller)
+    if promoted != nil {
+        changed = append(changed, promoted)
+    }
+    return changed
+}
+
+func abs(n int) int {
+    if n < 0 {
+        return -n
+    }
+    return n
+}
+
+// Demote controlling players that sent no paddle update for the AFK
+// timeout, handing their paddles to whoever is waiting. Called from the
+// game loop.
+func (r *Room) demoteAFK(now time.Time) {
+    timeout := r.cfg.AFKTimeout
+    if timeout == 0 {
+        return
+    }
+
+    afk, promoted := r.collectAFK(now, timeout)
+
+    for _, client := range afk {
+        slog.Info("Demoted AFK player",
+            "afk_timeout", timeout.String(),
+            "addr", client.addr,
+            "conn_id", client.id,
+            "timestamp", time.Now().Format(time.RFC3339))
+        client.Send(TypeJoin, Join{Role: string(RoleSpectator), Reason: "afk"})
+    }
+    for _, client := range promoted {
+        r.notifyPromoted(client)
+    }
+}
+
+// Make every player idle for timeout a spectator. Returns the demoted and
+// the promoted clients to notify once unlocked. The deferred unlock keeps
+// a panic from leaving the room locked.
+func (r *Room) collectAFK(now time.Time, timeout time.Duration) (afk, promoted []*Client) {
+    r.Lock()
+    defer r.Unlock()
+    for client := range r.connections {
+        if client.role != RolePlayer || client.team == "" || now.Sub(client.lastInput) < timeout {
+            continue
+        }
+        afk = append(afk, client)
+    }
+    // Demoted only once all are found, a waiter promoted here hasn't had
+    // the chance to send anything yet
+    for _, client := range afk {
+        if p := r.vacate(client); p != nil {
+            promoted = append(promoted, p)
+        }
+        r.setRoleLocked(client, RoleSpectator)
+    }
+    return afk, promoted
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+    "encoding/json"
+    "testing"
+    "time"
+)
+
+func TestAssignTeamFirstPlayerControls(t *testing.T) {
+    r := newTestRoom(t, DefaultConfig())
+    client := joinTestClient(t, r, RolePlayer)
+
+    team, position, _ := r.assignTeam(client, "left")
+    if team != "left" || position != 0 {
+        t.Fatalf("assignTeam = %q at %d, want left at 0", team, position)
+    }
+    if client.role != RolePlayer {
+        t.Fatalf("role = %q, want %q", client.role, RolePlayer)
+    }
+}
+
+func TestTeamCapPromotesOnLeave(t *testing.T) {
+    r := newTestRoom(t, DefaultConfig())
+    first := joinTestClient(t, r, RolePlayer)
+    second := joinTestClient(t, r, RolePlayer)
+
+    r.assignTeam(first, "left")
+    if _, position, _ := r.assignTeam(second, "left"); position != 1 {
+        t.Fatalf("second player queued at %d, want 1", position)
+    }
+    if second.role != RoleSpectator {
+        t.Fatalf("queued role = %q, want %q", second.role, RoleSpectator)
+    }
+
+    r.leave(first)
+
+    r.RLock()
+    role, team := second.role, second.team
+    r.RUnlock()
+    if role != RolePlayer || team != "left" {
+        t.Fatalf("after leave got %q on %q, want player on left", role, team)
+    }
+    msg, ok := queued(second, TypeTeamAssign)
+    if !ok {
+        t.Fatalf("promoted player got no team_assign")
+    }
+    var got TeamAssignment
+    if err := json.Unmarshal(msg.Payload, &got); err != nil || got.Team != "left" {
+        t.Fatalf("team_assign = %s, want left", msg.Payload)
+    }
+}
+
+func TestAutoBalanceAlternatesJoins(t *testing.T) {
+    cfg := DefaultConfig()
+    cfg.AutoBalance = true
+    cfg.MaxPlayersPerTeam = 4
+    r := newTestRoom(t, cfg)
+
+    // Everyone asks for left, balancing hands out the smaller team
+    for i, want := range []string{"left", "right", "left", "right", "left", "right"} {
+        client := joinTestClient(t, r, RolePlayer)
+        team, position, _ := r.assignTeam(client, "left")
+        if team != want || position != 0 {
+            t.Fatalf("join %d got %q at %d, want %q at 0", i, team, position, want)
+        }
+    }
+}
+
+func TestPlayersShowsControllerSide(t *testing.T) {
+    cfg := testConfig()
+    cfg.ExtensionSecret = testSecret
+    ts := newTestServer(t, cfg)
+    watcher := dialAs(t, ts, "sides", "U1", "viewer")
+    player := dialAs(t, ts, "sides", "U7", "viewer")
+
+    player.send(TypeTeamAssign, TeamAssignment{Team: "right"})
+    player.expect(TypeTeamAssign)
+
+    // Sent with the player count, so within a second or so
+    msg := watcher.expectMatch(TypePlayers, func(msg Message) bool {
+        return len(decode[Players](t, msg).Right) > 0
+    })
+    players := decode[Players](t, msg)
+    if len(players.Left) != 0 || len(players.Right) != 1 || players.Right[0] != "U7" {
+        t.Fatalf("players = %+v, want U7 alone on the right", players)
+    }
+}
+
+func TestAFKPlayerDemotedForWaiter(t *testing.T) {
+    cfg := DefaultConfig()
+    cfg.AFKTimeout = 30 * time.Second
+    r := newTestRoom(t, cfg)
+    idle := joinTestClient(t, r, RolePlayer)
+    waiter := joinTestClient(t, r, RolePlayer)
+    r.assignTeam(idle, "left")
+    r.assignTeam(waiter, "left")
+    start := idle.lastInput
+
+    r.demoteAFK(start.Add(29 * time.Second))
+    if idle.role != RolePlayer || waiter.role != RoleSpectator {
+        t.Fatalf("roles %q and %q before the timeout, want nothing to change", idle.role, waiter.role)
+    }
+
+    r.demoteAFK(start.Add(31 * time.Second))
+    r.RLock()
+    idleRole, waiterRole, waiterTeam := idle.role, waiter.role, waiter.team
+    r.RUnlock()
+    if idleRole != RoleSpectator {
+        t.Fatalf("afk player is %q, want %q", idleRole, RoleSpectator)
+    }
+    if waiterRole != RolePlayer || waiterTeam != "left" {
+        t.Fatalf("waiter is %q on %q, want player on left", waiterRole, waiterTeam)
+    }
+
+    msg, ok := queued(idle, TypeJoin)
+    if !ok {
+        t.Fatalf("afk player wasn't told")
+    }
+    if join := decode[Join](t, msg); join.Role != string(RoleSpectator) || join.Reason != "afk" {
+        t.Fatalf("join = %+v, want spectator for afk", join)
+    }
+    if _, ok := queued(waiter, TypeTeamAssign); !ok {
+        t.Fatalf("promoted waiter got no team_assign")
+    }
+
+    // Its own timeout only starts with the promotion
+    r.demoteAFK(time.Now().Add(time.Second))
+    r.RLock()
+    defer r.RUnlock()
+    if waiter.role != RolePlayer {
+        t.Fatalf("promoted waiter demoted on the next sweep")
+    }
+}
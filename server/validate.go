@@ -0,0 +1,203 @@
+package main
+
+import (
+    "encoding/json"
+    "errors"
+    "fmt"
+    "io"
+    "net/http"
+    "strings"
+
+    "github.com/ThePrimeagen/twitch-ext-pong/server/game"
+)
+
+// Decoders for client payloads. handleWS and /validate both go through
+// these so the dry run can't drift from what the game accepts. Each returns
+// the error code to send back when the payload is rejected.
+
+// paddleSizes gives the height of a side's paddle. Players can resize
+// them, so checks for a room ask the room.
+type paddleSizes func(side string) float64
+
+// fixedPaddles sizes every paddle height, for checks without a room
+func fixedPaddles(height float64) paddleSizes {
+    return func(string) float64 {
+        return height
+    }
+}
+
+// The whole paddle has to fit on the canvas. In clamp mode an off canvas Y
+// is pulled back onto it instead of rejected.
+func decodePaddleUpdate(payload json.RawMessage, height float64, paddles paddleSizes, bounds BoundsMode) (game.PaddlePosition, ErrorCode, error) {
+    var pos game.PaddlePosition
+    if err := json.Unmarshal(payload, &pos); err != nil {
+        return pos, ErrCodeBadMessage, err
+    }
+    if err := game.ValidateSide(pos.Side); err != nil {
+        return pos, ErrCodeInvalidSide, err
+    }
+    pos.Side = strings.ToLower(pos.Side)
+    size := paddles(pos.Side)
+    if err := pos.Validate(height, size); err != nil {
+        if bounds != BoundsClamp {
+            return pos, ErrCodeInvalidPosition, err
+        }
+        pos.Y = game.ClampY(pos.Y, height, size)
+    }
+    return pos, "", nil
+}
+
+func decodeTeamAssign(payload json.RawMessage) (TeamAssignment, ErrorCode, error) {
+    var assignment TeamAssignment
+    if err := json.Unmarshal(payload, &assignment); err != nil {
+        return assignment, ErrCodeBadMessage, err
+    }
+    if err := assignment.Validate(); err != nil {
+        return assignment, ErrCodeBadTeam, err
+    }
+    return assignment, "", nil
+}
+
+func decodeJoin(payload json.RawMessage) (Join, ErrorCode, error) {
+    var join Join
+    if err := json.Unmarshal(payload, &join); err != nil {
+        return join, ErrCodeBadMessage, err
+    }
+    if err := join.Validate(); err != nil {
+        return join, ErrCodeBadRole, err
+    }
+    return join, "", nil
+}
+
+func decodePaddleSize(payload json.RawMessage) (PaddleSize, ErrorCode, error) {
+    var size PaddleSize
+    if err := json.Unmarshal(payload, &size); err != nil {
+        return size, ErrCodeBadMessage, err
+    }
+    if err := size.Validate(); err != nil {
+        return size, ErrCodeInvalidSide, err
+    }
+    size.Side = strings.ToLower(size.Side)
+    return size, "", nil
+}
+
+func decodeChat(payload json.RawMessage) (Chat, ErrorCode, error) {
+    var chat Chat
+    if err := json.Unmarshal(payload, &chat); err != nil {
+        return chat, ErrCodeBadMessage, err
+    }
+    if sanitizeChat(chat.Text) == "" {
+        return chat, ErrCodeBadMessage, errors.New("empty chat message")
+    }
+    return chat, "", nil
+}
+
+func decodeLatencyProbe(payload json.RawMessage) (LatencyProbe, ErrorCode, error) {
+    var probe LatencyProbe
+    if err := json.Unmarshal(payload, &probe); err != nil {
+        return probe, ErrCodeBadMessage, err
+    }
+    if len(probe.ClientTime) == 0 {
+        return probe, ErrCodeBadMessage, errors.New("latency_probe needs a clientTime")
+    }
+    return probe, "", nil
+}
+
+func decodeKick(payload json.RawMessage) (Kick, ErrorCode, error) {
+    var kick Kick
+    if err := json.Unmarshal(payload, &kick); err != nil {
+        return kick, ErrCodeBadMessage, err
+    }
+    if err := kick.Validate(); err != nil {
+        return kick, ErrCodeBadMessage, err
+    }
+    return kick, "", nil
+}
+
+func decodeBan(payload json.RawMessage) (Ban, ErrorCode, error) {
+    var ban Ban
+    if err := json.Unmarshal(payload, &ban); err != nil {
+        return ban, ErrCodeBadMessage, err
+    }
+    if err := ban.Validate(); err != nil {
+        return ban, ErrCodeBadMessage, err
+    }
+    return ban, "", nil
+}
+
+// validateMessage runs the checks a client message goes through before the
+// game acts on it. It doesn't know who sent it, so permission, team and
+// rate limit checks are left out.
+func validateMessage(msg Message, cfg Config, paddles paddleSizes) (ErrorCode, error) {
+    var code ErrorCode
+    var err error
+    switch msg.Type {
+    case TypePaddleUpdate:
+        _, code, err = decodePaddleUpdate(msg.Payload, cfg.Canvas.Height, paddles, cfg.BoundsMode)
+    case TypeTeamAssign:
+        _, code, err = decodeTeamAssign(msg.Payload)
+    case TypeJoin:
+        _, code, err = decodeJoin(msg.Payload)
+    case TypeSetPaddleSize:
+        _, code, err = decodePaddleSize(msg.Payload)
+    case TypeChat:
+        _, code, err = decodeChat(msg.Payload)
+    case TypeLatencyProbe:
+        _, code, err = decodeLatencyProbe(msg.Payload)
+    case TypeKick:
+        _, code, err = decodeKick(msg.Payload)
+    case TypeBan, TypeUnban:
+        _, code, err = decodeBan(msg.Payload)
+    case TypeResetGame, TypeSpawnBall, TypePause, TypeResume, TypePing, TypeRequestState:
+        // No payload to check
+    default:
+        return ErrCodeUnknownType, fmt.Errorf("unknown message type %q", msg.Type)
+    }
+    return code, err
+}
+
+// parseMessage turns a frame from a client into a message that passed
+// validateMessage. It is everything between the socket and the handlers
+// that doesn't need a connection, so it can be exercised on its own.
+func parseMessage(data []byte, codec Codec, cfg Config, paddles paddleSizes) (Message, ErrorCode, error) {
+    msg, err := codec.Decode(data)
+    if err != nil {
+        return msg, ErrCodeBadMessage, err
+    }
+    if code, err := validateMessage(msg, cfg, paddles); err != nil {
+        return msg, code, err
+    }
+    return msg, "", nil
+}
+
+// ValidateResult is the body returned by /validate
+type ValidateResult struct {
+    Valid bool      `json:"valid"`
+    Code  ErrorCode `json:"code,omitempty"`
+    Error string    `json:"error,omitempty"`
+}
+
+// handleValidate dry runs a client message given as the POST body, without
+// touching any game
+func (s *Server) handleValidate(w http.ResponseWriter, r *http.Request) {
+    if r.Method != http.MethodPost {
+        http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+        return
+    }
+
+    result := ValidateResult{Valid: true}
+    data, err := io.ReadAll(http.MaxBytesReader(w, r.Body, int64(s.cfg.MaxMessageSize)))
+    if err != nil {
+        result = ValidateResult{Code: ErrCodeBadMessage, Error: err.Error()}
+    } else if _, code, err := parseMessage(data, JSONCodec{}, s.cfg, fixedPaddles(s.cfg.PaddleHeight)); err != nil {
+        result = ValidateResult{Code: code, Error: err.Error()}
+    }
+
+    status := http.StatusOK
+    if !result.Valid {
+        status = http.StatusBadRequest
+    }
+    w.Header().Set("Content-Type", "application/json")
+    w.WriteHeader(status)
+    json.NewEncoder(w).Encode(result)
+}
@@ -0,0 +1,215 @@
+package main
+
+import (
+    "encoding/json"
+    "net/http"
+    "strings"
+    "testing"
+
+    "github.com/ThePrimeagen/twitch-ext-pong/server/game"
+)
+
+// A frame as a client would send it
+func frame(t testing.TB, typ MessageType, v any) []byte {
+    t.Helper()
+    msg, err := NewMessage(typ, v)
+    if err != nil {
+        t.Fatalf("build %s: %v", typ, err)
+    }
+    data, err := JSONCodec{}.Encode(msg)
+    if err != nil {
+        t.Fatalf("encode %s: %v", typ, err)
+    }
+    return data
+}
+
+func TestPaddleUpdateCustomHeight(t *testing.T) {
+    t.Setenv("CANVAS_HEIGHT", "1000")
+    cfg, err := LoadConfig()
+    if err != nil {
+        t.Fatalf("LoadConfig: %v", err)
+    }
+    // Lowest a paddle can go and still be fully on the canvas
+    bottom := 1000 - cfg.PaddleHeight
+    for _, tc := range []struct {
+        y    float64
+        code ErrorCode
+    }{
+        {800, ""},
+        {bottom, ""},
+        {bottom + 1, ErrCodeInvalidPosition},
+        {1000, ErrCodeInvalidPosition},
+        {-1, ErrCodeInvalidPosition},
+    } {
+        data := frame(t, TypePaddleUpdate, map[string]any{"side": "left", "y": tc.y})
+        if _, code, _ := parseMessage(data, JSONCodec{}, cfg, fixedPaddles(cfg.PaddleHeight)); code != tc.code {
+            t.Errorf("y %v on a 1000 high canvas: code %q, want %q", tc.y, code, tc.code)
+        }
+    }
+}
+
+func TestPaddleUpdateSide(t *testing.T) {
+    for _, tc := range []struct {
+        side string
+        want string
+        code ErrorCode
+    }{
+        {"LEFT", "left", ""},
+        {"middle", "", ErrCodeInvalidSide},
+        {"", "", ErrCodeInvalidSide},
+    } {
+        data := frame(t, TypePaddleUpdate, map[string]any{"side": tc.side, "y": 300})
+        msg, code, _ := parseMessage(data, JSONCodec{}, DefaultConfig(), fixedPaddles(game.PaddleHeight))
+        if code != tc.code {
+            t.Errorf("side %q: code %q, want %q", tc.side, code, tc.code)
+            continue
+        }
+        if code != "" {
+            continue
+        }
+        pos, _, _ := decodePaddleUpdate(msg.Payload, DefaultConfig().Canvas.Height, fixedPaddles(game.PaddleHeight), BoundsReject)
+        if pos.Side != tc.want {
+            t.Errorf("side %q normalized to %q, want %q", tc.side, pos.Side, tc.want)
+        }
+    }
+}
+
+func TestValidateEndpoint(t *testing.T) {
+    ts := newTestServer(t, testConfig())
+
+    for _, tc := range []struct {
+        name string
+        body string
+        want ValidateResult
+    }{
+        {"paddle update", `{"type":"paddle_update","payload":{"side":"left","y":300}}`, ValidateResult{Valid: true}},
+        {"no payload needed", `{"type":"reset_game"}`, ValidateResult{Valid: true}},
+        {"off the canvas", `{"type":"paddle_update","payload":{"side":"left","y":601}}`, ValidateResult{Code: ErrCodeInvalidPosition}},
+        {"hanging off the bottom", `{"type":"paddle_update","payload":{"side":"left","y":590}}`, ValidateResult{Code: ErrCodeInvalidPosition}},
+        {"bad side", `{"type":"paddle_update","payload":{"side":"top","y":300}}`, ValidateResult{Code: ErrCodeInvalidSide}},
+        {"bad team", `{"type":"team_assign","payload":{"team":"blue"}}`, ValidateResult{Code: ErrCodeBadTeam}},
+        {"unknown type", `{"type":"teleport"}`, ValidateResult{Code: ErrCodeUnknownType}},
+        {"not json", `{"type":`, ValidateResult{Code: ErrCodeBadMessage}},
+    } {
+        resp, err := http.Post(ts.http.URL+"/validate", "application/json", strings.NewReader(tc.body))
+        if err != nil {
+            t.Fatalf("%s: %v", tc.name, err)
+        }
+        var got ValidateResult
+        err = json.NewDecoder(resp.Body).Decode(&got)
+        resp.Body.Close()
+        if err != nil {
+            t.Fatalf("%s: decode: %v", tc.name, err)
+        }
+
+        wantStatus := http.StatusOK
+        if !tc.want.Valid {
+            wantStatus = http.StatusBadRequest
+        }
+        if resp.StatusCode != wantStatus || got.Valid != tc.want.Valid || got.Code != tc.want.Code {
+            t.Errorf("%s: %d %+v, want %d %+v", tc.name, resp.StatusCode, got, wantStatus, tc.want)
+        }
+        if !got.Valid && got.Error == "" {
+            t.Errorf("%s: rejected without an error", tc.name)
+        }
+    }
+
+    if resp := ts.get(t, "/validate"); resp.StatusCode != http.StatusMethodNotAllowed {
+        t.Fatalf("GET status = %d, want %d", resp.StatusCode, http.StatusMethodNotAllowed)
+    }
+}
+
+// Feeds arbitrary frames through the same parsing a connection does. It
+// must never panic, and a paddle update it lets through must be on the
+// canvas, on a real side.
+func FuzzHandleMessage(f *testing.F) {
+    cfg := DefaultConfig()
+    for _, data := range [][]byte{
+        frame(f, TypePaddleUpdate, map[string]any{"side": "left", "y": 300}),
+        frame(f, TypePaddleUpdate, map[string]any{"side": "RIGHT", "y": 0, "seq": 4, "ts": 17}),
+        frame(f, TypeTeamAssign, TeamAssignment{Team: "left"}),
+        frame(f, TypeChat, Chat{Text: "gg"}),
+        frame(f, TypeKick, Kick{UserID: "U1", Ban: true}),
+        frame(f, TypePing, nil),
+        []byte(`{"type":"paddle_update","payload":{"side":"left","y":601}}`),
+        []byte(`{"type":"paddle_update","payload":{"side":"left","y":-1e308}}`),
+        []byte(`{"type":"paddle_update","payload":{"side":"top","y":300}}`),
+        []byte(`{"type":"paddle_update","payload":"left"}`),
+        []byte(`{"type":"paddle_update"}`),
+        []byte(`{"type":"ban","payload":{"userId":"U1","ttlSeconds":-5}}`),
+        []byte(`{"type":"teleport"}`),
+        []byte(`{"type":`),
+        []byte(`null`),
+        {},
+        {0x82, 0xa4, 't', 'y', 'p', 'e'},
+    } {
+        f.Add(data)
+    }
+
+    f.Fuzz(func(t *testing.T, data []byte) {
+        for _, codec := range []Codec{JSONCodec{}, MsgPackCodec{}} {
+            msg, code, err := parseMessage(data, codec, cfg, fixedPaddles(cfg.PaddleHeight))
+            if (err == nil) != (code == "") {
+                t.Fatalf("%T: code %q with error %v", codec, code, err)
+            }
+            if err != nil || msg.Type != TypePaddleUpdate {
+                continue
+            }
+            pos, _, err := decodePaddleUpdate(msg.Payload, cfg.Canvas.Height, fixedPaddles(cfg.PaddleHeight), cfg.BoundsMode)
+            if err != nil {
+                t.Fatalf("%T: accepted paddle update fails to decode: %v", codec, err)
+            }
+            if !(pos.Y >= 0 && pos.Y <= cfg.Canvas.Height-cfg.PaddleHeight) {
+                t.Fatalf("%T: accepted paddle y %v hangs off a %v high canvas", codec, pos.Y, cfg.Canvas.Height)
+            }
+            if pos.Side != "left" && pos.Side != "right" {
+                t.Fatalf("%T: accepted paddle side %q", codec, pos.Side)
+            }
+        }
+    })
+}
+
+func TestPaddleUpdateBoundsModes(t *testing.T) {
+    const height = 600
+    const paddle = 100
+    // Lowest a paddle can go and still be fully on the canvas
+    const bottom = height - paddle
+    for _, tc := range []struct {
+        y      float64
+        mode   BoundsMode
+        wantY  float64
+        wantOK bool
+    }{
+        {0, BoundsReject, 0, true},
+        {bottom, BoundsReject, bottom, true},
+        {-0.5, BoundsReject, 0, false},
+        {bottom + 1, BoundsReject, 0, false},
+        {height, BoundsReject, 0, false},
+        {0, BoundsClamp, 0, true},
+        {bottom, BoundsClamp, bottom, true},
+        {-0.5, BoundsClamp, 0, true},
+        {-1e9, BoundsClamp, 0, true},
+        {bottom + 1, BoundsClamp, bottom, true},
+        {height, BoundsClamp, bottom, true},
+        {1e9, BoundsClamp, bottom, true},
+    } {
+        payload, err := json.Marshal(game.PaddlePosition{Side: "left", Y: tc.y})
+        if err != nil {
+            t.Fatalf("encode: %v", err)
+        }
+        pos, code, err := decodePaddleUpdate(payload, height, fixedPaddles(paddle), tc.mode)
+        if (err == nil) != tc.wantOK {
+            t.Errorf("%s y %v: error %v, want ok %v", tc.mode, tc.y, err, tc.wantOK)
+            continue
+        }
+        if !tc.wantOK {
+            if code != ErrCodeInvalidPosition {
+                t.Errorf("%s y %v: code %q, want %q", tc.mode, tc.y, code, ErrCodeInvalidPosition)
+            }
+            continue
+        }
+        if pos.Y != tc.wantY {
+            t.Errorf("%s y %v: got y %v, want %v", tc.mode, tc.y, pos.Y, tc.wantY)
+        }
+    }
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+    "bytes"
+    "encoding/json"
+    "fmt"
+    "net/http"
+    "time"
+
+    "golang.org/x/exp/slog"
+)
+
+// Webhook delivery settings
+const (
+    // Events waiting for delivery before new ones are dropped
+    WebhookQueueSize = 256
+    // How long a single POST may take
+    WebhookTimeout = 5 * time.Second
+    // Attempts per event before it is dropped
+    WebhookAttempts = 3
+    // Wait before the first retry, doubled for each one after
+    WebhookRetryDelay = 500 * time.Millisecond
+)
+
+// Kinds of webhook events
+const (
+    WebhookConnectionOpen  = "connection_open"
+    WebhookConnectionClose = "connection_close"
+)
+
+// WebhookEvent is the JSON body POSTed to the webhook
+type WebhookEvent struct {
+    Event        string    `json:"event"`
+    Room         string    `json:"room"`
+    UserID       string    `json:"user_id,omitempty"`
+    ConnectionID string    `json:"connection_id"`
+    Timestamp    time.Time `json:"timestamp"`
+}
+
+// Webhook POSTs connection events to an external URL from its own
+// goroutine so a slow endpoint never holds up a connection. A nil Webhook
+// does nothing.
+type Webhook struct {
+    url    string
+    client *http.Client
+    queue  chan WebhookEvent
+}
+
+// NewWebhook delivers to url, nil when url is empty
+func NewWebhook(url string) *Webhook {
+    if url == "" {
+        return nil
+    }
+    return &Webhook{
+        url:    url,
+        client: &http.Client{Timeout: WebhookTimeout},
+        queue:  make(chan WebhookEvent, WebhookQueueSize),
+    }
+}
+
+// Send queues an event for client in channel without blocking, dropping it
+// when the queue is full
+func (w *Webhook) Send(event, channel string, client *Client) {
+    if w == nil {
+        return
+    }
+    e := WebhookEvent{
+        Event:        event,
+        Room:         channel,
+        UserID:       client.identity.OpaqueUserID,
+        ConnectionID: client.id,
+        Timestamp:    time.Now().UTC(),
+    }
+    select {
+    case w.queue <- e:
+    default:
+        slog.Warn("Dropping webhook event, queue full",
+            "event", event,
+            "channel", channel,
+            "queue_size", WebhookQueueSize,
+            "timestamp", time.Now().Format(time.RFC3339))
+    }
+}
+
+// run delivers queued events until done is closed
+func (w *Webhook) run(done <-chan struct{}) {
+    for {
+        select {
+        case <-done:
+            return
+        case e := <-w.queue:
+            w.deliver(e, done)
+        }
+    }
+}
+
+// POST e, retrying with backoff until it goes through, the attempts run
+// out or done is closed
+func (w *Webhook) deliver(e WebhookEvent, done <-chan struct{}) {
+    body, err := json.Marshal(e)
+    if err != nil {
+        slog.Error("Failed to build webhook body",
+            "error", err,
+            "event", e.Event,
+            "timestamp", time.Now().Format(time.RFC3339))
+        return
+    }
+
+    delay := WebhookRetryDelay
+    for attempt := 1; ; attempt++ {
+        err := w.post(body)
+        if err == nil {
+            return
+        }
+        if attempt == WebhookAttempts {
+            slog.Warn("Dropping webhook event after retries",
+                "error", err,
+                "event", e.Event,
+                "room", e.Room,
+                "attempts", attempt,
+                "timestamp", time.Now().Format(time.RFC3339))
+            return
+        }
+        select {
+        case <-done:
+            return
+        case <-time.After(delay):
+        }
+        delay *= 2
+    }
+}
+
+func (w *Webhook) post(body []byte) error {
+    resp, err := w.client.Post(w.url, "application/json", bytes.NewReader(body))
+    if err != nil {
+        return err
+    }
+    resp.Body.Close()
+    if resp.StatusCode >= 300 {
+        return fmt.Errorf("webhook answered %s", resp.Status)
+    }
+    return nil
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+    "encoding/json"
+    "net/http"
+    "net/http/httptest"
+    "testing"
+    "time"
+)
+
+func TestWebhookPayloads(t *testing.T) {
+    events := make(chan WebhookEvent, 2)
+    target := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        var e WebhookEvent
+        if r.Method != http.MethodPost || r.Header.Get("Content-Type") != "application/json" {
+            t.Errorf("webhook got %s with %q", r.Method, r.Header.Get("Content-Type"))
+        }
+        if err := json.NewDecoder(r.Body).Decode(&e); err != nil {
+            t.Errorf("webhook body: %v", err)
+        }
+        events <- e
+    }))
+    defer target.Close()
+
+    cfg := testConfig()
+    cfg.ExtensionSecret = testSecret
+    cfg.WebhookURL = target.URL
+    ts := newTestServer(t, cfg)
+    token := signTestJWT(t, TwitchClaims{ChannelID: "hooked", OpaqueUserID: "U2", Role: "viewer"})
+    c := ts.dial(t, "token="+token)
+    id := decode[InitialState](t, c.expect(TypeInitialState)).ConnectionID
+    c.conn.Close()
+
+    for _, want := range []string{WebhookConnectionOpen, WebhookConnectionClose} {
+        select {
+        case e := <-events:
+            if e.Event != want || e.Room != "hooked" || e.UserID != "U2" || e.ConnectionID != id {
+                t.Fatalf("webhook got %+v, want %s for U2 on %s in hooked", e, want, id)
+            }
+            if time.Since(e.Timestamp) > testTimeout {
+                t.Fatalf("webhook timestamp %v is stale", e.Timestamp)
+            }
+        case <-time.After(testTimeout):
+            t.Fatalf("no %s webhook within %s", want, testTimeout)
+        }
+    }
+}